@@ -0,0 +1,77 @@
+// adaptive.go: Contains the per-pixel statistics for adaptive sampling, and the heatmap of sample counts.
+
+package main
+
+import "image"
+
+// pixelStats tracks the running mean and variance of the luminance of the samples of a pixel (by Welford's method).
+type pixelStats struct {
+	n        int
+	mean, m2 entry // m2 is the sum of squared differences from the mean
+}
+
+// add a sample to the statistics
+func (s *pixelStats) add(v entry) {
+	s.n++
+	delta := v - s.mean
+	s.mean += delta / entry(s.n)
+	s.m2 += delta * (v - s.mean)
+}
+
+// the (unbiased) variance of the samples
+func (s *pixelStats) variance() entry {
+	if s.n < 2 {
+		return ZERO
+	}
+	return s.m2 / entry(s.n-1)
+}
+
+// the estimated standard error of the mean of the samples (infinite until there are two samples)
+func (s *pixelStats) standardError() entry {
+	if s.n < 2 {
+		return INF
+	}
+	return sqrt(s.variance() / entry(s.n))
+}
+
+// the (Rec. 709) luminance of a colour
+func luminance(c Vec3) entry {
+	return 0.2126*c[cX] + 0.7152*c[cY] + 0.0722*c[cZ]
+}
+
+// a colour ramp for t in [0,1]: from blue, through green, to red
+func heatColor(t entry) Vec3 {
+	t = maxEntry(ZERO, minEntry(t, ONE))
+	if t < 0.5 {
+		return Vec3{0, 2 * t, 1 - 2*t}
+	}
+	return Vec3{2*t - 1, 2 - 2*t, 0}
+}
+
+// SampleHeatmap creates an image of the number of samples of each pixel (in rows from the top-left):
+// from blue for the fewest samples, to red for the most (e.g. of RenderStats.SampleCounts).
+// The image is all blue if there is not one count for each pixel.
+func SampleHeatmap(counts []int, width, height int) *image.RGBA {
+	if len(counts) != width*height {
+		counts = make([]int, width*height)
+	}
+	minCount, maxCount := 0, 0
+	if len(counts) > 0 {
+		minCount, maxCount = counts[0], counts[0]
+	}
+	for _, c := range counts {
+		minCount, maxCount = minInt(minCount, c), maxInt(maxCount, c)
+	}
+
+	img := NewOutputImage(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := ZERO
+			if maxCount > minCount {
+				t = entry(counts[y*width+x]-minCount) / entry(maxCount-minCount)
+			}
+			Set(img, x, y, heatColor(t))
+		}
+	}
+	return img
+}
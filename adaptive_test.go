@@ -0,0 +1,47 @@
+// contains tests for adaptive.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPixelStats(t *testing.T) {
+	var s pixelStats
+	assertEquals(t, INF, s.standardError(), "Pixel stats: error without samples")
+	s.add(2)
+	assertEquals(t, INF, s.standardError(), "Pixel stats: error of one sample")
+	for _, v := range []entry{4, 4, 4, 5, 5, 7, 9} {
+		s.add(v)
+	}
+	// the samples 2,4,4,4,5,5,7,9 have mean 5, and the sum of squared differences 32
+	assert(t, s.n == 8 && !s.mean.neq(5), fmt.Sprint("Pixel stats: mean ", s.mean))
+	assert(t, !s.variance().neq(32.0/7), fmt.Sprint("Pixel stats: variance ", s.variance()))
+	assert(t, !s.standardError().neq(sqrt(32.0/7/8)), fmt.Sprint("Pixel stats: error ", s.standardError()))
+}
+
+func TestLuminance(t *testing.T) {
+	assert(t, !luminance(Vec3{1, 1, 1}).neq(ONE), "Luminance: white")
+	assert(t, luminance(Y_V3) > luminance(X_V3) && luminance(X_V3) > luminance(Z_V3), "Luminance: green, red, blue")
+}
+
+func TestSampleHeatmap(t *testing.T) {
+	img := SampleHeatmap([]int{4, 4, 10, 16}, 2, 2)
+	assert(t, img.Bounds().Dx() == 2 && img.Bounds().Dy() == 2, "Heatmap: size")
+	fewest, most := img.RGBAAt(0, 0), img.RGBAAt(1, 1)
+	assert(t, fewest.B == 255 && fewest.R == 0, fmt.Sprint("Heatmap: fewest samples are blue, not ", fewest))
+	assert(t, most.R == 255 && most.B == 0, fmt.Sprint("Heatmap: most samples are red, not ", most))
+	assert(t, img.RGBAAt(0, 1).G > 0, "Heatmap: between is green")
+
+	// the same number of samples everywhere
+	img = SampleHeatmap([]int{4, 4}, 2, 1)
+	assert(t, img.RGBAAt(1, 0).B == 255, "Heatmap: all the same")
+
+	// counts which are not of the image
+	for _, counts := range [][]int{nil, {4, 16, 8}} {
+		img = SampleHeatmap(counts, 2, 2)
+		assert(t, img.Bounds().Dx() == 2 && img.Bounds().Dy() == 2, "Heatmap: size, without counts")
+		assert(t, img.RGBAAt(0, 0).B == 255 && img.RGBAAt(1, 1).B == 255, fmt.Sprint("Heatmap: all blue for ", len(counts), " counts"))
+	}
+}
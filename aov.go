@@ -0,0 +1,375 @@
+// aov.go: Contains the render passes (arbitrary output values, or AOVs) which can be rendered alongside the image:
+// per-pixel data about the surfaces seen (e.g. their depth or normal), and the parts of the lighting (e.g. direct or reflected).
+//
+// The lighting passes are filtered as the image is, so that emission + direct + indirect adds up to the image.
+// The data passes average the samples within each pixel, and the ID passes are those of the sample nearest the center of the pixel.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// An AOV is a render pass.
+type AOV int
+
+// the render passes:
+const (
+	AOVDepth      AOV = iota // the distance along the ray to the surface (0 where nothing is hit)
+	AOVPosition              // the point hit, in world space
+	AOVNormal                // the shading normal of the point hit
+	AOVAlbedo                // the diffuse colour of the surface (the base colour of physically based materials)
+	AOVObjectID              // the shape hit: its index in the scene, from 1 (0 where nothing is hit)
+	AOVMaterialID            // the material of the surface, numbered from 1 in the order of the scene (0 where nothing is hit)
+	AOVDirect                // the light reaching the surface directly from the lights
+	AOVIndirect              // the light reaching the surface otherwise: the ambient light and the reflections
+	AOVReflection            // the light reflected by the surface (part of the indirect light)
+	AOVShadow                // the fraction of the shadow rays which are blocked (averaged over the lights)
+	AOVEmission              // the light emitted by the surface
+	numAOVs
+)
+
+// the names of the passes (as used in file names, and the layers of OpenEXR files)
+var aovNames = [numAOVs]string{"depth", "position", "normal", "albedo", "objectID", "materialID",
+	"direct", "indirect", "reflection", "shadow", "emission"}
+
+// AllAOVs returns every render pass.
+func AllAOVs() []AOV {
+	aovs := make([]AOV, numAOVs)
+	for i := range aovs {
+		aovs[i] = AOV(i)
+	}
+	return aovs
+}
+
+// String returns the name of the pass.
+func (a AOV) String() string {
+	if a < 0 || a >= numAOVs {
+		return fmt.Sprintf("AOV(%d)", int(a))
+	}
+	return aovNames[a]
+}
+
+// ParseAOV returns the pass of the given name.
+func ParseAOV(name string) (AOV, error) {
+	for i, n := range aovNames {
+		if n == name {
+			return AOV(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown render pass %q", name)
+}
+
+// check if the pass has a single value (rather than a colour or a vector)
+func (a AOV) scalar() bool {
+	return a == AOVDepth || a == AOVObjectID || a == AOVMaterialID || a == AOVShadow
+}
+
+// check if the pass is of light (which is filtered as the image is)
+func (a AOV) lighting() bool {
+	return a == AOVDirect || a == AOVIndirect || a == AOVReflection || a == AOVEmission
+}
+
+// the passes of a single (primary) sample, as found by findColor
+type aovSample struct {
+	depth                       entry
+	position, normal, albedo    Vec3
+	objectID, materialID        int
+	ambient, direct, reflection Vec3
+	emission                    Vec3
+	shadow                      entry
+}
+
+// set the passes of the surface hit by the sample: the intersection with the index-th shape of the scene,
+// and its material (along with the number of the material)
+func (s *aovSample) setHit(inter *Intersection, index int, mat *Material, materialID int) {
+	s.depth, s.position, s.normal = inter.dist, inter.point, inter.normal
+	s.albedo = mat.diffuse
+	if mat.pbr != nil {
+		s.albedo = mat.pbr.baseColor
+	}
+	s.objectID, s.materialID = index+1, materialID
+	s.ambient, s.emission = mat.ambient, mat.emission
+}
+
+// the value of a pass of the sample (with scalars in every component)
+func (s *aovSample) value(aov AOV) Vec3 {
+	switch aov {
+	case AOVDepth:
+		return Vec3{s.depth, s.depth, s.depth}
+	case AOVPosition:
+		return s.position
+	case AOVNormal:
+		return s.normal
+	case AOVAlbedo:
+		return s.albedo
+	case AOVObjectID:
+		return Vec3{entry(s.objectID), entry(s.objectID), entry(s.objectID)}
+	case AOVMaterialID:
+		return Vec3{entry(s.materialID), entry(s.materialID), entry(s.materialID)}
+	case AOVDirect:
+		return s.direct
+	case AOVIndirect:
+		return s.ambient.plus(s.reflection)
+	case AOVReflection:
+		return s.reflection
+	case AOVShadow:
+		return Vec3{s.shadow, s.shadow, s.shadow}
+	case AOVEmission:
+		return s.emission
+	}
+	return ZERO_V3
+}
+
+// the pass of the ID of the sample nearest the center of each pixel
+type idBuffer struct {
+	aov   AOV
+	fb    *Framebuffer // (only the colours are used)
+	dists []entry      // the squared distance of the sample of each pixel from its center (or -1, for none)
+}
+
+// the passes of a render
+type aovBuffers struct {
+	materialIDs map[*Material]int // the numbers of the materials of the scene
+	box         Filter            // the filter of the data passes, which averages the samples within each pixel
+	aovs        []AOV
+	filtered    []*Framebuffer // the filtered passes (in the order of aovs, and nil for the ID passes)
+	ids         []*idBuffer
+}
+
+// create the buffers of the passes, for the region of fb
+func newAOVBuffers(fb *Framebuffer, scene []Shape, aovs []AOV) *aovBuffers {
+	b := &aovBuffers{materialIDs: sceneMaterialIDs(scene), box: NewBoxFilter(0.5)}
+	for _, aov := range aovs {
+		if b.has(aov) {
+			continue
+		}
+		b.aovs = append(b.aovs, aov)
+		if aov == AOVObjectID || aov == AOVMaterialID {
+			dists := make([]entry, fb.width*fb.height)
+			for i := range dists {
+				dists[i] = -1
+			}
+			b.ids = append(b.ids, &idBuffer{aov, newFramebufferRegion(fb.x0, fb.y0, fb.width, fb.height), dists})
+			b.filtered = append(b.filtered, nil)
+		} else {
+			b.filtered = append(b.filtered, newFramebufferRegion(fb.x0, fb.y0, fb.width, fb.height))
+		}
+	}
+	return b
+}
+
+// check if the pass is rendered
+func (b *aovBuffers) has(aov AOV) bool {
+	for _, a := range b.aovs {
+		if a == aov {
+			return true
+		}
+	}
+	return false
+}
+
+// add the passes of a sample at the point (px,py) of the image (with the filter of the image)
+func (b *aovBuffers) add(px, py entry, sample *aovSample, filter Filter) {
+	for i, aov := range b.aovs {
+		if fb := b.filtered[i]; fb != nil {
+			if aov.lighting() {
+				fb.Splat(px, py, sample.value(aov), filter)
+			} else {
+				fb.Splat(px, py, sample.value(aov), b.box)
+			}
+		}
+	}
+
+	for _, ids := range b.ids {
+		x, y := int(math.Floor(float64(px))), int(math.Floor(float64(py)))
+		if x < ids.fb.x0 || y < ids.fb.y0 || x >= ids.fb.x0+ids.fb.width || y >= ids.fb.y0+ids.fb.height {
+			continue
+		}
+		dx, dy := px-entry(x)-0.5, py-entry(y)-0.5
+		i := ids.fb.index(x, y)
+		if dist := dx*dx + dy*dy; ids.dists[i] < 0 || dist < ids.dists[i] {
+			ids.fb.colors[i], ids.fb.weights[i], ids.dists[i] = sample.value(ids.aov), ONE, dist
+		}
+	}
+}
+
+// the pixels of a pass, in rows from the top-left
+func (b *aovBuffers) pixels(aov AOV) []Vec3 {
+	for i, a := range b.aovs {
+		if a != aov {
+			continue
+		}
+		if b.filtered[i] != nil {
+			return b.filtered[i].Pixels()
+		}
+		for _, ids := range b.ids {
+			if ids.aov == aov {
+				return ids.fb.Pixels()
+			}
+		}
+	}
+	return nil
+}
+
+// number the materials of the scene (from 1), in the order they are found
+// (by the material which findColor sees: that of the shape hit, or of the instance which overrides it)
+func sceneMaterialIDs(scene []Shape) map[*Material]int {
+	ids := make(map[*Material]int)
+	add := func(mat *Material) {
+		if _, ok := ids[mat]; mat != nil && !ok {
+			ids[mat] = len(ids) + 1
+		}
+	}
+	var walk func(shapes []Shape)
+	walk = func(shapes []Shape) {
+		for _, shape := range shapes {
+			switch s := shape.(type) {
+			case *Group:
+				walk(s.shapes)
+			case *Instance:
+				add(s.mat)
+				walk([]Shape{s.shape})
+			default:
+				add(shape.GetMaterial())
+			}
+		}
+	}
+	walk(scene)
+	return ids
+}
+
+// RenderPasses holds the image of a render, and its render passes.
+type RenderPasses struct {
+	width, height int
+	beauty        []Vec3 // the colours of the image, in rows from the top-left
+	passes        map[AOV][]Vec3
+}
+
+// DrawPasses renders the scene (as DrawContext), along with the given render passes (once each, if repeated).
+// (on an error, the passes are of the pixels rendered before it)
+func (r *RayTracer) DrawPasses(ctx context.Context, scene []Shape, lights []Light, aovs ...AOV) (*RenderPasses, RenderStats, error) {
+	fb := NewFramebuffer(r.width, r.height)
+	rt := r.forRender()
+	rt.aovs = newAOVBuffers(fb, scene, aovs)
+	stats, err := rt.drawRegion(ctx, scene, lights, fb)
+
+	passes := &RenderPasses{r.width, r.height, fb.Pixels(), make(map[AOV][]Vec3, len(aovs))}
+	for _, aov := range aovs {
+		passes.passes[aov] = rt.aovs.pixels(aov)
+	}
+	return passes, stats, err
+}
+
+// AOVs returns the passes which were rendered, in order.
+func (p *RenderPasses) AOVs() []AOV {
+	aovs := make([]AOV, 0, len(p.passes))
+	for aov := range p.passes {
+		aovs = append(aovs, aov)
+	}
+	sort.Slice(aovs, func(i, j int) bool { return aovs[i] < aovs[j] })
+	return aovs
+}
+
+// Pixels returns the values of a pass, in rows from the top-left (with scalars in every component),
+// or nil if it was not rendered.
+func (p *RenderPasses) Pixels(aov AOV) []Vec3 {
+	return p.passes[aov]
+}
+
+// Image returns the image of the render.
+func (p *RenderPasses) Image() *image.RGBA {
+	return colorsImage(p.width, p.height, p.beauty)
+}
+
+// PassImage returns a pass as an image, for viewing: depths and positions are scaled to fit between black and white,
+// normals are mapped from [-1,1] to [0,1], and each ID has its own colour (with black where nothing is hit).
+// The other passes are shown as they are. Returns nil if the pass was not rendered.
+func (p *RenderPasses) PassImage(aov AOV) *image.RGBA {
+	pixels, ok := p.passes[aov]
+	if !ok {
+		return nil
+	}
+	colors := make([]Vec3, len(pixels))
+	switch aov {
+	case AOVDepth, AOVPosition:
+		lo, hi := Vec3{INF, INF, INF}, Vec3{-INF, -INF, -INF}
+		for _, v := range pixels {
+			for c := range v {
+				lo[c], hi[c] = minEntry(lo[c], v[c]), maxEntry(hi[c], v[c])
+			}
+		}
+		for i, v := range pixels {
+			for c := range v {
+				if hi[c] > lo[c] {
+					colors[i][c] = (v[c] - lo[c]) / (hi[c] - lo[c])
+				}
+			}
+		}
+	case AOVNormal:
+		for i, v := range pixels {
+			if v != ZERO_V3 {
+				colors[i] = v.scale(0.5).plus(Vec3{0.5, 0.5, 0.5})
+			}
+		}
+	case AOVObjectID, AOVMaterialID:
+		for i, v := range pixels {
+			if id := uint32(v[cX]); id != 0 {
+				h := hashUint32(id)
+				colors[i] = Vec3{entry(h&0xff) / 255, entry(h>>8&0xff) / 255, entry(h>>16&0xff) / 255}
+			}
+		}
+	default:
+		copy(colors, pixels)
+	}
+	return colorsImage(p.width, p.height, colors)
+}
+
+// SavePNGs saves the image of each pass (as PassImage) as a PNG file,
+// named by formatting pattern with the name of the pass (e.g. "scene-%s.png").
+func (p *RenderPasses) SavePNGs(pattern string) error {
+	for _, aov := range p.AOVs() {
+		if err := saveImg(fmt.Sprintf(pattern, aov), p.PassImage(aov)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEXR writes the image, with each of its passes as a layer, as an OpenEXR file:
+// the image is the R, G and B channels, and the passes are layers named for them, of R, G and B channels
+// (e.g. "normal.R"), or a single Y channel for passes of a single value (e.g. "depth.Y").
+func (p *RenderPasses) WriteEXR(w io.Writer) error {
+	channels := colorChannels("", p.beauty)
+	for _, aov := range p.AOVs() {
+		if aov.scalar() {
+			values := make([]float32, len(p.passes[aov]))
+			for i, v := range p.passes[aov] {
+				values[i] = float32(v[cX])
+			}
+			channels = append(channels, exrChannel{aov.String() + ".Y", values})
+		} else {
+			channels = append(channels, colorChannels(aov.String(), p.passes[aov])...)
+		}
+	}
+	return writeEXRChannels(w, p.width, p.height, channels)
+}
+
+// SaveEXR saves the image and its passes (as WriteEXR) to the file at path.
+func (p *RenderPasses) SaveEXR(path string) error {
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = p.WriteEXR(output); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
@@ -0,0 +1,122 @@
+// contains tests for aov.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseAOV(t *testing.T) {
+	for _, aov := range AllAOVs() {
+		act, err := ParseAOV(aov.String())
+		assert(t, err == nil && act == aov, "ParseAOV: "+aov.String())
+	}
+	_, err := ParseAOV("colour")
+	assert(t, err != nil, "ParseAOV: expected an error for an unknown pass")
+}
+
+// the passes of a primary ray are those of the surface it hits
+func TestFindColorAOVs(t *testing.T) {
+	r := benchmarkRayTracer()
+	scene, lights := benchmarkScene()
+	ray := r.buildRayFromEyeToImage(36, 32, r.eyePos)
+	r.aovs = newAOVBuffers(NewFramebuffer(r.width, r.height), scene, []AOV{AOVDepth, AOVDepth})
+	assertEquals(t, 1, len(r.aovs.filtered), "AOVs: a repeated pass is rendered once")
+
+	var aov aovSample
+	color := r.findColor(ray, scene, lights, 0, ONE_V3, &aov)
+	_, inter, index := findClosestShape(ray, scene, ZERO, INF)
+	assertEquals(t, inter.dist, aov.depth, "AOVs: depth")
+	assertVec3Equals(t, inter.point, aov.position, "AOVs: position")
+	assertVec3Equals(t, inter.normal, aov.normal, "AOVs: normal")
+	assertVec3Equals(t, Vec3{0.2, 0.4, 0.2}, aov.albedo, "AOVs: albedo")
+	assertEquals(t, index+1, aov.objectID, "AOVs: object ID")
+	assertEquals(t, 1, aov.materialID, "AOVs: material ID")
+	assertVec3Equals(t, color, aov.emission.plus(aov.direct).plus(aov.value(AOVIndirect)), "AOVs: the lighting passes add up to the colour")
+
+	// a ray which hits nothing has no passes
+	aov = aovSample{}
+	r.findColor(Ray{r.eyePos, Y_V3}, scene, lights, 0, ONE_V3, &aov)
+	assertEquals(t, aovSample{}, aov, "AOVs: a miss")
+}
+
+// the image of DrawPasses is that of Draw, and the lighting passes add up to it
+func TestDrawPasses(t *testing.T) {
+	s, _ := ParseSceneFile([]byte(serviceTestScene(24, 16, 2)), "")
+	r, scene, lights, err := s.Build()
+	if !assert(t, err == nil, "DrawPasses: build error "+errString(err)) {
+		return
+	}
+	passes, stats, err := r.DrawPasses(context.Background(), scene, lights, AllAOVs()...)
+	assert(t, err == nil && stats.Complete(), "DrawPasses: render error "+errString(err))
+	exp := r.Draw(scene, lights)
+	assert(t, string(exp.Pix) == string(passes.Image().Pix), "DrawPasses: the image differs from Draw")
+	assertEquals(t, fmt.Sprint(AllAOVs()), fmt.Sprint(passes.AOVs()), "DrawPasses: the passes")
+
+	beauty := passes.beauty
+	emission, direct, indirect := passes.Pixels(AOVEmission), passes.Pixels(AOVDirect), passes.Pixels(AOVIndirect)
+	depth, objectIDs, materialIDs := passes.Pixels(AOVDepth), passes.Pixels(AOVObjectID), passes.Pixels(AOVMaterialID)
+	for i := range beauty {
+		if !assertVec3Equals(t, beauty[i], emission[i].plus(direct[i]).plus(indirect[i]), fmt.Sprint("DrawPasses: lighting of pixel ", i)) {
+			break
+		}
+		// (the IDs are of the same sample, which is one of those averaged for the depth)
+		if objectIDs[i][cX] == 0 {
+			assertEquals(t, ZERO, materialIDs[i][cX], fmt.Sprint("DrawPasses: material of empty pixel ", i))
+		} else {
+			assert(t, depth[i][cX] > 0, fmt.Sprint("DrawPasses: depth of pixel ", i))
+		}
+	}
+
+	// the IDs are whole numbers: the shapes of the scene, and its materials
+	seen := make(map[string]bool)
+	for i := range objectIDs {
+		seen[fmt.Sprint(objectIDs[i][cX], materialIDs[i][cY])] = true
+	}
+	for ids := range seen {
+		var object, material int
+		_, err := fmt.Sscan(ids, &object, &material)
+		assert(t, err == nil && object >= 0 && object <= len(scene) && material >= 0 && material <= 3, "DrawPasses: IDs "+ids)
+	}
+	assert(t, passes.Pixels(AOV(-1)) == nil && passes.PassImage(AOV(-1)) == nil, "DrawPasses: a pass which was not rendered")
+}
+
+// materials are numbered in the order of the scene, including those of groups and instances
+func TestSceneMaterialIDs(t *testing.T) {
+	a, b, c := &Material{}, &Material{}, &Material{}
+	mesh := NewMesh([]Vec3{ZERO_V3, X_V3, Y_V3}, nil, nil, nil, []uint32{0, 1, 2}, c)
+	scene := []Shape{
+		NewSphere(ONE, ZERO_V3, a),
+		NewGroup(NewInstance(mesh, Translation(X_V3), b), NewSphere(ONE, ZERO_V3, a)),
+	}
+	ids := sceneMaterialIDs(scene)
+	assertEquals(t, 3, len(ids), "MaterialIDs: number of materials")
+	assertEquals(t, 1, ids[a], "MaterialIDs: the first material")
+	assertEquals(t, 2, ids[b], "MaterialIDs: the material of the instance")
+	assertEquals(t, 3, ids[c], "MaterialIDs: the material of the instanced mesh")
+}
+
+// the passes are layers of the OpenEXR file, after the channels of the image
+func TestRenderPassesEXR(t *testing.T) {
+	passes := &RenderPasses{2, 1, []Vec3{{1, 2, 3}, {4, 5, 6}}, map[AOV][]Vec3{
+		AOVDepth:  {{0.5, 0.5, 0.5}, {0, 0, 0}},
+		AOVNormal: {{0, 1, 0}, {-1, 0, 0}},
+	}}
+	var buf bytes.Buffer
+	if !assert(t, passes.WriteEXR(&buf) == nil, "EXR passes: write error") {
+		return
+	}
+	attributes, rows := readTestEXR(t, buf.Bytes())
+	assertEquals(t, "B G R depth.Y normal.B normal.G normal.R", strings.Join(exrChannelNames(attributes["channels"]), " "), "EXR passes: channels")
+	assertEquals(t, fmt.Sprint([]float32{3, 6, 2, 5, 1, 4, 0.5, 0, 0, 0, 1, 0, 0, -1}), fmt.Sprint(rows[0]), "EXR passes: pixels")
+
+	// viewable images of the passes
+	img := passes.PassImage(AOVNormal)
+	assertEquals(t, fmt.Sprint([]uint8{128, 255, 128, 255, 0, 128, 128, 255}), fmt.Sprint(img.Pix), "EXR passes: normal image")
+	img = passes.PassImage(AOVDepth)
+	assertEquals(t, fmt.Sprint([]uint8{255, 255, 255, 255, 0, 0, 0, 255}), fmt.Sprint(img.Pix), "EXR passes: depth image")
+}
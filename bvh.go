@@ -0,0 +1,244 @@
+// bvh.go: Contains axis-aligned bounding boxes and a bounding volume hierarchy (BVH)
+// for accelerating intersection tests against many primitives.
+
+package main
+
+// An AABB is an axis-aligned bounding box.
+type AABB struct {
+	min, max Vec3
+}
+
+// an empty box, which contains nothing (extending it by any point gives that point)
+func emptyAABB() AABB {
+	inf := INF
+	return AABB{Vec3{inf, inf, inf}, Vec3{-inf, -inf, -inf}}
+}
+
+// grow the box to contain the point
+func (b *AABB) extend(p Vec3) {
+	for i := 0; i < V3LEN; i++ {
+		b.min[i] = minEntry(b.min[i], p[i])
+		b.max[i] = maxEntry(b.max[i], p[i])
+	}
+}
+
+// grow the box to contain another box
+func (b *AABB) union(c AABB) {
+	b.extend(c.min)
+	b.extend(c.max)
+}
+
+// the center of the box
+func (b *AABB) centroid() Vec3 {
+	return b.min.plus(b.max).scale(ONE / TWO)
+}
+
+// half of the surface area of the box (used by the surface area heuristic)
+func (b *AABB) halfArea() entry {
+	d := b.max.minus(b.min)
+	if d[cX] < 0 {
+		return ZERO // empty box
+	}
+	return d[cX]*d[cY] + d[cY]*d[cZ] + d[cZ]*d[cX]
+}
+
+// slab test: check if the ray passes through the box within (tMin, tMax).
+// invDir is the elementwise reciprocal of the ray direction.
+func (b *AABB) hit(ray Ray, invDir Vec3, tMin, tMax entry) bool {
+	for i := 0; i < V3LEN; i++ {
+		t0 := (b.min[i] - ray.start[i]) * invDir[i]
+		t1 := (b.max[i] - ray.start[i]) * invDir[i]
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		t1 *= ONE + TWO*gamma(3) // round the exit up, so that rounding errors cannot miss a grazing hit
+		tMin, tMax = maxEntry(tMin, t0), minEntry(tMax, t1)
+		if tMin > tMax {
+			return false
+		}
+	}
+	return true
+}
+
+func minEntry(a, b entry) entry {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxEntry(a, b entry) entry {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// define the shape of the BVH:
+const (
+	bvhLeafSize = 4  // max number of primitives in a leaf
+	bvhNumBins  = 16 // number of buckets to consider when splitting a node
+)
+
+// A node in the BVH. The nodes are stored in depth-first order,
+// so the first child of an interior node is the next node.
+type bvhNode struct {
+	bounds AABB
+	offset int // interior: index of the second child; leaf: index of the first primitive in order
+	count  int // number of primitives in a leaf (0 for interior nodes)
+}
+
+// A bvh is a bounding volume hierarchy over a set of primitives,
+// each of which is known only by its index and bounding box.
+type bvh struct {
+	nodes []bvhNode
+	order []int32 // the primitive indices, ordered so that each leaf holds a contiguous range
+}
+
+// build a BVH over the primitives with the given bounding boxes
+func newBVH(bounds []AABB) *bvh {
+	b := &bvh{make([]bvhNode, 0, 2*len(bounds)/bvhLeafSize+1), make([]int32, len(bounds))}
+	centroids := make([]Vec3, len(bounds))
+	for i := range bounds {
+		b.order[i] = int32(i)
+		centroids[i] = bounds[i].centroid()
+	}
+	if len(bounds) > 0 {
+		b.build(bounds, centroids, 0, len(bounds))
+	}
+	return b
+}
+
+// recursively build the node over the primitives order[start:end], returning its index
+func (b *bvh) build(bounds []AABB, centroids []Vec3, start, end int) int {
+
+	// compute the bounds of the primitives and of their centroids:
+	box, cbox := emptyAABB(), emptyAABB()
+	for _, p := range b.order[start:end] {
+		box.union(bounds[p])
+		cbox.extend(centroids[p])
+	}
+
+	index := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{box, start, end - start})
+	if end-start <= bvhLeafSize {
+		return index
+	}
+
+	// split along the longest axis of the centroids:
+	axis, extent := 0, cbox.max.minus(cbox.min)
+	if extent[cY] > extent[axis] {
+		axis = cY
+	}
+	if extent[cZ] > extent[axis] {
+		axis = cZ
+	}
+	if extent[axis] <= 0 {
+		return index // all centroids coincide: cannot split
+	}
+
+	// find the bin of a primitive:
+	binOf := func(p int32) int {
+		bin := int(entry(bvhNumBins) * (centroids[p][axis] - cbox.min[axis]) / extent[axis])
+		if bin >= bvhNumBins {
+			bin = bvhNumBins - 1
+		}
+		return bin
+	}
+
+	// place the primitives into bins:
+	var binBoxes [bvhNumBins]AABB
+	var binCounts [bvhNumBins]int
+	for i := range binBoxes {
+		binBoxes[i] = emptyAABB()
+	}
+	for _, p := range b.order[start:end] {
+		bin := binOf(p)
+		binBoxes[bin].union(bounds[p])
+		binCounts[bin]++
+	}
+
+	// pick the split (between bins) with the least surface area heuristic cost:
+	var rightCosts [bvhNumBins]entry
+	acc, count := emptyAABB(), 0
+	for i := bvhNumBins - 1; i > 0; i-- {
+		acc.union(binBoxes[i])
+		count += binCounts[i]
+		rightCosts[i] = acc.halfArea() * entry(count)
+	}
+	bestSplit, bestCost := 0, INF
+	acc, count = emptyAABB(), 0
+	for i := 0; i < bvhNumBins-1; i++ {
+		acc.union(binBoxes[i])
+		count += binCounts[i]
+		if cost := acc.halfArea()*entry(count) + rightCosts[i+1]; count > 0 && count < end-start && cost < bestCost {
+			bestSplit, bestCost = i+1, cost
+		}
+	}
+
+	// partition the primitives about the split:
+	mid := start
+	for i := start; i < end; i++ {
+		if binOf(b.order[i]) < bestSplit {
+			b.order[i], b.order[mid] = b.order[mid], b.order[i]
+			mid++
+		}
+	}
+	if mid == start || mid == end {
+		mid = (start + end) / 2 // degenerate split: fall back to halving
+	}
+
+	// build the children (the first child immediately follows this node):
+	b.build(bounds, centroids, start, mid)
+	second := b.build(bounds, centroids, mid, end)
+	b.nodes[index].offset, b.nodes[index].count = second, 0
+	return index
+}
+
+// traverse the BVH, calling test on each primitive whose leaf is hit by the ray within (tMin, tMax).
+// test returns whether the primitive was hit, and if so, at what distance (which shrinks tMax).
+// Returns whether any primitive was hit: if anyHit is set, the traversal stops at the first one.
+func (b *bvh) traverse(ray Ray, tMin, tMax entry, anyHit bool, test func(prim int, tMax entry) (bool, entry)) (hit bool) {
+	if len(b.nodes) == 0 {
+		return
+	}
+	invDir := Vec3{ONE / ray.direction[cX], ONE / ray.direction[cY], ONE / ray.direction[cZ]}
+
+	// depth-first traversal, using an explicit stack of node indices:
+	stack := make([]int, 1, 64)
+	for len(stack) > 0 {
+		index := stack[len(stack)-1]
+		node := &b.nodes[index]
+		stack = stack[:len(stack)-1]
+		if !node.bounds.hit(ray, invDir, tMin, tMax) {
+			continue
+		}
+		if node.count > 0 {
+			for _, p := range b.order[node.offset : node.offset+node.count] {
+				if h, dist := test(int(p), tMax); h && dist < tMax {
+					if hit, tMax = true, dist; anyHit {
+						return
+					}
+				}
+			}
+		} else {
+			// push both children (the first child is visited first):
+			stack = append(stack, node.offset, index+1)
+		}
+	}
+	return
+}
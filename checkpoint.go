@@ -0,0 +1,191 @@
+// checkpoint.go: Contains the checkpoints of progressive renders (so that they can be resumed),
+// and the hash of the scene which identifies them.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"os"
+	refl "reflect" // (reflect is the reflection of a ray, in raytracer.go)
+	"sort"
+)
+
+// the version of the format of checkpoint files
+const checkpointVersion = 1
+
+// ErrSceneChanged is returned when resuming from a checkpoint of a different scene (or camera, or options).
+var ErrSceneChanged = errors.New("checkpoint: the scene has changed since the checkpoint was saved")
+
+// a checkpoint holds the progress of a progressive render
+// (the samples of each pixel are determined by the seed of the sampler, which is part of the hash of the scene,
+// so the sampler continues exactly from the number of samples so far)
+type checkpoint struct {
+	Version       int
+	SceneHash     uint64
+	Width, Height int
+	Sampler       string // the type of the sampler
+	Pass          int    // the current pass
+	Total         int    // the number of samples of each pixel, before the current pass
+	PassSamples   int    // the number of samples of each pixel, in the current pass
+	Row           int    // the next row of the current pass
+	Colors        []Vec3 // the accumulation framebuffer
+	Weights       []entry
+	SampleCounts  []int
+}
+
+// save the checkpoint to the file at path (replacing it only once the checkpoint is fully written)
+func (c *checkpoint) save(path string) error {
+	tmp := path + ".tmp"
+	output, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(output).Encode(c); err == nil {
+		err = output.Sync()
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load a checkpoint from the file at path
+func loadCheckpoint(path string) (*checkpoint, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	c := &checkpoint{}
+	if err := gob.NewDecoder(input).Decode(c); err != nil {
+		return nil, fmt.Errorf("checkpoint: %s: %v", path, err)
+	}
+	if c.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint: %s: unsupported version %d", path, c.Version)
+	}
+	if len(c.Colors) != c.Width*c.Height || len(c.Weights) != len(c.Colors) || len(c.SampleCounts) != len(c.Colors) {
+		return nil, fmt.Errorf("checkpoint: %s: the framebuffer does not match the image size", path)
+	}
+	return c, nil
+}
+
+// SceneHash returns a hash of everything which determines the image: the shapes (and their materials),
+// the lights, the camera and the options of the ray tracer (including the seed of the sampler).
+func (r *RayTracer) SceneHash(scene []Shape, lights []Light) uint64 {
+	h := &valueHasher{h: fnv.New64a(), seen: make(map[seenPointer]int)}
+
+	// only the inputs of the ray tracer are hashed (the camera, by the view it determines), and not the state of its renders
+	inputs := struct {
+		width, height                  int
+		tanX, tanY                     entry
+		basisU, basisV, basisW, eyePos Vec3
+		options                        *RayTracerOptions
+		sampler                        Sampler
+		filter                         Filter
+	}{r.width, r.height, r.tanX, r.tanY, r.basisU, r.basisV, r.basisW, r.eyePos, r.options, r.sampler, r.filter}
+	h.value(refl.ValueOf(inputs))
+	h.value(refl.ValueOf(scene))
+	h.value(refl.ValueOf(lights))
+	return h.h.Sum64()
+}
+
+// a pointer (to a value of a type: a struct and its first field have the same address)
+type seenPointer struct {
+	ptr uintptr
+	typ refl.Type
+}
+
+// valueHasher hashes any value (through reflection) by its contents, following pointers and interfaces.
+type valueHasher struct {
+	h    hash.Hash64
+	seen map[seenPointer]int // the pointers already hashed (which may be shared, or cyclic), by the order they were found
+	buf  [8]byte
+}
+
+func (h *valueHasher) uint(u uint64) {
+	binary.LittleEndian.PutUint64(h.buf[:], u)
+	h.h.Write(h.buf[:])
+}
+
+func (h *valueHasher) string(s string) {
+	h.uint(uint64(len(s)))
+	h.h.Write([]byte(s))
+}
+
+// hash the value v
+func (h *valueHasher) value(v refl.Value) {
+	switch v.Kind() {
+	case refl.Bool:
+		if v.Bool() {
+			h.uint(1)
+		} else {
+			h.uint(0)
+		}
+	case refl.Int, refl.Int8, refl.Int16, refl.Int32, refl.Int64:
+		h.uint(uint64(v.Int()))
+	case refl.Uint, refl.Uint8, refl.Uint16, refl.Uint32, refl.Uint64, refl.Uintptr:
+		h.uint(v.Uint())
+	case refl.Float32, refl.Float64:
+		h.uint(math.Float64bits(v.Float()))
+	case refl.String:
+		h.string(v.String())
+	case refl.Array, refl.Slice:
+		h.uint(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			h.value(v.Index(i))
+		}
+	case refl.Struct:
+		// the state of a sampler changes as it is used, but only its seed determines the samples
+		if v.Type() == refl.TypeOf(samplerState{}) {
+			h.uint(v.FieldByName("seed").Uint())
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			h.value(v.Field(i))
+		}
+	case refl.Ptr:
+		if v.IsNil() {
+			h.uint(0)
+			return
+		}
+		// a pointer seen before is hashed by when it was first seen (rather than its contents, again)
+		p := seenPointer{v.Pointer(), v.Type()}
+		if order, ok := h.seen[p]; ok {
+			h.uint(uint64(order))
+			return
+		}
+		h.seen[p] = len(h.seen) + 1
+		h.uint(math.MaxUint64)
+		h.value(v.Elem())
+	case refl.Interface:
+		if v.IsNil() {
+			h.uint(0)
+			return
+		}
+		h.string(v.Elem().Type().String())
+		h.value(v.Elem())
+	case refl.Map:
+		// in the order of the (formatted) keys
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		h.uint(uint64(len(keys)))
+		for _, k := range keys {
+			h.value(k)
+			h.value(v.MapIndex(k))
+		}
+	default:
+		// functions (e.g. shaders) and channels are only hashed by their type
+		h.string(v.Type().String())
+	}
+}
@@ -0,0 +1,109 @@
+// contains tests for checkpoint.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// the scene hash depends on the contents of the scene, lights, camera and options (rather than where they are in memory)
+func TestSceneHash(t *testing.T) {
+	scene, lights := benchmarkScene()
+	r := progressiveRayTracer(1)
+	hash := r.SceneHash(scene, lights)
+
+	// the same scene, built again (and after rendering)
+	scene2, lights2 := benchmarkScene()
+	progressiveRayTracer(1).Draw(scene2, lights2)
+	assertEquals(t, hash, progressiveRayTracer(1).SceneHash(scene2, lights2), "Scene hash: the same scene")
+
+	// the state of renders is not hashed
+	r2 := progressiveRayTracer(1)
+	r2.progress = &atomic.Int64{}
+	r2.aovs = newAOVBuffers(NewFramebuffer(r2.width, r2.height), scene, []AOV{AOVDepth})
+	assertEquals(t, hash, r2.SceneHash(scene, lights), "Scene hash: the state of a render")
+
+	// changes to the scene
+	scene2[0].GetMaterial().diffuse[cX] += 0.01
+	assert(t, r.SceneHash(scene2, lights2) != hash, "Scene hash: a material changed")
+	scene2, _ = benchmarkScene()
+	lights2[1].(*PointLight).position[cY] = 2
+	assert(t, r.SceneHash(scene2, lights2) != hash, "Scene hash: a light moved")
+	assert(t, r.SceneHash(scene[1:], lights) != hash, "Scene hash: a shape removed")
+
+	// changes to the camera and options
+	view := &Camera{Vec3{0, 0, 6}, ZERO_V3, Y_V3, 16, 12, entry(61)}
+	r2 = NewRayTracer(view, &RayTracerOptions{1, 1, 1, 1, ZERO, NewSobolSampler(3), nil, 0, ZERO})
+	assert(t, r2.SceneHash(scene, lights) != hash, "Scene hash: the field of view changed")
+	r2 = progressiveRayTracer(1)
+	r2.options.sampler, r2.sampler = NewSobolSampler(4), NewSobolSampler(4)
+	assert(t, r2.SceneHash(scene, lights) != hash, "Scene hash: the seed changed")
+}
+
+// a render which stops (e.g. the process dies) resumes exactly from its last checkpoint
+func TestCheckpointResume(t *testing.T) {
+	scene, lights := benchmarkScene()
+	exp, _ := progressiveRayTracer(1).DrawProgressive(context.Background(), scene, lights, &ProgressiveOptions{targetSamples: 8})
+
+	// stop after the third pass, which is not checkpointed at its end (so the checkpoint is within the pass)
+	path := filepath.Join(t.TempDir(), "render.checkpoint")
+	stop := errors.New("killed")
+	options := &ProgressiveOptions{targetSamples: 8, checkpointPath: path, checkpointInterval: 1, onPass: func(pass ProgressivePass) error {
+		if pass.Pass == 3 {
+			return stop
+		}
+		return nil
+	}}
+	_, err := progressiveRayTracer(1).DrawProgressive(context.Background(), scene, lights, options)
+	assert(t, err == stop, fmt.Sprint("Checkpoint: stopped with error ", err))
+	c, err := loadCheckpoint(path)
+	assert(t, err == nil && c.Pass == 3 && c.Row == 11 && c.Total == 2, fmt.Sprint("Checkpoint: saved at ", c, " with error ", err))
+
+	// resume (in a new ray tracer, with the scene built again)
+	scene, lights = benchmarkScene()
+	r := progressiveRayTracer(1)
+	var counts []int
+	options.onPass = func(pass ProgressivePass) error {
+		counts = pass.SampleCounts
+		return nil
+	}
+	act, err := r.ResumeProgressive(context.Background(), scene, lights, options)
+	assert(t, err == nil, fmt.Sprint("Checkpoint: resumed with error ", err))
+	assert(t, string(exp.Pix) == string(act.Pix), "Checkpoint: the resumed image differs")
+	assertEquals(t, 16*12, len(counts), "Checkpoint: sample counts")
+	for _, count := range counts {
+		assertEquals(t, 8, count, "Checkpoint: samples of each pixel")
+	}
+
+	// the completed render is saved too (so resuming again has nothing more to do)
+	c, err = loadCheckpoint(path)
+	assert(t, err == nil && c.Total == 8, fmt.Sprint("Checkpoint: completed at ", c.Total, " samples, with error ", err))
+	_, err = os.Stat(path + ".tmp")
+	assert(t, os.IsNotExist(err), "Checkpoint: temporary file left behind")
+}
+
+// resuming fails if the scene has changed, or the checkpoint is missing or corrupt
+func TestCheckpointRefusesResume(t *testing.T) {
+	scene, lights := benchmarkScene()
+	path := filepath.Join(t.TempDir(), "render.checkpoint")
+	options := &ProgressiveOptions{targetSamples: 2, checkpointPath: path}
+	progressiveRayTracer(1).DrawProgressive(context.Background(), scene, lights, options)
+
+	scene[3].GetMaterial().shininess = 50
+	_, err := progressiveRayTracer(1).ResumeProgressive(context.Background(), scene, lights, options)
+	assert(t, err == ErrSceneChanged, fmt.Sprint("Checkpoint: changed scene resumed with error ", err))
+
+	os.WriteFile(path, []byte("not a checkpoint"), 0644)
+	_, err = progressiveRayTracer(1).ResumeProgressive(context.Background(), scene, lights, options)
+	assert(t, err != nil, "Checkpoint: corrupt file resumed")
+
+	options.checkpointPath = filepath.Join(t.TempDir(), "missing")
+	_, err = progressiveRayTracer(1).ResumeProgressive(context.Background(), scene, lights, options)
+	assert(t, os.IsNotExist(err), fmt.Sprint("Checkpoint: missing file resumed with error ", err))
+}
@@ -0,0 +1,152 @@
+// denoise.go: Contains the denoiser, which smooths the noise of renders with few samples (e.g. from soft shadows
+// and blurred reflections) while keeping their edges, guided by the albedo and normal passes.
+//
+// It is an edge-avoiding à-trous wavelet filter (as in "Edge-Avoiding À-Trous Wavelet Transform for fast
+// Global Illumination Filtering", Dammertz et al.): a 5x5 kernel is applied repeatedly, with its taps spread
+// twice as far apart each time, and each tap is weighted down by how much its colour, normal and albedo differ
+// from those of the pixel. The colours are first divided by the albedo, so that the texture of the surfaces
+// is not blurred along with the noise of their lighting.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// the parameters of the denoiser:
+const (
+	denoiseIterations  = 5     // the number of times the kernel is applied (the last time, its taps are 16 pixels apart)
+	denoiseSigmaColor  = 0.3   // the difference in colour (at a strength of 1) beyond which pixels are not smoothed together
+	denoiseMinSigma    = 1e-6  // (smaller differences in colour would overflow the weights of the colours, e.g. for a tiny strength)
+	denoiseSigmaAlbedo = 0.1   // the difference in albedo beyond which pixels are not smoothed together
+	denoiseNormalPower = 64    // the sharpness of the falloff of the weight with the angle between normals
+	denoiseMinAlbedo   = 0.01  // albedos below this are not divided out of the colour
+	denoiseMinWeight   = 1e-12 // (taps with a smaller weight are skipped)
+)
+
+// the B3 spline, the 1D kernel of the filter
+var denoiseKernel = [5]entry{1.0 / 16, 1.0 / 4, 3.0 / 8, 1.0 / 4, 1.0 / 16}
+
+// ErrMissingPasses is returned when denoising a render without its albedo and normal passes.
+var ErrMissingPasses = errors.New("denoising needs the albedo and normal passes of the render")
+
+// Denoised returns a copy of the render (sharing its passes) with the image denoised. The strength scales how
+// different in colour pixels may be and still be smoothed together: 1 suits most renders, higher values smooth more
+// (at the risk of blurring detail in the lighting), and 0 leaves the image as it is.
+// The render must have the albedo and normal passes (AOVAlbedo and AOVNormal).
+func (p *RenderPasses) Denoised(strength entry) (*RenderPasses, error) {
+	albedo, normals := p.passes[AOVAlbedo], p.passes[AOVNormal]
+	if albedo == nil || normals == nil {
+		return nil, ErrMissingPasses
+	}
+	beauty, err := Denoise(p.width, p.height, p.beauty, albedo, normals, strength)
+	if err != nil {
+		return nil, err
+	}
+	res := *p
+	res.beauty = beauty
+	return &res, nil
+}
+
+// Denoise denoises the colours of an image of width x height pixels (in rows from the top-left),
+// guided by the albedo and the normal of each pixel, with the given strength (as Denoised).
+// It fails if there is not a colour, an albedo and a normal for each pixel.
+func Denoise(width, height int, colors, albedo, normals []Vec3, strength entry) ([]Vec3, error) {
+	if n := width * height; width < 0 || height < 0 || len(colors) != n || len(albedo) != n || len(normals) != n {
+		return nil, fmt.Errorf("denoise: %d colours, %d albedos and %d normals for an image of %dx%d pixels",
+			len(colors), len(albedo), len(normals), width, height)
+	}
+	res := make([]Vec3, len(colors))
+	copy(res, colors)
+	if strength <= 0 {
+		return res, nil
+	}
+
+	// divide out the albedo (where it is not too dark, to keep the lighting within range)
+	for i := range res {
+		for c := range res[i] {
+			if albedo[i][c] > denoiseMinAlbedo {
+				res[i][c] /= albedo[i][c]
+			}
+		}
+	}
+
+	// apply the kernel with the taps spread out (by step pixels), and the colour weights narrowed, each time
+	next := make([]Vec3, len(res))
+	sigmaColor := strength * denoiseSigmaColor
+	for i, step := 0, 1; i < denoiseIterations; i, step = i+1, step*2 {
+		denoiseStep(width, height, res, next, albedo, normals, step, sigmaColor)
+		res, next = next, res
+		sigmaColor /= 2
+	}
+
+	// and multiply it back in
+	for i := range res {
+		for c := range res[i] {
+			if albedo[i][c] > denoiseMinAlbedo {
+				res[i][c] *= albedo[i][c]
+			}
+		}
+	}
+	return res, nil
+}
+
+// apply the kernel once, with its taps step pixels apart, from the colours in src to dst
+func denoiseStep(width, height int, src, dst, albedo, normals []Vec3, step int, sigmaColor entry) {
+	sigmaColor = maxEntry(sigmaColor, denoiseMinSigma)
+	colorScale := -ONE / (sigmaColor * sigmaColor)
+	albedoScale := -ONE / (denoiseSigmaAlbedo * denoiseSigmaAlbedo)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var sum Vec3
+			var sumWeight entry
+			for ky := -2; ky <= 2; ky++ {
+				qy := y + ky*step
+				if qy < 0 || qy >= height {
+					continue
+				}
+				for kx := -2; kx <= 2; kx++ {
+					qx := x + kx*step
+					if qx < 0 || qx >= width {
+						continue
+					}
+					j := qy*width + qx
+					w := denoiseKernel[kx+2] * denoiseKernel[ky+2] * normalWeight(normals[i], normals[j])
+					if w < denoiseMinWeight {
+						continue
+					}
+					dc, da := src[i].minus(src[j]), albedo[i].minus(albedo[j])
+					w *= entry(math.Exp(float64(dc.dot(dc)*colorScale + da.dot(da)*albedoScale)))
+					if w < denoiseMinWeight {
+						continue
+					}
+					sum.addScaledInPlace(src[j], w)
+					sumWeight += w
+				}
+			}
+			// (the pixel itself always has a weight)
+			dst[i] = sum.scale(ONE / sumWeight)
+		}
+	}
+}
+
+// the weight of a tap from its normal, and that of the pixel: falling off with the angle between them
+// (pixels where nothing is hit have a zero normal, and are only smoothed with each other)
+func normalWeight(n, m Vec3) entry {
+	if n == ZERO_V3 || m == ZERO_V3 {
+		if n == m {
+			return ONE
+		}
+		return ZERO
+	}
+	// (the normals are averages over the pixels, so they may be a little shorter than unit length)
+	cos := n.dot(m) / (n.magnitude() * m.magnitude())
+	if cos <= 0 {
+		return ZERO
+	}
+	return entry(math.Pow(float64(cos), denoiseNormalPower))
+}
@@ -0,0 +1,139 @@
+// contains tests for denoise.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// a scene whose renders are noisy with few rays: soft shadows, and blurred reflections on a glossy floor,
+// along with the ray tracer for it (with one sample per pixel, and the given number of shadow and reflection rays)
+func denoiseTestScene(rays int) (*RayTracer, []Shape, []Light) {
+	floor := &Material{Vec3{0.05, 0.05, 0.05}, ZERO_V3, Vec3{0.5, 0.5, 0.4}, Vec3{0.4, 0.4, 0.4}, entry(20), entry(0.4), nil}
+	red := &Material{Vec3{0.05, 0.02, 0.02}, ZERO_V3, Vec3{0.7, 0.1, 0.1}, Vec3{0.3, 0.3, 0.3}, entry(30), entry(0.3), nil}
+	blue := NewPBRMaterial(Vec3{0.1, 0.2, 0.8}, ZERO_V3, ZERO, entry(0.5))
+	scene := []Shape{
+		NewQuad(Vec3{-6, -1, 4}, Vec3{6, -1, 4}, Vec3{6, -1, -8}, Vec3{-6, -1, -8}, floor),
+		NewSphere(ONE, Vec3{-1.2, 0, -1}, red),
+		NewSphere(entry(0.7), Vec3{1.3, -0.3, 0}, blue),
+	}
+	lights := []Light{&PointLight{Vec3{0.9, 0.9, 0.9}, Vec3{1, 4, 3}, X_V3}}
+	view := &Camera{Vec3{0, 1.5, 6}, Vec3{0, -0.3, 0}, Y_V3, 48, 32, entry(45)}
+	return NewRayTracer(view, &RayTracerOptions{2, 1, rays, rays, ZERO, NewSobolSampler(7), nil, 0, ZERO}), scene, lights
+}
+
+// denoising a render with one shadow and reflection ray brings it much closer to a render with many
+// (of the same samples of each pixel, so that they differ only by the noise of the lighting)
+func TestDenoiseReference(t *testing.T) {
+	rt, scene, lights := denoiseTestScene(1)
+	noisy, _, err := rt.DrawPasses(context.Background(), scene, lights, AOVAlbedo, AOVNormal)
+	if !assert(t, err == nil, "Denoise: render error "+errString(err)) {
+		return
+	}
+	rt, _, _ = denoiseTestScene(64)
+	ref, _, _ := rt.DrawPasses(context.Background(), scene, lights)
+
+	// (a little of the error remains: the sparkles of the blurred reflections are mostly smoothed away, rather than spread out)
+	noisyError := MSE(noisy.beauty, ref.beauty)
+	for _, test := range []struct{ strength, maxError entry }{{0.5, 0.95}, {1, 0.7}, {2, 0.7}} {
+		denoised, err := noisy.Denoised(test.strength)
+		if !assert(t, err == nil, "Denoise: error "+errString(err)) {
+			return
+		}
+		act := MSE(denoised.beauty, ref.beauty)
+		assert(t, act < noisyError*test.maxError, fmt.Sprint("Denoise: the error at strength ", test.strength, " is ", act, ", and without denoising ", noisyError))
+	}
+
+	// the passes are shared, and a strength of 0 leaves the image as it is
+	denoised, _ := noisy.Denoised(0)
+	assertEquals(t, fmt.Sprint(noisy.beauty), fmt.Sprint(denoised.beauty), "Denoise: strength 0")
+	assertEquals(t, &noisy.passes[AOVNormal][0], &denoised.passes[AOVNormal][0], "Denoise: the passes of the copy")
+
+	_, err = ref.Denoised(1)
+	assertEquals(t, ErrMissingPasses, err, "Denoise: a render without the passes")
+}
+
+// the edges of the albedo and the normals are kept, even where the lighting changes by less than noise would
+func TestDenoiseEdges(t *testing.T) {
+	const width, height = 16, 8
+	colors, albedo, normals := make([]Vec3, width*height), make([]Vec3, width*height), make([]Vec3, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			switch {
+			case x < 6: // a red surface
+				albedo[i], normals[i] = Vec3{0.8, 0.1, 0.1}, Y_V3
+				colors[i] = albedo[i].scale(0.4)
+			case x < 12: // a white surface, facing another way (so it is lit more)
+				albedo[i], normals[i] = Vec3{0.8, 0.8, 0.8}, X_V3
+				colors[i] = albedo[i].scale(0.6)
+			}
+		}
+	}
+	act, err := Denoise(width, height, colors, albedo, normals, 1)
+	if !assert(t, err == nil, "Denoise: error "+errString(err)) {
+		return
+	}
+	for i := range colors {
+		assert(t, act[i].minus(colors[i]).magnitude() < 1e-3, fmt.Sprint("Denoise: pixel (", i%width, ",", i/width, ") is ", act[i], ", not ", colors[i]))
+	}
+}
+
+// the noise of a surface is smoothed
+func TestDenoiseNoise(t *testing.T) {
+	const width, height = 32, 32
+	colors, exp := make([]Vec3, width*height), make([]Vec3, width*height)
+	albedo, normals := make([]Vec3, width*height), make([]Vec3, width*height)
+	for i := range colors {
+		noise := entry(hashUint32(uint32(i))%1000)/1000*0.3 - 0.15
+		albedo[i], normals[i] = Vec3{0.2, 0.5, 0.8}, Z_V3
+		exp[i], colors[i] = albedo[i].scale(0.5), albedo[i].scale(0.5+noise)
+	}
+	act, _ := Denoise(width, height, colors, albedo, normals, 1)
+	noisyError, actError := MSE(colors, exp), MSE(act, exp)
+	assert(t, actError < noisyError/10, fmt.Sprint("Denoise: the error is ", actError, ", and without denoising ", noisyError))
+}
+
+// a tiny strength smooths nothing (rather than giving NaNs, when the weights of the colours overflow)
+func TestDenoiseTinyStrength(t *testing.T) {
+	const width, height = 8, 8
+	colors, albedo, normals := make([]Vec3, width*height), make([]Vec3, width*height), make([]Vec3, width*height)
+	for i := range colors {
+		albedo[i], normals[i] = Vec3{0.2, 0.5, 0.8}, Z_V3
+		colors[i] = albedo[i].scale(entry(i%3) * 0.1)
+	}
+	for _, strength := range []float64{1e-20, 1e-200} {
+		act, err := Denoise(width, height, colors, albedo, normals, entry(strength))
+		if !assert(t, err == nil, "Denoise: error "+errString(err)) {
+			return
+		}
+		for i := range colors {
+			// (a NaN is not equal to itself)
+			assert(t, act[i] == act[i], fmt.Sprint("Denoise: pixel ", i, " is ", act[i], " at strength ", strength))
+			assertVec3Equals(t, colors[i], act[i], fmt.Sprint("Denoise: pixel ", i, " at strength ", strength))
+		}
+	}
+}
+
+// the colours, albedos and normals must be of every pixel
+func TestDenoiseSizes(t *testing.T) {
+	pixels := make([]Vec3, 6)
+	for _, c := range []struct {
+		width, height           int
+		colors, albedo, normals []Vec3
+	}{
+		{3, 2, pixels[:5], pixels, pixels},
+		{3, 2, pixels, pixels[:1], pixels},
+		{3, 2, pixels, pixels, nil},
+		{4, 2, pixels, pixels, pixels},
+		{-3, -2, pixels, pixels, pixels},
+	} {
+		_, err := Denoise(c.width, c.height, c.colors, c.albedo, c.normals, 1)
+		assert(t, err != nil, fmt.Sprint("Denoise: expected an error for ", len(c.colors), " colours, ", len(c.albedo), " albedos and ",
+			len(c.normals), " normals of ", c.width, "x", c.height, " pixels"))
+	}
+	_, err := Denoise(3, 2, pixels, pixels, pixels, 1)
+	assert(t, err == nil, "Denoise: error "+errString(err))
+}
@@ -0,0 +1,320 @@
+// distributed.go: Contains distributed rendering, in which a Coordinator splits the image into tiles
+// and serves them (and the scene) over HTTP to workers, which render them and send back their pixels.
+//
+// The protocol:
+//	GET  /scene                  the scene, as a (self-contained) SceneFile in json
+//	POST /tiles/next?worker=name a tile to render, as json {"ID","X0","Y0","X1","Y1"} (pixels [X0,X1) x [Y0,Y1)),
+//	                             or 204 (No Content) if every tile is being rendered, or 410 (Gone) once the image is done
+//	PUT  /tiles/{id}?worker=name the colours of the pixels of the tile, in rows from the top-left,
+//	                             as little-endian float32 red, green and blue
+// A tile is leased to a worker for a time: if its pixels do not arrive in time (e.g. the worker died),
+// the tile is given to another worker. (the first pixels to arrive are kept)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a tile of the image, and its lease
+type tile struct {
+	ID             int
+	X0, Y0, X1, Y1 int
+	worker         string    // the worker it was last given to ("" if none)
+	deadline       time.Time // when the lease of the worker expires
+	done           bool
+}
+
+// A Coordinator serves the tiles of an image to workers (as an http.Handler), and collects their pixels.
+type Coordinator struct {
+	scene         []byte // the json of the scene
+	width, height int
+	leaseTimeout  time.Duration
+
+	mu        sync.Mutex
+	tiles     []*tile
+	colors    []Vec3 // the pixels received so far
+	remaining int    // the number of tiles not yet done
+	done      chan struct{}
+}
+
+// NewCoordinator creates a Coordinator for the scene (with its meshes embedded, so workers need no files),
+// split into tiles of tileSize x tileSize pixels, which are given to another worker if their pixels have not
+// arrived after leaseTimeout (which should be well above the time to render a tile).
+func NewCoordinator(scene *SceneFile, tileSize int, leaseTimeout time.Duration) (*Coordinator, error) {
+	if tileSize <= 0 {
+		return nil, fmt.Errorf("distributed: the tile size %d is invalid", tileSize)
+	}
+	if leaseTimeout <= 0 {
+		return nil, fmt.Errorf("distributed: the lease timeout %v is invalid", leaseTimeout)
+	}
+	if err := scene.Embed(); err != nil {
+		return nil, err
+	}
+	if _, _, _, err := scene.Build(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(scene)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := scene.Camera.Width, scene.Camera.Height
+	c := &Coordinator{
+		scene: data, width: width, height: height, leaseTimeout: leaseTimeout,
+		colors: make([]Vec3, width*height), done: make(chan struct{}),
+	}
+	for y := 0; y < height; y += tileSize {
+		for x := 0; x < width; x += tileSize {
+			c.tiles = append(c.tiles, &tile{ID: len(c.tiles), X0: x, Y0: y, X1: minInt(x+tileSize, width), Y1: minInt(y+tileSize, height)})
+		}
+	}
+	c.remaining = len(c.tiles)
+	return c, nil
+}
+
+// ServeHTTP handles the requests of workers.
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch path := req.URL.Path; {
+	case path == "/scene" && req.Method == http.MethodGet:
+		c.serveScene(w, req)
+	case path == "/tiles/next" && req.Method == http.MethodPost:
+		c.serveNextTile(w, req)
+	case strings.HasPrefix(path, "/tiles/") && req.Method == http.MethodPut:
+		c.serveTileResult(w, req, strings.TrimPrefix(path, "/tiles/"))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (c *Coordinator) serveScene(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(c.scene)
+}
+
+func (c *Coordinator) serveNextTile(w http.ResponseWriter, req *http.Request) {
+	t, done := c.nextTile(req.URL.Query().Get("worker"), time.Now())
+	if t == nil {
+		if done {
+			w.WriteHeader(http.StatusGone)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// lease the next tile to the worker: one which has not been given out yet, or else the one whose lease
+// expired first (nil if there is none, along with whether every tile is done)
+func (c *Coordinator) nextTile(worker string, now time.Time) (*tile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var next *tile
+	for _, t := range c.tiles {
+		if t.done || now.Before(t.deadline) {
+			continue
+		}
+		if t.worker == "" {
+			next = t
+			break
+		}
+		if next == nil || t.deadline.Before(next.deadline) {
+			next = t
+		}
+	}
+	if next == nil {
+		return nil, c.remaining == 0
+	}
+	next.worker, next.deadline = worker, now.Add(c.leaseTimeout)
+	res := *next
+	return &res, false
+}
+
+func (c *Coordinator) serveTileResult(w http.ResponseWriter, req *http.Request, tileID string) {
+	id, err := strconv.Atoi(tileID)
+	if err != nil || id < 0 || id >= len(c.tiles) {
+		http.Error(w, "unknown tile", http.StatusNotFound)
+		return
+	}
+	t := c.tiles[id] // (the bounds of a tile never change)
+	n := (t.X1 - t.X0) * (t.Y1 - t.Y0)
+	data, err := io.ReadAll(io.LimitReader(req.Body, int64(n*V3LEN*4+1)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) != n*V3LEN*4 {
+		http.Error(w, fmt.Sprintf("expected %d pixels", n), http.StatusBadRequest)
+		return
+	}
+	c.setTile(t, decodePixels(data))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// set the pixels of a tile (unless they already arrived from another worker)
+func (c *Coordinator) setTile(t *tile, colors []Vec3) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.done {
+		return
+	}
+	i := 0
+	for y := t.Y0; y < t.Y1; y++ {
+		copy(c.colors[y*c.width+t.X0:y*c.width+t.X1], colors[i:i+t.X1-t.X0])
+		i += t.X1 - t.X0
+	}
+	t.done = true
+	c.remaining--
+	if c.remaining == 0 {
+		close(c.done)
+	}
+}
+
+// Wait blocks until every tile is done (or ctx is cancelled, returning ctx.Err()).
+func (c *Coordinator) Wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pixels returns the colours of the pixels received so far (black for the others), in rows from the top-left.
+func (c *Coordinator) Pixels() []Vec3 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Vec3(nil), c.colors...)
+}
+
+// Image returns the image of the pixels received so far (black for the others).
+func (c *Coordinator) Image() *image.RGBA {
+	return colorsImage(c.width, c.height, c.Pixels())
+}
+
+// encode colours as little-endian float32 red, green and blue
+func encodePixels(colors []Vec3) []byte {
+	data := make([]byte, 0, len(colors)*V3LEN*4)
+	for _, color := range colors {
+		for _, e := range color {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(e)))
+		}
+	}
+	return data
+}
+
+// decode colours from little-endian float32 red, green and blue
+func decodePixels(data []byte) []Vec3 {
+	colors := make([]Vec3, len(data)/(V3LEN*4))
+	for i := range colors {
+		for j := range colors[i] {
+			colors[i][j] = entry(math.Float32frombits(binary.LittleEndian.Uint32(data[(i*V3LEN+j)*4:])))
+		}
+	}
+	return colors
+}
+
+// the time between asking a busy coordinator for a tile
+const workerPollInterval = 100 * time.Millisecond
+
+// RunWorker renders tiles for the coordinator at the url (e.g. "http://host:8080"), identifying itself by name,
+// until the image is done (returning nil), or ctx is cancelled, or the coordinator cannot be reached.
+func RunWorker(ctx context.Context, coordinator, name string) error {
+	data, err := workerRequest(ctx, http.MethodGet, coordinator+"/scene", nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	sceneFile, err := ParseSceneFile(data, "")
+	if err != nil {
+		return err
+	}
+	rt, scene, lights, err := sceneFile.Build()
+	if err != nil {
+		return err
+	}
+
+	query := "?worker=" + url.QueryEscape(name)
+	for {
+		res, err := workerDo(ctx, http.MethodPost, coordinator+"/tiles/next"+query, nil)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		switch res.StatusCode {
+		case http.StatusGone:
+			return nil
+		case http.StatusNoContent:
+			// every tile is being rendered, but a worker may die
+			select {
+			case <-time.After(workerPollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		case http.StatusOK:
+		default:
+			return fmt.Errorf("distributed: %s: %s", coordinator, res.Status)
+		}
+
+		var t tile
+		if err := json.Unmarshal(data, &t); err != nil {
+			return fmt.Errorf("distributed: %s: %v", coordinator, err)
+		}
+		colors, err := rt.DrawTile(ctx, scene, lights, t.X0, t.Y0, t.X1, t.Y1)
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("%s/tiles/%d%s", coordinator, t.ID, query)
+		if _, err := workerRequest(ctx, http.MethodPut, path, encodePixels(colors), http.StatusNoContent); err != nil {
+			return err
+		}
+	}
+}
+
+// make a request of the coordinator
+func workerDo(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// make a request of the coordinator, returning the body of the response (which must have the given status)
+func workerRequest(ctx context.Context, method, path string, body []byte, status int) ([]byte, error) {
+	res, err := workerDo(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != status {
+		return nil, fmt.Errorf("distributed: %s: %s", path, res.Status)
+	}
+	return data, nil
+}
@@ -0,0 +1,206 @@
+// contains tests for distributed.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// the tiles of an image, each rendered alone, are the same as the whole image
+func TestDrawTile(t *testing.T) {
+	scene, lights := benchmarkScene()
+	view := &Camera{Vec3{0, 0, 6}, ZERO_V3, Y_V3, 20, 14, entry(60)}
+	r := NewRayTracer(view, &RayTracerOptions{1, 2, 1, 1, ZERO, NewSobolSampler(3), NewMitchellFilter(2, 1.0/3, 1.0/3), 0, ZERO})
+	exp, _, _ := r.DrawContext(context.Background(), scene, lights)
+
+	act := NewOutputImage(20, 14)
+	for y := 0; y < 14; y += 8 {
+		for x := 0; x < 20; x += 8 {
+			x1, y1 := minInt(x+8, 20), minInt(y+8, 14)
+			colors, err := r.DrawTile(context.Background(), scene, lights, x, y, x1, y1)
+			if !assert(t, err == nil && len(colors) == (x1-x)*(y1-y), fmt.Sprint("DrawTile: error ", err)) {
+				return
+			}
+			for i, color := range colors {
+				Set(act, x+i%(x1-x), y+i/(x1-x), color)
+			}
+		}
+	}
+	assert(t, string(exp.Pix) == string(act.Pix), "DrawTile: the tiles differ from the whole image")
+}
+
+// a coordinator for the test scene (with a mesh file, which has to be embedded)
+func testCoordinator(t *testing.T, tileSize int, lease time.Duration) *Coordinator {
+	s, err := LoadSceneFile(writeTestScene(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCoordinator(s, tileSize, lease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// the tiles and their leases must be positive
+func TestNewCoordinatorErrors(t *testing.T) {
+	for _, c := range []struct {
+		tileSize int
+		lease    time.Duration
+	}{{0, time.Minute}, {-16, time.Minute}, {16, 0}, {16, -time.Second}} {
+		s, err := LoadSceneFile(writeTestScene(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewCoordinator(s, c.tileSize, c.lease)
+		assert(t, err != nil, fmt.Sprint("Coordinator: expected an error for tiles of ", c.tileSize, " and a lease of ", c.lease))
+	}
+}
+
+// tiles are leased to one worker at a time, and reassigned once their lease expires
+func TestCoordinatorLeases(t *testing.T) {
+	c := testCoordinator(t, 16, time.Minute)
+	assertEquals(t, 2, len(c.tiles), "Coordinator: number of tiles (of a 24x16 image)")
+	assertEquals(t, "[16 0 24 16]", fmt.Sprint([]int{c.tiles[1].X0, c.tiles[1].Y0, c.tiles[1].X1, c.tiles[1].Y1}), "Coordinator: the bounds of a tile")
+
+	now := time.Now()
+	a, _ := c.nextTile("a", now)
+	b, _ := c.nextTile("b", now.Add(time.Second))
+	none, done := c.nextTile("c", now.Add(2*time.Second))
+	assert(t, a != nil && b != nil && a.ID != b.ID, "Coordinator: expected a different tile for each worker")
+	assert(t, none == nil && !done, "Coordinator: expected no tile while all are leased")
+
+	// the lease of a expires first
+	next, _ := c.nextTile("c", now.Add(time.Minute+time.Second))
+	if assert(t, next != nil, "Coordinator: expected an expired tile") {
+		assertEquals(t, a.ID, next.ID, "Coordinator: the tile reassigned")
+	}
+
+	// the first pixels to arrive are kept
+	pixels := make([]Vec3, 16*16)
+	for i := range pixels {
+		pixels[i] = ONE_V3
+	}
+	c.setTile(c.tiles[a.ID], pixels)
+	c.setTile(c.tiles[a.ID], make([]Vec3, 16*16))
+	assertVec3Equals(t, ONE_V3, c.Pixels()[c.tiles[a.ID].X0], "Coordinator: pixel of a tile")
+	assertEquals(t, 1, c.remaining, "Coordinator: tiles remaining")
+
+	c.setTile(c.tiles[b.ID], make([]Vec3, 8*16))
+	none, done = c.nextTile("c", now)
+	assert(t, none == nil && done, "Coordinator: expected every tile to be done")
+	assert(t, c.Wait(context.Background()) == nil, "Coordinator: wait")
+}
+
+func TestCoordinatorRejectsBadPixels(t *testing.T) {
+	c := testCoordinator(t, 16, time.Minute)
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	for path, size := range map[string]int{"/tiles/0": 3, "/tiles/9": 16 * 16, "/tiles/x": 16 * 16} {
+		_, err := workerRequest(context.Background(), http.MethodPut, server.URL+path, encodePixels(make([]Vec3, size)), http.StatusNoContent)
+		assert(t, err != nil, "Coordinator: expected an error for "+path)
+	}
+	assertEquals(t, 2, c.remaining, "Coordinator: tiles remaining")
+}
+
+func TestPixelEncoding(t *testing.T) {
+	colors := []Vec3{{0, 0.5, 1}, {-2, 1e6, 0.25}}
+	act := decodePixels(encodePixels(colors))
+	assertEquals(t, len(colors), len(act), "Pixels: number decoded")
+	for i := range colors {
+		assertVec3Equals(t, colors[i], act[i], "Pixels: decoded")
+	}
+}
+
+// the environment variables which make the test binary a worker (see TestDistributedWorkerProcess)
+const (
+	testCoordinatorEnv = "RAYTRACER_TEST_COORDINATOR"
+	testWorkerDiesEnv  = "RAYTRACER_TEST_WORKER_DIES"
+)
+
+// start the test binary as a worker process, for the coordinator at url
+func startTestWorker(t *testing.T, url, name string, dies bool) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDistributedWorkerProcess$")
+	cmd.Env = append(os.Environ(), testCoordinatorEnv+"="+url, "RAYTRACER_TEST_WORKER="+name)
+	if dies {
+		cmd.Env = append(cmd.Env, testWorkerDiesEnv+"=1")
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+// not a test: the worker processes of TestDistributedRender
+func TestDistributedWorkerProcess(t *testing.T) {
+	url, name := os.Getenv(testCoordinatorEnv), os.Getenv("RAYTRACER_TEST_WORKER")
+	if url == "" {
+		t.Skip("only run as a worker process")
+	}
+	if os.Getenv(testWorkerDiesEnv) != "" {
+		// take a tile, and die before rendering it
+		workerRequest(context.Background(), http.MethodPost, url+"/tiles/next?worker="+name, nil, http.StatusOK)
+		os.Exit(3)
+	}
+	if err := RunWorker(context.Background(), url, name); err != nil {
+		fmt.Fprintln(os.Stderr, "worker", name, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// several worker processes render the same image as a local render, even when one dies
+func TestDistributedRender(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts worker processes")
+	}
+	c := testCoordinator(t, 8, 500*time.Millisecond)
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	// the first worker takes a tile and dies (so the tile has to be reassigned, once its lease expires)
+	startTestWorker(t, server.URL, "dies", true).Wait()
+	workers := make([]*exec.Cmd, 3)
+	for i := range workers {
+		workers[i] = startTestWorker(t, server.URL, fmt.Sprint("worker", i), false)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if !assert(t, c.Wait(ctx) == nil, "Distributed: the image was not finished") {
+		for _, w := range workers {
+			w.Process.Kill()
+		}
+		return
+	}
+	for i, w := range workers {
+		assert(t, w.Wait() == nil, fmt.Sprint("Distributed: worker ", i, " failed"))
+	}
+	for _, tile := range c.tiles {
+		assert(t, tile.done && tile.worker != "dies", fmt.Sprint("Distributed: tile ", tile.ID, " was not reassigned"))
+	}
+
+	// the pixels are those of a local render (as float32)
+	s, _ := ParseSceneFile(c.scene, "")
+	r, scene, lights, _ := s.Build()
+	exp, _ := r.DrawTile(context.Background(), scene, lights, 0, 0, r.width, r.height)
+	act := c.Pixels()
+	for i := range exp {
+		for j := range exp[i] {
+			if !assert(t, abs(exp[i][j]-act[i][j]) <= 1e-6*maxEntry(ONE, abs(exp[i][j])), fmt.Sprint("Distributed: pixel ", i, " is ", act[i], " not ", exp[i])) {
+				return
+			}
+		}
+	}
+	img, _, _ := r.DrawContext(context.Background(), scene, lights)
+	assert(t, string(img.Pix) == string(c.Image().Pix), "Distributed: different image from a local render")
+}
@@ -0,0 +1,24 @@
+//go:build float32
+
+// entry_float32.go: Selects single precision for vectors, matrices, shapes and colours.
+// This halves the memory used by huge meshes (at the cost of precision), and is enabled with `-tags float32`.
+
+package main
+
+import "math"
+
+// each element of a matrix/vector
+type entry float32
+
+// the relative rounding error of an entry (half of the spacing between 1 and the next entry)
+const machineEpsilon = 0x1p-24
+
+// the next representable entry above e
+func nextEntryUp(e entry) entry {
+	return entry(math.Nextafter32(float32(e), float32(math.Inf(1))))
+}
+
+// the next representable entry below e
+func nextEntryDown(e entry) entry {
+	return entry(math.Nextafter32(float32(e), float32(math.Inf(-1))))
+}
@@ -0,0 +1,28 @@
+//go:build float32
+
+// contains tests for entry_float32.go
+
+package main
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// the tolerances of (absolute) equality checks: coarser than for float64, since an entry only has 24 bits of precision.
+// Tests whose error accumulates check with a wider tolerance: over several operations on values far from 1, or for tangent (grazing)
+// intersections, which keep only about half of the bits.
+const (
+	TOLERANCE          float64 = 0.00001
+	ROUNDING_TOLERANCE float64 = 0.0001
+	GRAZING_TOLERANCE  float64 = 0.002
+)
+
+func TestEntryIsSinglePrecision(t *testing.T) {
+	assertEquals(t, uintptr(4), unsafe.Sizeof(entry(0)), "float32: size of an entry")
+	assertEquals(t, uintptr(12), unsafe.Sizeof(Vec3{}), "float32: size of a Vec3")
+
+	// the machine epsilon is the largest value which is lost when added to one:
+	assertEquals(t, ONE, ONE+entry(machineEpsilon), "float32: 1 + epsilon")
+	assert(t, ONE+2*entry(machineEpsilon) > ONE, "float32: 1 + 2*epsilon should exceed 1")
+}
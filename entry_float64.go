@@ -0,0 +1,24 @@
+//go:build !float32
+
+// entry_float64.go: Selects double precision for vectors, matrices, shapes and colours (the default).
+// Build with `-tags float32` to use single precision instead (see entry_float32.go).
+
+package main
+
+import "math"
+
+// each element of a matrix/vector
+type entry float64
+
+// the relative rounding error of an entry (half of the spacing between 1 and the next entry)
+const machineEpsilon = 0x1p-53
+
+// the next representable entry above e
+func nextEntryUp(e entry) entry {
+	return entry(math.Nextafter(float64(e), math.Inf(1)))
+}
+
+// the next representable entry below e
+func nextEntryDown(e entry) entry {
+	return entry(math.Nextafter(float64(e), math.Inf(-1)))
+}
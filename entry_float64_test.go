@@ -0,0 +1,27 @@
+//go:build !float32
+
+// contains tests for entry_float64.go
+
+package main
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// the tolerances of (absolute) equality checks: (the same for tests whose error accumulates, which use a wider tolerance
+// only for float32 entries)
+const (
+	TOLERANCE          float64 = 0.00000001
+	ROUNDING_TOLERANCE         = TOLERANCE
+	GRAZING_TOLERANCE          = TOLERANCE
+)
+
+func TestEntryIsDoublePrecision(t *testing.T) {
+	assertEquals(t, uintptr(8), unsafe.Sizeof(entry(0)), "float64: size of an entry")
+	assertEquals(t, uintptr(24), unsafe.Sizeof(Vec3{}), "float64: size of a Vec3")
+
+	// the machine epsilon is the largest value which is lost when added to one:
+	assertEquals(t, ONE, ONE+entry(machineEpsilon), "float64: 1 + epsilon")
+	assert(t, ONE+2*entry(machineEpsilon) > ONE, "float64: 1 + 2*epsilon should exceed 1")
+}
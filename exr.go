@@ -0,0 +1,381 @@
+// exr.go: Contains a writer (and a reader) of OpenEXR images, which keep the (high dynamic range) colours of the pixels
+// as floats, rather than clamping them to 8 bits.
+//
+// The images are single-part scanline files, without compression, of 32-bit float channels: R, G and B,
+// along with any layers of render passes (e.g. "normal.R", or "depth.Y" for a single channel).
+// The reader also reads 16-bit float (half) and 32-bit integer channels, but not compressed or tiled images.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// constants of the OpenEXR format:
+const (
+	exrMagic      = 20000630
+	exrVersion    = 2
+	exrPixelUint  = 0 // the pixel types of channels: 32-bit unsigned integers,
+	exrPixelHalf  = 1 // 16-bit floats,
+	exrPixelFloat = 2 // and 32-bit floats
+
+	exrTiledFlag     = 0x200  // the flags of the version field: a tiled image,
+	exrMultipartFlag = 0x1000 // or one of several parts
+)
+
+// WriteEXR writes the colours of an image of width x height pixels (in rows from the top-left) as an OpenEXR file.
+func WriteEXR(w io.Writer, width, height int, colors []Vec3) error {
+	return writeEXRChannels(w, width, height, colorChannels("", colors))
+}
+
+// a channel of an OpenEXR image: the value of each pixel, in rows from the top-left
+type exrChannel struct {
+	name   string
+	values []float32
+}
+
+// the R, G and B channels of colours, with names in the layer (e.g. "normal.R"), or without a layer if it is empty
+func colorChannels(layer string, colors []Vec3) []exrChannel {
+	prefix := ""
+	if layer != "" {
+		prefix = layer + "."
+	}
+	channels := []exrChannel{{prefix + "R", nil}, {prefix + "G", nil}, {prefix + "B", nil}}
+	for c := range channels {
+		channels[c].values = make([]float32, len(colors))
+		for i, color := range colors {
+			channels[c].values[i] = float32(color[c])
+		}
+	}
+	return channels
+}
+
+// write the channels of an image of width x height pixels as an OpenEXR file
+func writeEXRChannels(w io.Writer, width, height int, channels []exrChannel) error {
+	out := &exrWriter{w: bufio.NewWriter(w)}
+	out.uint32(exrMagic)
+	out.uint32(exrVersion)
+
+	// the header: a list of attributes (name, type, size and value), ended by an empty name
+	// (the channels are in alphabetical order, as they are stored in that order)
+	channels = append([]exrChannel(nil), channels...)
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+	size := 1
+	for _, c := range channels {
+		size += len(c.name) + 17
+	}
+	out.attribute("channels", "chlist", size)
+	for _, c := range channels {
+		out.string(c.name)
+		out.uint32(exrPixelFloat)
+		out.uint32(0) // pLinear, and 3 reserved bytes
+		out.uint32(1) // xSampling
+		out.uint32(1) // ySampling
+	}
+	out.byte(0)
+	out.attribute("compression", "compression", 1)
+	out.byte(0) // none
+	for _, window := range []string{"dataWindow", "displayWindow"} {
+		out.attribute(window, "box2i", 16)
+		out.uint32(0)
+		out.uint32(0)
+		out.uint32(uint32(width - 1))
+		out.uint32(uint32(height - 1))
+	}
+	out.attribute("lineOrder", "lineOrder", 1)
+	out.byte(0) // increasing y
+	out.attribute("pixelAspectRatio", "float", 4)
+	out.float(1)
+	out.attribute("screenWindowCenter", "v2f", 8)
+	out.float(0)
+	out.float(0)
+	out.attribute("screenWindowWidth", "float", 4)
+	out.float(1)
+	out.byte(0)
+
+	// the offset table (of each scanline, from the start of the file), after which are the scanlines:
+	// the y co-ordinate, the size of the data, and then each channel of the row in turn
+	lineSize := width * len(channels) * 4
+	offset := out.n + uint64(height)*8
+	for y := 0; y < height; y++ {
+		out.uint64(offset)
+		offset += uint64(8 + lineSize)
+	}
+	for y := 0; y < height; y++ {
+		out.uint32(uint32(y))
+		out.uint32(uint32(lineSize))
+		for _, c := range channels {
+			for _, v := range c.values[y*width : (y+1)*width] {
+				out.float(v)
+			}
+		}
+	}
+
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// SaveEXR saves the colours of an image (as WriteEXR) to the file at path.
+func SaveEXR(path string, width, height int, colors []Vec3) error {
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = WriteEXR(output, width, height, colors); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
+
+// ReadEXR reads the colours of an OpenEXR image (from its R, G and B channels, or else its Y channel),
+// returning its size and the colours of its pixels in rows from the top-left.
+func ReadEXR(r io.Reader) (width, height int, colors []Vec3, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	in := &exrReader{r: bytes.NewReader(data)}
+	if in.uint32() != exrMagic {
+		return 0, 0, nil, errors.New("not an OpenEXR image")
+	}
+	if version := in.uint32(); version&0xff != exrVersion || version&(exrTiledFlag|exrMultipartFlag) != 0 {
+		return 0, 0, nil, fmt.Errorf("unsupported OpenEXR version %#x (only single-part scanline images can be read)", version)
+	}
+
+	// the header: only the channels, the compression and the data window are needed
+	type channel struct {
+		name      string
+		pixelType uint32
+	}
+	var channels []channel
+	var x0, y0, x1, y1 int32
+	compression := -1
+	for name := in.string(); name != "" && in.err == nil; name = in.string() {
+		typ, size := in.string(), in.uint32()
+		switch {
+		case name == "channels" && typ == "chlist":
+			for c := in.string(); c != "" && in.err == nil; c = in.string() {
+				channels = append(channels, channel{c, in.uint32()})
+				in.uint32() // pLinear, and 3 reserved bytes
+				if xSampling, ySampling := in.uint32(), in.uint32(); xSampling != 1 || ySampling != 1 {
+					return 0, 0, nil, fmt.Errorf("unsupported subsampling of the OpenEXR channel %s", c)
+				}
+			}
+		case name == "compression" && typ == "compression":
+			compression = int(in.byte())
+		case name == "dataWindow" && typ == "box2i":
+			x0, y0, x1, y1 = int32(in.uint32()), int32(in.uint32()), int32(in.uint32()), int32(in.uint32())
+		default:
+			in.skip(int64(size))
+		}
+	}
+	if in.err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid OpenEXR header: %v", in.err)
+	}
+	if compression != 0 {
+		return 0, 0, nil, fmt.Errorf("unsupported OpenEXR compression %d (only uncompressed images can be read)", compression)
+	}
+	width, height = int(x1-x0+1), int(y1-y0+1)
+	if width <= 0 || height <= 0 || int64(width)*int64(height) > int64(len(data)) {
+		return 0, 0, nil, fmt.Errorf("invalid OpenEXR data window (%d,%d)-(%d,%d)", x0, y0, x1, y1)
+	}
+
+	// the components of the colours which each channel fills (none, for the channels of other layers)
+	components := make([][]int, len(channels))
+	lineSize := 0
+	grey := true
+	for i, c := range channels {
+		switch c.name {
+		case "R":
+			components[i], grey = []int{cX}, false
+		case "G":
+			components[i], grey = []int{cY}, false
+		case "B":
+			components[i], grey = []int{cZ}, false
+		}
+		switch c.pixelType {
+		case exrPixelHalf:
+			lineSize += width * 2
+		case exrPixelUint, exrPixelFloat:
+			lineSize += width * 4
+		default:
+			return 0, 0, nil, fmt.Errorf("unsupported OpenEXR pixel type %d", c.pixelType)
+		}
+	}
+	for i, c := range channels {
+		if grey && c.name == "Y" {
+			components[i] = []int{cX, cY, cZ}
+		}
+	}
+
+	// the offset table (of each scanline), and then the scanlines
+	colors = make([]Vec3, width*height)
+	offsets := make([]uint64, height)
+	for i := range offsets {
+		offsets[i] = in.uint64()
+	}
+	for _, offset := range offsets {
+		in.seek(offset)
+		y, size := int32(in.uint32())-y0, in.uint32()
+		if y < 0 || int(y) >= height || int(size) != lineSize {
+			return 0, 0, nil, fmt.Errorf("invalid OpenEXR scanline at offset %d", offset)
+		}
+		row := colors[int(y)*width : int(y+1)*width]
+		for i, c := range channels {
+			for x := range row {
+				var v entry
+				switch c.pixelType {
+				case exrPixelHalf:
+					v = entry(halfToFloat(uint16(in.byte()) | uint16(in.byte())<<8))
+				case exrPixelUint:
+					v = entry(in.uint32())
+				default:
+					v = entry(math.Float32frombits(in.uint32()))
+				}
+				for _, component := range components[i] {
+					row[x][component] = v
+				}
+			}
+		}
+	}
+	if in.err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid OpenEXR scanlines: %v", in.err)
+	}
+	return width, height, colors, nil
+}
+
+// LoadEXR reads the colours of the OpenEXR image at path (as ReadEXR).
+func LoadEXR(path string) (width, height int, colors []Vec3, err error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer input.Close()
+	return ReadEXR(input)
+}
+
+// convert a 16-bit (IEEE 754 half precision) float to a float32
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp, mantissa := uint32(h>>10)&0x1f, uint32(h)&0x3ff
+	switch {
+	case exp == 0x1f: // infinity, or not a number
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	case exp == 0: // zero, or subnormal (2^-14 * mantissa/1024)
+		f := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mantissa<<13)
+}
+
+// reads the little-endian values of an OpenEXR file, keeping the first error
+type exrReader struct {
+	r   *bytes.Reader
+	err error
+	buf [8]byte
+}
+
+func (e *exrReader) read(b []byte) {
+	if e.err == nil {
+		_, e.err = io.ReadFull(e.r, b)
+	}
+}
+
+func (e *exrReader) byte() byte {
+	e.read(e.buf[:1])
+	return e.buf[0]
+}
+
+func (e *exrReader) uint32() uint32 {
+	e.read(e.buf[:4])
+	return binary.LittleEndian.Uint32(e.buf[:4])
+}
+
+func (e *exrReader) uint64() uint64 {
+	e.read(e.buf[:])
+	return binary.LittleEndian.Uint64(e.buf[:])
+}
+
+// a null-terminated string
+func (e *exrReader) string() string {
+	var s []byte
+	for b := e.byte(); b != 0 && e.err == nil; b = e.byte() {
+		s = append(s, b)
+	}
+	return string(s)
+}
+
+func (e *exrReader) skip(n int64) {
+	if e.err == nil {
+		_, e.err = e.r.Seek(n, io.SeekCurrent)
+	}
+}
+
+func (e *exrReader) seek(offset uint64) {
+	if e.err == nil && offset > uint64(e.r.Size()) {
+		e.err = io.ErrUnexpectedEOF
+	}
+	if e.err == nil {
+		_, e.err = e.r.Seek(int64(offset), io.SeekStart)
+	}
+}
+
+// writes the little-endian values of an OpenEXR file, keeping the first error, and the number of bytes written
+type exrWriter struct {
+	w   *bufio.Writer
+	n   uint64
+	err error
+	buf [8]byte
+}
+
+func (e *exrWriter) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+		e.n += uint64(len(b))
+	}
+}
+
+func (e *exrWriter) byte(b byte) {
+	e.write([]byte{b})
+}
+
+func (e *exrWriter) uint32(u uint32) {
+	binary.LittleEndian.PutUint32(e.buf[:], u)
+	e.write(e.buf[:4])
+}
+
+func (e *exrWriter) uint64(u uint64) {
+	binary.LittleEndian.PutUint64(e.buf[:], u)
+	e.write(e.buf[:])
+}
+
+func (e *exrWriter) float(f float32) {
+	e.uint32(math.Float32bits(f))
+}
+
+// a null-terminated string
+func (e *exrWriter) string(s string) {
+	e.write([]byte(s))
+	e.byte(0)
+}
+
+// the start of an attribute of the header (whose value, of size bytes, follows)
+func (e *exrWriter) attribute(name, typ string, size int) {
+	e.string(name)
+	e.string(typ)
+	e.uint32(uint32(size))
+}
@@ -0,0 +1,147 @@
+// contains tests for exr.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+// read the attributes and the pixels of an OpenEXR file (as written by WriteEXR)
+func readTestEXR(t *testing.T, data []byte) (map[string][]byte, [][]float32) {
+	r := bytes.NewReader(data)
+	var magic, version uint32
+	binary.Read(r, binary.LittleEndian, &magic)
+	binary.Read(r, binary.LittleEndian, &version)
+	assertEquals(t, uint32(exrMagic), magic, "EXR: magic number")
+	assertEquals(t, uint32(exrVersion), version, "EXR: version")
+
+	str := func() string {
+		var b []byte
+		for c, _ := r.ReadByte(); c != 0; c, _ = r.ReadByte() {
+			b = append(b, c)
+		}
+		return string(b)
+	}
+	attributes := make(map[string][]byte)
+	for name := str(); name != ""; name = str() {
+		str() // type
+		var size uint32
+		binary.Read(r, binary.LittleEndian, &size)
+		value := make([]byte, size)
+		r.Read(value)
+		attributes[name] = value
+	}
+
+	// the offset table follows the header
+	var window [4]int32
+	binary.Read(bytes.NewReader(attributes["dataWindow"]), binary.LittleEndian, &window)
+	width, height := int(window[2]+1), int(window[3]+1)
+	offsets := make([]uint64, height)
+	binary.Read(r, binary.LittleEndian, offsets)
+
+	// (each row has every channel in turn)
+	channels := len(exrChannelNames(attributes["channels"]))
+	var rows [][]float32
+	for y, offset := range offsets {
+		var line [2]uint32
+		r.Seek(int64(offset), 0)
+		binary.Read(r, binary.LittleEndian, &line)
+		assertEquals(t, uint32(y), line[0], "EXR: y of the scanline")
+		row := make([]float32, width*channels)
+		binary.Read(r, binary.LittleEndian, row)
+		rows = append(rows, row)
+	}
+	return attributes, rows
+}
+
+// the names of the channels of a chlist attribute
+func exrChannelNames(chlist []byte) []string {
+	var names []string
+	for len(chlist) > 1 {
+		end := bytes.IndexByte(chlist, 0)
+		names = append(names, string(chlist[:end]))
+		chlist = chlist[end+17:]
+	}
+	return names
+}
+
+func TestWriteEXR(t *testing.T) {
+	colors := []Vec3{{0, 0.5, 1}, {2, 3, 4}, {-1, 1e6, 0.25}, {0.125, 0.375, 0.75}, {1, 1, 1}, {5, 6, 7}}
+	var buf bytes.Buffer
+	if !assert(t, WriteEXR(&buf, 3, 2, colors) == nil, "EXR: write error") {
+		return
+	}
+	attributes, rows := readTestEXR(t, buf.Bytes())
+
+	for _, name := range []string{"channels", "compression", "dataWindow", "displayWindow", "lineOrder", "pixelAspectRatio", "screenWindowCenter", "screenWindowWidth"} {
+		_, ok := attributes[name]
+		assert(t, ok, "EXR: missing the attribute "+name)
+	}
+	assertEquals(t, "B\x00\x02\x00\x00\x00", string(attributes["channels"][:6]), "EXR: the first channel")
+	assertEquals(t, 2, len(rows), "EXR: number of scanlines")
+
+	// the channels of each row are in the order B, G, R
+	for y, row := range rows {
+		for x := 0; x < 3; x++ {
+			exp := colors[y*3+x]
+			act := Vec3{entry(row[6+x]), entry(row[3+x]), entry(row[x])}
+			assertVec3Equals(t, exp, act, "EXR: pixel")
+		}
+	}
+	assert(t, math.Float32frombits(binary.LittleEndian.Uint32(attributes["pixelAspectRatio"])) == 1, "EXR: pixel aspect ratio")
+}
+
+func TestReadEXR(t *testing.T) {
+	colors := []Vec3{{0, 0.5, 1}, {2, 3, 4}, {-1, 1e6, 0.25}, {0.125, 0.375, 0.75}, {1, 1, 1}, {5, 6, 7}}
+	var buf bytes.Buffer
+	WriteEXR(&buf, 3, 2, colors)
+	data := append([]byte{}, buf.Bytes()...)
+	width, height, act, err := ReadEXR(bytes.NewReader(data))
+	if !assert(t, err == nil, "ReadEXR: error "+errString(err)) {
+		return
+	}
+	assert(t, width == 3 && height == 2, "ReadEXR: size")
+	for i := range colors {
+		assertVec3Equals(t, colors[i], act[i], "ReadEXR: pixel")
+	}
+
+	// the channels of other layers are ignored, and a Y channel is grey
+	buf.Reset()
+	writeEXRChannels(&buf, 2, 1, append(colorChannels("normal", colors[:2]), exrChannel{"Y", []float32{0.25, 3}}))
+	_, _, act, err = ReadEXR(&buf)
+	assert(t, err == nil, "ReadEXR: error "+errString(err))
+	assertVec3Equals(t, Vec3{0.25, 0.25, 0.25}, act[0], "ReadEXR: grey pixel")
+	assertVec3Equals(t, Vec3{3, 3, 3}, act[1], "ReadEXR: grey pixel")
+
+	// unsupported and invalid images
+	compressed := []byte(strings.Replace(string(data), "compression\x00compression\x00\x01\x00\x00\x00\x00", "compression\x00compression\x00\x01\x00\x00\x00\x03", 1))
+	for name, data := range map[string][]byte{
+		"not an image": []byte("P6 3 2 255"),
+		"tiled":        append(append([]byte{}, data[:4]...), append([]byte{2, 2, 0, 0}, data[8:]...)...),
+		"compressed":   compressed,
+		"truncated":    data[:len(data)-4],
+	} {
+		_, _, _, err := ReadEXR(bytes.NewReader(data))
+		assert(t, err != nil, "ReadEXR: expected an error for an image which is "+name)
+	}
+}
+
+func TestHalfToFloat(t *testing.T) {
+	for h, exp := range map[uint16]float32{
+		0x0000: 0,
+		0x3C00: 1,
+		0x3800: 0.5,
+		0xC000: -2,
+		0x7BFF: 65504,
+		0x0001: 1.0 / (1 << 24),
+		0x8400: -1.0 / (1 << 14),
+		0x7C00: float32(math.Inf(1)),
+	} {
+		assertEquals(t, exp, halfToFloat(h), "halfToFloat")
+	}
+	assert(t, math.IsNaN(float64(halfToFloat(0x7E00))), "halfToFloat: NaN")
+}
@@ -0,0 +1,161 @@
+// filter.go: Contains the reconstruction filters, which weight each sample by its offset from a pixel.
+
+package main
+
+import "math"
+
+// A Filter weights the samples near a pixel, by their offset (in pixels) from the center of the pixel.
+// The weight is zero beyond the radius (in each of x and y), which may exceed one pixel.
+type Filter interface {
+	Radius() entry
+	Evaluate(dx, dy entry) entry
+}
+
+// BoxFilter implementation of Filter: all samples within the radius are equally weighted.
+// (a radius of 0.5 averages the samples within each pixel)
+type BoxFilter struct {
+	radius entry
+}
+
+// NewBoxFilter creates a BoxFilter of the given radius.
+func NewBoxFilter(radius entry) *BoxFilter {
+	return &BoxFilter{radius}
+}
+
+// Radius returns the radius of the filter.
+func (f *BoxFilter) Radius() entry {
+	return f.radius
+}
+
+// Evaluate returns the weight of a sample at offset (dx,dy).
+func (f *BoxFilter) Evaluate(dx, dy entry) entry {
+	// (half-open, so that a sample on the edge between pixels is only in one)
+	if dx <= -f.radius || dx > f.radius || dy <= -f.radius || dy > f.radius {
+		return ZERO
+	}
+	return ONE
+}
+
+// TentFilter implementation of Filter: the weight falls linearly to zero at the radius.
+type TentFilter struct {
+	radius entry
+}
+
+// NewTentFilter creates a TentFilter of the given radius.
+func NewTentFilter(radius entry) *TentFilter {
+	return &TentFilter{radius}
+}
+
+// Radius returns the radius of the filter.
+func (f *TentFilter) Radius() entry {
+	return f.radius
+}
+
+// Evaluate returns the weight of a sample at offset (dx,dy).
+func (f *TentFilter) Evaluate(dx, dy entry) entry {
+	return maxEntry(ZERO, f.radius-abs(dx)) * maxEntry(ZERO, f.radius-abs(dy))
+}
+
+// GaussianFilter implementation of Filter: a Gaussian of standard deviation sigma,
+// shifted down so that it falls smoothly to zero at the radius.
+type GaussianFilter struct {
+	radius, sigma entry
+}
+
+// NewGaussianFilter creates a GaussianFilter of the given radius and standard deviation (e.g. 1.5 and 0.5).
+func NewGaussianFilter(radius, sigma entry) *GaussianFilter {
+	return &GaussianFilter{radius, sigma}
+}
+
+// Radius returns the radius of the filter.
+func (f *GaussianFilter) Radius() entry {
+	return f.radius
+}
+
+// the (unnormalized) gaussian of standard deviation sigma at x
+func gaussian(x, sigma entry) entry {
+	return entry(math.Exp(-float64(x*x) / float64(2*sigma*sigma)))
+}
+
+// the 1D gaussian at x, less its value at the radius
+func (f *GaussianFilter) gaussian(x entry) entry {
+	return maxEntry(ZERO, gaussian(x, f.sigma)-gaussian(f.radius, f.sigma))
+}
+
+// Evaluate returns the weight of a sample at offset (dx,dy).
+func (f *GaussianFilter) Evaluate(dx, dy entry) entry {
+	return f.gaussian(dx) * f.gaussian(dy)
+}
+
+// MitchellFilter implementation of Filter: the cubic filter of Mitchell and Netravali,
+// with parameters b and c (b = c = 1/3 is a good balance between blurring and ringing).
+type MitchellFilter struct {
+	radius, b, c entry
+}
+
+// NewMitchellFilter creates a MitchellFilter of the given radius (e.g. 2), and parameters b and c.
+func NewMitchellFilter(radius, b, c entry) *MitchellFilter {
+	return &MitchellFilter{radius, b, c}
+}
+
+// Radius returns the radius of the filter.
+func (f *MitchellFilter) Radius() entry {
+	return f.radius
+}
+
+// the 1D cubic at x in [-2,2]
+func (f *MitchellFilter) mitchell(x entry) entry {
+	b, c := f.b, f.c
+	x = abs(x)
+	if x <= 1 {
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	}
+	if x <= 2 {
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	}
+	return ZERO
+}
+
+// Evaluate returns the weight of a sample at offset (dx,dy).
+func (f *MitchellFilter) Evaluate(dx, dy entry) entry {
+	// (the cubic is scaled to the radius)
+	return f.mitchell(TWO*dx/f.radius) * f.mitchell(TWO*dy/f.radius)
+}
+
+// LanczosFilter implementation of Filter: the sinc function, windowed by a wider sinc
+// which reaches zero at the radius (in pixels, e.g. 2 or 3).
+type LanczosFilter struct {
+	radius entry
+}
+
+// NewLanczosFilter creates a LanczosFilter of the given radius.
+func NewLanczosFilter(radius entry) *LanczosFilter {
+	return &LanczosFilter{radius}
+}
+
+// Radius returns the radius of the filter.
+func (f *LanczosFilter) Radius() entry {
+	return f.radius
+}
+
+// the normalized sinc function: sin(pi x) / (pi x)
+func sinc(x entry) entry {
+	if abs(x) < 1e-5 {
+		return ONE
+	}
+	px := math.Pi * float64(x)
+	return entry(math.Sin(px) / px)
+}
+
+// the 1D windowed sinc at x
+func (f *LanczosFilter) lanczos(x entry) entry {
+	if abs(x) >= f.radius {
+		return ZERO
+	}
+	return sinc(x) * sinc(x/f.radius)
+}
+
+// Evaluate returns the weight of a sample at offset (dx,dy).
+func (f *LanczosFilter) Evaluate(dx, dy entry) entry {
+	return f.lanczos(dx) * f.lanczos(dy)
+}
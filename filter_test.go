@@ -0,0 +1,65 @@
+// contains tests for filter.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// each of the filters, with a radius of 2 pixels
+func testFilters() map[string]Filter {
+	return map[string]Filter{
+		"box":      NewBoxFilter(2),
+		"tent":     NewTentFilter(2),
+		"gaussian": NewGaussianFilter(2, 0.5),
+		"mitchell": NewMitchellFilter(2, 1.0/3, 1.0/3),
+		"lanczos":  NewLanczosFilter(2),
+	}
+}
+
+// the filters are largest at the center, symmetric, and zero beyond their radius
+func TestFilterShape(t *testing.T) {
+	for name, f := range testFilters() {
+		center := f.Evaluate(ZERO, ZERO)
+		assert(t, center > 0, fmt.Sprint(name, ": weight at the center ", center))
+		for _, d := range []entry{0.25, 0.5, 1, 1.5, 1.9} {
+			w := f.Evaluate(d, d/2)
+			assert(t, w <= center, fmt.Sprint(name, ": weight at ", d, " exceeds the center"))
+			assert(t, !w.neq(f.Evaluate(-d, -d/2)) && !w.neq(f.Evaluate(d/2, d)), fmt.Sprint(name, ": not symmetric at ", d))
+		}
+		for _, d := range []entry{2.01, 3, 10} {
+			assertEquals(t, ZERO, f.Evaluate(d, ZERO), fmt.Sprint(name, ": weight at ", d, " (x)"))
+			assertEquals(t, ZERO, f.Evaluate(ZERO, -d), fmt.Sprint(name, ": weight at ", -d, " (y)"))
+		}
+	}
+}
+
+// the box filter of radius 0.5 covers exactly one pixel (and an edge between pixels is in only one)
+func TestBoxFilterCoversOnePixel(t *testing.T) {
+	f := NewBoxFilter(0.5)
+	assertEquals(t, ONE, f.Evaluate(0.5, -0.49), "Box filter: inside")
+	assertEquals(t, ZERO, f.Evaluate(-0.5, ZERO), "Box filter: edge of the next pixel")
+	assertEquals(t, ZERO, f.Evaluate(0.51, ZERO), "Box filter: outside")
+}
+
+// the weights of the Mitchell-Netravali cubic (in 1D) sum to one, and it has a small negative lobe
+func TestMitchellFilter(t *testing.T) {
+	f := NewMitchellFilter(2, 1.0/3, 1.0/3)
+	for _, offset := range []entry{0, 0.25, 0.5} {
+		sum := ZERO
+		for x := entry(-3); x <= 3; x++ {
+			sum += f.mitchell(x + offset)
+		}
+		assert(t, !sum.neq(ONE), fmt.Sprint("Mitchell: sum of weights at offset ", offset, " is ", sum))
+	}
+	assert(t, f.mitchell(1.5) < 0 && f.mitchell(1.5) > -0.05, fmt.Sprint("Mitchell: negative lobe ", f.mitchell(1.5)))
+	assert(t, abs(f.mitchell(TWO)) < 1e-6, fmt.Sprint("Mitchell: zero at 2, not ", f.mitchell(TWO)))
+}
+
+func TestLanczosFilter(t *testing.T) {
+	f := NewLanczosFilter(2)
+	assertEquals(t, ONE, f.lanczos(ZERO), "Lanczos: one at the center")
+	assert(t, abs(f.lanczos(ONE)) < 1e-6, fmt.Sprint("Lanczos: zero at 1, not ", f.lanczos(ONE)))
+	assert(t, f.lanczos(1.5) < 0, "Lanczos: negative lobe")
+}
@@ -0,0 +1,58 @@
+// float.go: Contains bounds on floating-point (rounding) errors, which are used to
+// start new rays from an intersection point without hitting the same surface again.
+//
+// Each shape bounds the error of the intersection point it computes (Intersection.pError),
+// and rays are started outside of that error bound, by offsetting them along the normal.
+// The offset is therefore proportional to the precision of the point, rather than fixed,
+// so it works equally well for tiny and huge scenes.
+
+package main
+
+import "math"
+
+// the distance to infinity (e.g. the end of an unbounded ray interval)
+var INF = entry(math.Inf(1))
+
+// a bound on the relative error of n successive floating-point operations
+func gamma(n int) entry {
+	ne := entry(n) * machineEpsilon
+	return ne / (ONE - ne)
+}
+
+// the elementwise absolute value of a vector
+func absVec(v Vec3) Vec3 {
+	return Vec3{abs(v[cX]), abs(v[cY]), abs(v[cZ])}
+}
+
+// the error bound of transforming a point p (whose own error bound is pError) by an affine matrix
+func transformPointError(m Mat4, p, pError Vec3) Vec3 {
+	var res Vec3
+	g := gamma(3)
+	for i := 0; i < V3LEN; i++ {
+		r := m[i*V4LEN : (i+1)*V4LEN]
+		res[i] = g*(abs(r[0]*p[cX])+abs(r[1]*p[cY])+abs(r[2]*p[cZ])+abs(r[3])) +
+			(ONE+g)*(abs(r[0])*pError[cX]+abs(r[1])*pError[cY]+abs(r[2])*pError[cZ])
+	}
+	return res
+}
+
+// offset a point (whose error bound is pError) along the normal, to start a ray in direction dir:
+// the point is moved to the side of the surface which dir leaves from, beyond its error bound.
+func offsetRayOrigin(point, pError, normal, dir Vec3) Vec3 {
+	d := absVec(normal).dot(pError)
+	offset := normal.scale(d)
+	if dir.dot(normal) < 0 {
+		offset = offset.scale(-ONE)
+	}
+	res := point.plus(offset)
+
+	// round away from the point, so that the offset is not lost to rounding:
+	for i := 0; i < V3LEN; i++ {
+		if offset[i] > 0 {
+			res[i] = nextEntryUp(res[i])
+		} else if offset[i] < 0 {
+			res[i] = nextEntryDown(res[i])
+		}
+	}
+	return res
+}
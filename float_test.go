@@ -0,0 +1,117 @@
+// contains tests for float.go
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// a (convex or planar) shape to test, with a sphere (center, extent) which contains it
+type robustnessCase struct {
+	name   string
+	shape  Shape
+	center Vec3
+	extent entry
+}
+
+// shapes of the given scale, placed away from the origin (which makes their co-ordinates less precise)
+func robustnessShapes(s entry) []robustnessCase {
+	mat := &Material{}
+	sphereCenter := Vec3{7, -3, 11}.scale(s)
+	ellipsoidCenter := Vec3{-40, 25, 3}.scale(s)
+
+	// an axis-aligned quad, with exactly representable corners:
+	q := entry(math.Exp2(math.Round(math.Log2(float64(s)))))
+	quad := NewQuad(Vec3{96, -5, 0}.scale(q), Vec3{104, -5, 0}.scale(q), Vec3{104, -5, -8}.scale(q), Vec3{96, -5, -8}.scale(q), mat)
+
+	// a tilted square mesh, and an instance of the unit square mesh:
+	o, u, v := Vec3{300, 200, -100}.scale(s), Vec3{1, 0.5, 0.2}.scale(s), Vec3{0, 0.3, 1}.scale(s)
+	mesh := NewMesh([]Vec3{o, o.plus(u), o.plus(u).plus(v), o.plus(v)}, nil, nil, nil, []uint32{0, 1, 2, 0, 2, 3}, mat)
+	trans := NewTRS(Vec3{1000, -500, 300}.scale(s), QuaternionFromEuler(30, 40, 50), Vec3{s, s, s})
+
+	return []robustnessCase{
+		{"sphere", NewSphere(s, sphereCenter, mat), sphereCenter, s},
+		{"ellipsoid", NewRotatedEllipsoid(Vec3{1, 2, 0.5}.scale(s), ellipsoidCenter, Vec3{1, 1, 0}.direction(), 30, mat), ellipsoidCenter, 2 * s},
+		{"quad", quad, Vec3{100, -5, -4}.scale(q), 6 * q},
+		{"mesh", mesh, o.plus(u.plus(v).scale(0.5)), 1.5 * s},
+		{"instance", NewInstance(newSquareMesh(nil), trans, nil), trans.transformPoint(Vec3{0.5, 0.5, 0}), s},
+	}
+}
+
+// a uniformly random direction
+func randomDirection(rng *rand.Rand) Vec3 {
+	return sphericalDirection(entry(2*rng.Float64()-1), entry(rng.Float64()))
+}
+
+// rays leaving a surface (from the side which was hit) should not hit it again (i.e. no shadow acne),
+// and should start very close to the surface (so no light leaks), at any scale.
+func TestSpawnedRaysDoNotSelfIntersect(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	for _, scale := range []entry{1e-3, 1e-1, 1, 1e2, 1e5} {
+		for _, c := range robustnessShapes(scale) {
+			msg := fmt.Sprint("Self-intersection of ", c.name, " at scale ", scale)
+			maxOffset := 100 * machineEpsilon * (Vec4(toV4(absVec(c.center), c.extent)).dot(Vec4{1, 1, 1, 1}))
+			hits, acne, leaks := 0, 0, 0
+
+			for k := 0; k < 500; k++ {
+				// aim at the shape from a random point around it:
+				target := c.center.plus(randomDirection(rng).scale(c.extent * entry(rng.Float64())))
+				start := c.center.plus(randomDirection(rng).scale(4 * c.extent))
+				ray := Ray{start, target.minus(start).direction()}
+				hit, inter := c.shape.Intersect(ray, ZERO, INF)
+				if !hit {
+					continue
+				}
+				hits++
+
+				// leave in a random direction, and in the reflected direction:
+				n := facingNormal(inter.normal, ray.direction.scale(-ONE))
+				for _, dir := range []Vec3{randomHemisphere(rng, n), reflect(ray.direction, inter.normal)} {
+					r := spawnRay(&inter, dir)
+					if h, _ := c.shape.Intersect(r, ZERO, INF); h {
+						acne++
+					}
+					if r.start.distanceTo(inter.point) > maxOffset {
+						leaks++
+					}
+				}
+			}
+			assert(t, hits > 50, msg+fmt.Sprint(": too few hits ", hits))
+			assertEquals(t, 0, acne, msg+": rays which hit the surface again")
+			assertEquals(t, 0, leaks, msg+": rays which start too far from the surface")
+		}
+	}
+}
+
+// the error bound of a point on a sphere should contain its actual distance from the surface
+func TestSpherePointErrorBound(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	for _, scale := range []entry{1e-3, 1, 1e5} {
+		center, radius := Vec3{1000, -2000, 500}.scale(scale), scale
+		s := NewSphere(radius, center, &Material{})
+		for k := 0; k < 200; k++ {
+			start := center.plus(randomDirection(rng).scale(3 * radius))
+			_, inter := s.Intersect(Ray{start, center.minus(start).direction()}, ZERO, INF)
+			var d2 float64
+			for i := 0; i < V3LEN; i++ {
+				d := float64(inter.point[i]) - float64(center[i])
+				d2 += d * d
+			}
+			err := math.Abs(math.Sqrt(d2) - float64(radius))
+			assert(t, err <= float64(inter.pError.magnitude()), fmt.Sprint("Sphere error bound at scale ", scale, ": ", err, " > ", inter.pError))
+		}
+	}
+}
+
+func TestOffsetRayOrigin(t *testing.T) {
+	point, pError := Vec3{3, 4, 1e5}, Vec3{1e-3, 1e-3, 1e-3}
+
+	// the offset is along the normal, beyond the error, on the side of the direction:
+	up := offsetRayOrigin(point, pError, Z_V3, Vec3{1, 0, 1})
+	down := offsetRayOrigin(point, pError, Z_V3, Vec3{1, 0, -1})
+	assert(t, up[cZ] > point[cZ]+pError[cZ] && up[cX] == point[cX], fmt.Sprint("Offset up: ", up))
+	assert(t, down[cZ] < point[cZ]-pError[cZ] && down[cY] == point[cY], fmt.Sprint("Offset down: ", down))
+}
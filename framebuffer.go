@@ -0,0 +1,86 @@
+// framebuffer.go: Contains the Framebuffer, which accumulates the weighted samples of each pixel.
+
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// A Framebuffer holds the weighted sum of the colours of the samples near each pixel,
+// along with the sum of their weights (from a reconstruction Filter), for a region of the image.
+type Framebuffer struct {
+	x0, y0        int     // the top-left pixel of the region
+	width, height int     // the size of the region, in pixels
+	colors        []Vec3  // the weighted sum of the colours, in rows from the top-left
+	weights       []entry // the sum of the weights
+}
+
+// NewFramebuffer creates an empty Framebuffer of the given size, in pixels.
+func NewFramebuffer(width, height int) *Framebuffer {
+	return newFramebufferRegion(0, 0, width, height)
+}
+
+// create an empty Framebuffer for the region of the image of the given size, from the pixel (x0,y0)
+func newFramebufferRegion(x0, y0, width, height int) *Framebuffer {
+	return &Framebuffer{x0, y0, width, height, make([]Vec3, width*height), make([]entry, width*height)}
+}
+
+// Splat adds a sample at the point (px,py) of the image (the center of pixel (x,y) is at (x+0.5, y+0.5))
+// to every pixel (of the region) within the radius of the filter, weighted by its offset from each.
+func (f *Framebuffer) Splat(px, py entry, color Vec3, filter Filter) {
+	// find the pixels whose centers are within the radius:
+	r := filter.Radius()
+	x0 := maxInt(f.x0, int(math.Ceil(float64(px-0.5-r))))
+	x1 := minInt(f.x0+f.width-1, int(math.Floor(float64(px-0.5+r))))
+	y0 := maxInt(f.y0, int(math.Ceil(float64(py-0.5-r))))
+	y1 := minInt(f.y0+f.height-1, int(math.Floor(float64(py-0.5+r))))
+
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			if w := filter.Evaluate(entry(x)+0.5-px, entry(y)+0.5-py); w != 0 {
+				i := f.index(x, y)
+				f.colors[i].addScaledInPlace(color, w)
+				f.weights[i] += w
+			}
+		}
+	}
+}
+
+// the index of the pixel (x,y) of the image, in the region
+func (f *Framebuffer) index(x, y int) int {
+	return (y-f.y0)*f.width + (x - f.x0)
+}
+
+// Color returns the colour of the pixel (x,y) of the image: the weighted average of its samples
+// (which is black if it has none).
+func (f *Framebuffer) Color(x, y int) Vec3 {
+	i := f.index(x, y)
+	if f.weights[i] <= 0 {
+		return ZERO_V3
+	}
+	return f.colors[i].scale(ONE / f.weights[i])
+}
+
+// Pixels returns the colours of the pixels of the region (as Color), in rows from the top-left.
+func (f *Framebuffer) Pixels() []Vec3 {
+	colors := make([]Vec3, 0, f.width*f.height)
+	for y := f.y0; y < f.y0+f.height; y++ {
+		for x := f.x0; x < f.x0+f.width; x++ {
+			colors = append(colors, f.Color(x, y))
+		}
+	}
+	return colors
+}
+
+// Image returns the colours of the pixels of the region as an image (with the same bounds as the region)
+// (negative colours, from filters with negative lobes, are clamped to black).
+func (f *Framebuffer) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(f.x0, f.y0, f.x0+f.width, f.y0+f.height))
+	for y := f.y0; y < f.y0+f.height; y++ {
+		for x := f.x0; x < f.x0+f.width; x++ {
+			Set(img, x, y, f.Color(x, y))
+		}
+	}
+	return img
+}
@@ -0,0 +1,96 @@
+// contains tests for framebuffer.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// splat samples on a fine grid over the framebuffer, with the colour of each given by color(x, y)
+func splatGrid(fb *Framebuffer, f Filter, color func(x, y entry) Vec3) {
+	const perPixel = 8
+	for j := 0; j < fb.height*perPixel; j++ {
+		for i := 0; i < fb.width*perPixel; i++ {
+			x, y := (entry(i)+0.5)/perPixel, (entry(j)+0.5)/perPixel
+			fb.Splat(x, y, color(x, y), f)
+		}
+	}
+}
+
+// the box filter of radius 0.5 averages the samples within each pixel
+func TestFramebufferBoxAverage(t *testing.T) {
+	fb := NewFramebuffer(3, 2)
+	f := NewBoxFilter(0.5)
+	fb.Splat(1.2, 0.3, Vec3{1, 0, 0}, f)
+	fb.Splat(1.8, 0.9, Vec3{0, 1, 0.5}, f)
+	fb.Splat(2.5, 1.5, Vec3{0, 0, 1}, f)
+	assertVec3Equals(t, Vec3{0.5, 0.5, 0.25}, fb.Color(1, 0), "Box average: pixel (1,0)")
+	assertVec3Equals(t, Vec3{0, 0, 1}, fb.Color(2, 1), "Box average: pixel (2,1)")
+	assertVec3Equals(t, ZERO_V3, fb.Color(0, 0), "Box average: pixel without samples")
+}
+
+// a sample contributes to every pixel within the radius of the filter (which may exceed one pixel)
+func TestFramebufferSplatRadius(t *testing.T) {
+	fb := NewFramebuffer(7, 7)
+	fb.Splat(3.5, 3.5, Vec3{1, 1, 1}, NewTentFilter(2))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 7; x++ {
+			inside := abs(entry(x-3)) < 2 && abs(entry(y-3)) < 2
+			assert(t, (fb.weights[y*7+x] > 0) == inside, fmt.Sprint("Splat radius: weight of pixel ", x, ",", y, " is ", fb.weights[y*7+x]))
+		}
+	}
+}
+
+// a constant image is reconstructed exactly (including at the edges), by every filter
+func TestFramebufferConstantImage(t *testing.T) {
+	col := Vec3{0.2, 0.5, 0.7}
+	for name, f := range testFilters() {
+		fb := NewFramebuffer(6, 4)
+		splatGrid(fb, f, func(x, y entry) Vec3 { return col })
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 6; x++ {
+				assertVec3Equals(t, col, fb.Color(x, y), fmt.Sprint(name, ": constant image at ", x, ",", y))
+			}
+		}
+	}
+}
+
+// an edge (from black to white) is reconstructed without (much) ringing:
+// the pixels are within [0,1], and increase across the edge.
+func TestFramebufferEdgeRinging(t *testing.T) {
+	const width = 12
+	edge := func(x, y entry) Vec3 {
+		if x < width/2 {
+			return ZERO_V3
+		}
+		return Vec3{1, 1, 1}
+	}
+
+	maxRinging := map[string]entry{"box": 0, "tent": 0, "gaussian": 0, "mitchell": 0.02, "lanczos": 0.1}
+	for name, f := range testFilters() {
+		fb := NewFramebuffer(width, 1)
+		splatGrid(fb, f, edge)
+		prev := -ONE
+		for x := 0; x < width; x++ {
+			c := fb.Color(x, 0)[cX]
+			ringing := maxEntry(-c, c-ONE)
+			assert(t, ringing <= maxRinging[name]+1e-6, fmt.Sprint(name, ": ringing ", ringing, " at ", x))
+			if maxRinging[name] == 0 {
+				assert(t, c >= prev-1e-6, fmt.Sprint(name, ": not increasing at ", x))
+			}
+			prev = c
+		}
+		assert(t, fb.Color(width/2-1, 0)[cX] < fb.Color(width/2, 0)[cX], name+": edge is lost")
+	}
+}
+
+func TestFramebufferImage(t *testing.T) {
+	fb := NewFramebuffer(2, 1)
+	f := NewBoxFilter(0.5)
+	fb.Splat(0.5, 0.5, Vec3{1, 0.5, -1}, f)
+	img := fb.Image()
+	assert(t, img.Bounds().Dx() == 2 && img.Bounds().Dy() == 1, "Image: size")
+	c := img.RGBAAt(0, 0)
+	assert(t, c.R == 255 && c.G == 128 && c.B == 0 && c.A == 255, fmt.Sprint("Image: colour ", c))
+}
@@ -0,0 +1,624 @@
+// gltf.go: Contains an importer for glTF 2.0 scenes (.gltf and .glb files).
+//
+// The importer maps:
+//	- the node hierarchy (which must be a tree) to the world transforms of Instances,
+//	  which are flattened rather than nested
+//	- meshes to triangle Meshes (one per primitive), shared between the nodes which use them
+//	- perspective cameras to Cameras
+//	- KHR_lights_punctual lights to Lights
+//	- metallic-roughness materials to Materials
+// Buffers must be embedded (as data URIs, or in the binary chunk of a .glb file)
+// or be files relative to the scene: nothing is fetched over the network.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A GLTFScene holds the contents of an imported glTF scene.
+type GLTFScene struct {
+	shapes  []Shape   // one instance for each node with a mesh
+	lights  []Light   // one light for each node with a light
+	cameras []*Camera // one camera for each node with a (perspective) camera
+}
+
+// the json document of a glTF file (only the parts which are used)
+type gltfDocument struct {
+	Scene  *int
+	Scenes []struct {
+		Nodes []int
+	}
+	Nodes       []gltfNode
+	Meshes      []gltfMesh
+	Accessors   []gltfAccessor
+	BufferViews []gltfBufferView
+	Buffers     []gltfBuffer
+	Materials   []gltfMaterial
+	Cameras     []gltfCamera
+	Extensions  struct {
+		Lights struct {
+			Lights []gltfLight
+		} `json:"KHR_lights_punctual"`
+	}
+}
+
+type gltfNode struct {
+	Children    []int
+	Mesh        *int
+	Camera      *int
+	Matrix      []float64 // column-major 4x4 matrix, or:
+	Translation []float64 // translation (x,y,z),
+	Rotation    []float64 // rotation quaternion (x,y,z,w),
+	Scale       []float64 // and scale (x,y,z), applied in the order: scale, rotate, translate.
+	Extensions  struct {
+		Light struct {
+			Light *int
+		} `json:"KHR_lights_punctual"`
+	}
+}
+
+type gltfMesh struct {
+	Primitives []struct {
+		Attributes map[string]int
+		Indices    *int
+		Material   *int
+		Mode       *int
+	}
+}
+
+type gltfAccessor struct {
+	BufferView    *int
+	ByteOffset    int
+	ComponentType int
+	Normalized    bool
+	Count         int
+	Type          string
+	Sparse        *json.RawMessage
+}
+
+type gltfBufferView struct {
+	Buffer     int
+	ByteOffset int
+	ByteLength int
+	ByteStride int
+}
+
+type gltfBuffer struct {
+	URI        string
+	ByteLength int
+}
+
+type gltfMaterial struct {
+	PbrMetallicRoughness struct {
+		BaseColorFactor []float64
+		MetallicFactor  *float64
+		RoughnessFactor *float64
+	}
+	EmissiveFactor []float64
+}
+
+type gltfCamera struct {
+	Type        string
+	Perspective struct {
+		Yfov float64 // in radians
+	}
+}
+
+type gltfLight struct {
+	Type      string
+	Color     []float64
+	Intensity *float64
+}
+
+// constants of the glTF format:
+const (
+	glbMagic         = 0x46546C67 // "glTF"
+	glbChunkJSON     = 0x4E4F534A // "JSON"
+	glbChunkBIN      = 0x004E4942 // "BIN"
+	gltfModeTriangle = 4
+)
+
+// the size in bytes of each accessor component type
+var gltfComponentSizes = map[int]int{
+	5120: 1, // byte
+	5121: 1, // unsigned byte
+	5122: 2, // short
+	5123: 2, // unsigned short
+	5125: 4, // unsigned int
+	5126: 4, // float
+}
+
+// the number of components of each accessor type
+var gltfTypeSizes = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT2": 4, "MAT3": 9, "MAT4": 16}
+
+// the state of an import in progress
+type gltfLoader struct {
+	doc     gltfDocument
+	buffers [][]byte
+	meshes  map[int]Shape // the meshes built so far, so they can be shared
+	loading []bool        // the nodes which are being loaded (i.e. the current node and its ancestors)
+	width   int
+	height  int
+	scene   *GLTFScene
+}
+
+// LoadGLTF imports a .gltf or .glb file. Cameras in the scene are given the image size width x height.
+func LoadGLTF(path string, width, height int) (*GLTFScene, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scene, err := readGLTF(data, filepath.Dir(path), width, height)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return scene, nil
+}
+
+// import a glTF scene from the file contents; dir is used to resolve relative buffer paths.
+func readGLTF(data []byte, dir string, width, height int) (*GLTFScene, error) {
+	l := &gltfLoader{meshes: make(map[int]Shape), width: width, height: height, scene: &GLTFScene{}}
+
+	// a .glb file holds the json, followed by the binary buffer:
+	var bin []byte
+	if len(data) >= 12 && binary.LittleEndian.Uint32(data) == glbMagic {
+		var err error
+		if data, bin, err = readGLBChunks(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, &l.doc); err != nil {
+		return nil, fmt.Errorf("gltf: %v", err)
+	}
+	if err := l.loadBuffers(dir, bin); err != nil {
+		return nil, err
+	}
+
+	// the hierarchy must be a tree: no node may be the child of more than one node
+	// (shared children would be walked once per path to them, which can grow exponentially).
+	isChild := make([]bool, len(l.doc.Nodes))
+	for _, node := range l.doc.Nodes {
+		for _, c := range node.Children {
+			if c >= 0 && c < len(isChild) {
+				if isChild[c] {
+					return nil, fmt.Errorf("gltf: node %d is a child more than once", c)
+				}
+				isChild[c] = true
+			}
+		}
+	}
+
+	// find the root nodes: those of the default scene (or all nodes which are not children)
+	var roots []int
+	if len(l.doc.Scenes) > 0 {
+		scene := 0
+		if l.doc.Scene != nil {
+			scene = *l.doc.Scene
+		}
+		if scene < 0 || scene >= len(l.doc.Scenes) {
+			return nil, fmt.Errorf("gltf: scene %d does not exist", scene)
+		}
+		roots = l.doc.Scenes[scene].Nodes
+	} else {
+		for i := range l.doc.Nodes {
+			if !isChild[i] {
+				roots = append(roots, i)
+			}
+		}
+	}
+
+	l.loading = make([]bool, len(l.doc.Nodes))
+	for _, root := range roots {
+		if err := l.loadNode(root, IDENTITY_T, 0); err != nil {
+			return nil, err
+		}
+	}
+	return l.scene, nil
+}
+
+// split a .glb file into its json and binary chunks
+func readGLBChunks(data []byte) (jsonChunk, binChunk []byte, err error) {
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, nil, fmt.Errorf("gltf: unsupported glb version %d", version)
+	}
+	if length := int(binary.LittleEndian.Uint32(data[8:])); length <= len(data) {
+		data = data[:length]
+	}
+	for offset := 12; offset+8 <= len(data); {
+		length, typ := int(binary.LittleEndian.Uint32(data[offset:])), binary.LittleEndian.Uint32(data[offset+4:])
+		start, end := offset+8, offset+8+length
+		if end > len(data) {
+			return nil, nil, errors.New("gltf: truncated glb chunk")
+		}
+		switch typ {
+		case glbChunkJSON:
+			jsonChunk = data[start:end]
+		case glbChunkBIN:
+			binChunk = data[start:end]
+		}
+		offset = end
+	}
+	if jsonChunk == nil {
+		return nil, nil, errors.New("gltf: glb has no json chunk")
+	}
+	return jsonChunk, binChunk, nil
+}
+
+// load the contents of each buffer
+func (l *gltfLoader) loadBuffers(dir string, bin []byte) error {
+	l.buffers = make([][]byte, len(l.doc.Buffers))
+	for i, buf := range l.doc.Buffers {
+		var data []byte
+		var err error
+		switch {
+		case buf.URI == "":
+			if i != 0 || bin == nil {
+				return fmt.Errorf("gltf: buffer %d has no data", i)
+			}
+			data = bin
+		case strings.HasPrefix(buf.URI, "data:"):
+			comma := strings.Index(buf.URI, ",")
+			if comma < 0 || !strings.HasSuffix(buf.URI[:comma], ";base64") {
+				return fmt.Errorf("gltf: buffer %d: unsupported data uri", i)
+			}
+			data, err = base64.StdEncoding.DecodeString(buf.URI[comma+1:])
+		case strings.Contains(buf.URI, "://"):
+			return fmt.Errorf("gltf: buffer %d: only embedded or relative buffers are supported", i)
+		default:
+			var path string
+			if path, err = url.PathUnescape(buf.URI); err == nil {
+				data, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("gltf: buffer %d: %v", i, err)
+		}
+		if len(data) < buf.ByteLength {
+			return fmt.Errorf("gltf: buffer %d is shorter than its byteLength", i)
+		}
+		l.buffers[i] = data
+	}
+	return nil
+}
+
+// the maximum depth of the node hierarchy
+const gltfMaxDepth = 64
+
+// load a node and its children, given the transform of its parent (into world space)
+func (l *gltfLoader) loadNode(index int, parent Transform, depth int) error {
+	if index < 0 || index >= len(l.doc.Nodes) {
+		return fmt.Errorf("gltf: node %d does not exist", index)
+	}
+	if depth > gltfMaxDepth {
+		return errors.New("gltf: the node hierarchy is too deep")
+	}
+	if l.loading[index] {
+		return fmt.Errorf("gltf: node %d is its own ancestor", index)
+	}
+	l.loading[index] = true
+	defer func() { l.loading[index] = false }()
+
+	node := &l.doc.Nodes[index]
+	world := parent.times(gltfNodeTransform(node))
+
+	if node.Mesh != nil {
+		mesh, err := l.loadMesh(*node.Mesh)
+		if err != nil {
+			return err
+		}
+		if mesh != nil {
+			l.scene.shapes = append(l.scene.shapes, NewInstance(mesh, world, nil))
+		}
+	}
+	if node.Camera != nil {
+		if err := l.loadCamera(*node.Camera, world); err != nil {
+			return err
+		}
+	}
+	if node.Extensions.Light.Light != nil {
+		if err := l.loadLight(*node.Extensions.Light.Light, world); err != nil {
+			return err
+		}
+	}
+	for _, child := range node.Children {
+		if err := l.loadNode(child, world, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the local transform of a node
+func gltfNodeTransform(node *gltfNode) Transform {
+	if len(node.Matrix) == M4LEN {
+		var res Mat4
+		for i, x := range node.Matrix {
+			res[i] = entry(x)
+		}
+		return NewTransform(res.transpose()) // from column-major
+	}
+
+	t, r, s := []float64{0, 0, 0}, []float64{0, 0, 0, 1}, []float64{1, 1, 1}
+	if len(node.Translation) == V3LEN {
+		t = node.Translation
+	}
+	if len(node.Rotation) == V4LEN {
+		r = node.Rotation
+	}
+	if len(node.Scale) == V3LEN {
+		s = node.Scale
+	}
+
+	return NewTRS(
+		Vec3{entry(t[cX]), entry(t[cY]), entry(t[cZ])},
+		Quaternion{entry(r[cX]), entry(r[cY]), entry(r[cZ]), entry(r[cW])}.normalized(),
+		Vec3{entry(s[cX]), entry(s[cY]), entry(s[cZ])},
+	)
+}
+
+// load a mesh (once: later calls share the same shape).
+// Each triangle primitive becomes a Mesh; other primitives (points, lines, strips) are skipped.
+func (l *gltfLoader) loadMesh(index int) (Shape, error) {
+	if shape, ok := l.meshes[index]; ok {
+		return shape, nil
+	}
+	if index < 0 || index >= len(l.doc.Meshes) {
+		return nil, fmt.Errorf("gltf: mesh %d does not exist", index)
+	}
+
+	var parts []Shape
+	for p, prim := range l.doc.Meshes[index].Primitives {
+		if prim.Mode != nil && *prim.Mode != gltfModeTriangle {
+			continue
+		}
+		mesh, err := l.loadPrimitive(prim.Attributes, prim.Indices, prim.Material)
+		if err != nil {
+			return nil, fmt.Errorf("gltf: mesh %d, primitive %d: %v", index, p, err)
+		}
+		parts = append(parts, mesh)
+	}
+
+	var shape Shape
+	switch len(parts) {
+	case 0:
+		shape = nil
+	case 1:
+		shape = parts[0]
+	default:
+		shape = NewGroup(parts...)
+	}
+	l.meshes[index] = shape
+	return shape, nil
+}
+
+// load a triangle primitive of a mesh
+func (l *gltfLoader) loadPrimitive(attributes map[string]int, indices, material *int) (*Mesh, error) {
+	posIndex, ok := attributes["POSITION"]
+	if !ok {
+		return nil, errors.New("no POSITION attribute")
+	}
+	vertices, err := l.readVectors(posIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	// the optional attributes:
+	var buffers [3][]Vec3 // normals, uvs, colors
+	for i, name := range []string{"NORMAL", "TEXCOORD_0", "COLOR_0"} {
+		if a, ok := attributes[name]; ok {
+			if buffers[i], err = l.readVectors(a); err != nil {
+				return nil, err
+			}
+			if len(buffers[i]) != len(vertices) {
+				return nil, fmt.Errorf("%s has %d entries, expected %d", name, len(buffers[i]), len(vertices))
+			}
+		}
+	}
+
+	// the indices (if there are none, the vertices are used in order):
+	var idx []uint32
+	if indices != nil {
+		values, _, err := l.readAccessor(*indices)
+		if err != nil {
+			return nil, err
+		}
+		idx = make([]uint32, len(values))
+		for i, x := range values {
+			if x < 0 || int(x) >= len(vertices) {
+				return nil, fmt.Errorf("index %v out of range", x)
+			}
+			idx[i] = uint32(x)
+		}
+	} else {
+		idx = make([]uint32, len(vertices))
+		for i := range idx {
+			idx[i] = uint32(i)
+		}
+	}
+	if len(idx)%3 != 0 {
+		return nil, errors.New("the number of indices is not a multiple of 3")
+	}
+
+	mat, err := l.loadMaterial(material)
+	if err != nil {
+		return nil, err
+	}
+	return NewMesh(vertices, buffers[0], buffers[1], buffers[2], idx, mat), nil
+}
+
+// read an accessor of (up to) 4 components into vectors; any 4th component (e.g. alpha) is dropped.
+func (l *gltfLoader) readVectors(index int) ([]Vec3, error) {
+	values, n, err := l.readAccessor(index)
+	if err != nil {
+		return nil, err
+	}
+	if n > V4LEN {
+		return nil, fmt.Errorf("accessor %d is not a vector", index)
+	}
+	res := make([]Vec3, len(values)/n)
+	for i := range res {
+		for c := 0; c < n && c < V3LEN; c++ {
+			res[i][c] = entry(values[i*n+c])
+		}
+	}
+	return res, nil
+}
+
+// read all the components of an accessor, returning them and the number of components per element.
+func (l *gltfLoader) readAccessor(index int) (values []float64, n int, err error) {
+	if index < 0 || index >= len(l.doc.Accessors) {
+		return nil, 0, fmt.Errorf("accessor %d does not exist", index)
+	}
+	acc := &l.doc.Accessors[index]
+	size, n := gltfComponentSizes[acc.ComponentType], gltfTypeSizes[acc.Type]
+	switch {
+	case size == 0 || n == 0:
+		return nil, 0, fmt.Errorf("accessor %d has an unknown type", index)
+	case acc.Count < 0:
+		return nil, 0, fmt.Errorf("accessor %d has a negative count %d", index, acc.Count)
+	case acc.Sparse != nil:
+		return nil, 0, fmt.Errorf("accessor %d: sparse accessors are not supported", index)
+	case acc.BufferView == nil:
+		return make([]float64, acc.Count*n), n, nil // all zeros
+	case *acc.BufferView < 0 || *acc.BufferView >= len(l.doc.BufferViews):
+		return nil, 0, fmt.Errorf("accessor %d: buffer view %d does not exist", index, *acc.BufferView)
+	}
+
+	view := &l.doc.BufferViews[*acc.BufferView]
+	if view.Buffer < 0 || view.Buffer >= len(l.buffers) {
+		return nil, 0, fmt.Errorf("buffer view %d: buffer %d does not exist", *acc.BufferView, view.Buffer)
+	}
+	stride := view.ByteStride
+	if stride == 0 {
+		stride = size * n // tightly packed
+	}
+
+	// check that the whole accessor lies within the view, and the view within the buffer:
+	buffer := l.buffers[view.Buffer]
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > len(buffer) {
+		return nil, 0, fmt.Errorf("buffer view %d is out of range", *acc.BufferView)
+	}
+	data := buffer[view.ByteOffset : view.ByteOffset+view.ByteLength]
+	if acc.Count > 0 && (acc.ByteOffset < 0 || acc.ByteOffset+(acc.Count-1)*stride+size*n > len(data)) {
+		return nil, 0, fmt.Errorf("accessor %d is out of range", index)
+	}
+
+	values = make([]float64, acc.Count*n)
+	for i := 0; i < acc.Count; i++ {
+		for c := 0; c < n; c++ {
+			values[i*n+c] = gltfComponent(data[acc.ByteOffset+i*stride+c*size:], acc.ComponentType, acc.Normalized)
+		}
+	}
+	return values, n, nil
+}
+
+// decode a single (little-endian) component
+func gltfComponent(b []byte, componentType int, normalized bool) float64 {
+	var x, max float64
+	switch componentType {
+	case 5120:
+		x, max = float64(int8(b[0])), 127
+	case 5121:
+		x, max = float64(b[0]), 255
+	case 5122:
+		x, max = float64(int16(binary.LittleEndian.Uint16(b))), 32767
+	case 5123:
+		x, max = float64(binary.LittleEndian.Uint16(b)), 65535
+	case 5125:
+		x, max = float64(binary.LittleEndian.Uint32(b)), 1
+	default: // 5126: float
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	if normalized {
+		return math.Max(x/max, -1)
+	}
+	return x
+}
+
+// load a metallic-roughness material
+func (l *gltfLoader) loadMaterial(index *int) (*Material, error) {
+	var m gltfMaterial // the default material, if there is none
+	if index != nil {
+		if *index < 0 || *index >= len(l.doc.Materials) {
+			return nil, fmt.Errorf("material %d does not exist", *index)
+		}
+		m = l.doc.Materials[*index]
+	}
+
+	pbr := &m.PbrMetallicRoughness
+	base, metallic, roughness := Vec3{1, 1, 1}, ONE, ONE
+	if len(pbr.BaseColorFactor) >= V3LEN {
+		base = Vec3{entry(pbr.BaseColorFactor[cX]), entry(pbr.BaseColorFactor[cY]), entry(pbr.BaseColorFactor[cZ])}
+	}
+	if pbr.MetallicFactor != nil {
+		metallic = entry(*pbr.MetallicFactor)
+	}
+	if pbr.RoughnessFactor != nil {
+		roughness = entry(*pbr.RoughnessFactor)
+	}
+	var emission Vec3
+	if len(m.EmissiveFactor) == V3LEN {
+		emission = Vec3{entry(m.EmissiveFactor[cX]), entry(m.EmissiveFactor[cY]), entry(m.EmissiveFactor[cZ])}
+	}
+
+	return NewPBRMaterial(base, emission, metallic, roughness), nil
+}
+
+// load a camera, looking down the -z axis of its node (with +y up)
+func (l *gltfLoader) loadCamera(index int, world Transform) error {
+	if index < 0 || index >= len(l.doc.Cameras) {
+		return fmt.Errorf("gltf: camera %d does not exist", index)
+	}
+	cam := &l.doc.Cameras[index]
+	if cam.Type != "perspective" {
+		return nil // only perspective cameras are supported
+	}
+
+	fovY := degrees(cam.Perspective.Yfov)
+	l.scene.cameras = append(l.scene.cameras, NewCameraFromTransform(world, l.width, l.height, fovY))
+	return nil
+}
+
+// load a light: point and spot lights are placed at the origin of their node,
+// whereas directional lights shine down the -z axis of their node.
+// Spot lights are treated as point lights, since there is no cone falloff.
+func (l *gltfLoader) loadLight(index int, world Transform) error {
+	lights := l.doc.Extensions.Lights.Lights
+	if index < 0 || index >= len(lights) {
+		return fmt.Errorf("gltf: light %d does not exist", index)
+	}
+	light := &lights[index]
+
+	color, intensity := Vec3{1, 1, 1}, ONE
+	if len(light.Color) == V3LEN {
+		color = Vec3{entry(light.Color[cX]), entry(light.Color[cY]), entry(light.Color[cZ])}
+	}
+	if light.Intensity != nil {
+		intensity = entry(*light.Intensity)
+	}
+	color = color.scale(intensity)
+
+	switch light.Type {
+	case "directional":
+		// the Light's direction points towards the light:
+		toLight := world.transformVector(Z_V3).direction()
+		l.scene.lights = append(l.scene.lights, &DirectionalLight{color, toLight})
+	case "point", "spot":
+		// with inverse-square falloff:
+		pos := world.transformPoint(ZERO_V3)
+		l.scene.lights = append(l.scene.lights, &PointLight{color, pos, Vec3{0, 0, 1}})
+	default:
+		return fmt.Errorf("gltf: light %d has unknown type %q", index, light.Type)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// contains tests for gltf.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the binary buffer of the test scene: a unit square in the z=0 plane (4 float positions, then 6 short indices)
+func gltfTestBuffer() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, []float32{0, 0, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0})
+	binary.Write(buf, binary.LittleEndian, []uint16{0, 1, 2, 0, 2, 3})
+	return buf.Bytes()
+}
+
+// the json of the test scene, with the given buffer uri (if any):
+//	- node 0: moved to z=-5, with children:
+//		- node 1: the square, scaled by 2
+//		- node 2: the same square, rotated 90 degrees about y, and moved to x=10
+//	- node 3: a camera at z=5
+//	- node 4: a directional light, rotated -90 degrees about x (so shining down the -y axis)
+//	- node 5: a point light at (1,2,3)
+func gltfTestJSON(uri string) string {
+	if uri != "" {
+		uri = fmt.Sprintf(`"uri": %q,`, uri)
+	}
+	s := math.Sqrt(0.5)
+	return fmt.Sprintf(`{
+		"asset": {"version": "2.0"},
+		"extensionsUsed": ["KHR_lights_punctual"],
+		"scene": 0,
+		"scenes": [{"nodes": [0, 3, 4, 5]}],
+		"nodes": [
+			{"translation": [0, 0, -5], "children": [1, 2]},
+			{"mesh": 0, "scale": [2, 2, 2]},
+			{"mesh": 0, "rotation": [0, %v, 0, %v], "translation": [10, 0, 0]},
+			{"camera": 0, "matrix": [1,0,0,0, 0,1,0,0, 0,0,1,0, 0,0,5,1]},
+			{"rotation": [%v, 0, 0, %v], "extensions": {"KHR_lights_punctual": {"light": 0}}},
+			{"translation": [1, 2, 3], "extensions": {"KHR_lights_punctual": {"light": 1}}}
+		],
+		"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1, "material": 0}]}],
+		"materials": [{"pbrMetallicRoughness": {"baseColorFactor": [1, 0, 0, 1], "metallicFactor": 0, "roughnessFactor": 0.5}}],
+		"cameras": [{"type": "perspective", "perspective": {"yfov": %v, "znear": 0.1}}],
+		"extensions": {"KHR_lights_punctual": {"lights": [
+			{"type": "directional", "intensity": 2},
+			{"type": "point", "color": [1, 0.5, 0]}
+		]}},
+		"accessors": [
+			{"bufferView": 0, "componentType": 5126, "count": 4, "type": "VEC3"},
+			{"bufferView": 1, "componentType": 5123, "count": 6, "type": "SCALAR"}
+		],
+		"bufferViews": [
+			{"buffer": 0, "byteOffset": 0, "byteLength": 48},
+			{"buffer": 0, "byteOffset": 48, "byteLength": 12}
+		],
+		"buffers": [{%s "byteLength": 60}]
+	}`, s, s, -s, s, math.Pi/4, uri)
+}
+
+func TestLoadGLTFWithEmbeddedBuffer(t *testing.T) {
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(gltfTestBuffer())
+	scene, err := readGLTF([]byte(gltfTestJSON(uri)), ".", 320, 240)
+	if assert(t, err == nil, fmt.Sprint("glTF: unexpected error ", err)) {
+		assertGLTFTestScene(t, scene, "glTF (embedded)")
+	}
+}
+
+func TestLoadGLTFWithRelativeBuffer(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "square data.bin"), gltfTestBuffer(), 0644)
+	os.WriteFile(filepath.Join(dir, "scene.gltf"), []byte(gltfTestJSON("square%20data.bin")), 0644)
+
+	scene, err := LoadGLTF(filepath.Join(dir, "scene.gltf"), 320, 240)
+	if assert(t, err == nil, fmt.Sprint("glTF: unexpected error ", err)) {
+		assertGLTFTestScene(t, scene, "glTF (relative)")
+	}
+}
+
+func TestLoadGLB(t *testing.T) {
+	// pad the chunks to 4 bytes, as the format requires:
+	jsonChunk, binChunk := []byte(gltfTestJSON("")), gltfTestBuffer()
+	for len(jsonChunk)%4 != 0 {
+		jsonChunk = append(jsonChunk, ' ')
+	}
+	for len(binChunk)%4 != 0 {
+		binChunk = append(binChunk, 0)
+	}
+
+	glb := &bytes.Buffer{}
+	binary.Write(glb, binary.LittleEndian, []uint32{glbMagic, 2, uint32(12 + 8 + len(jsonChunk) + 8 + len(binChunk))})
+	binary.Write(glb, binary.LittleEndian, []uint32{uint32(len(jsonChunk)), glbChunkJSON})
+	glb.Write(jsonChunk)
+	binary.Write(glb, binary.LittleEndian, []uint32{uint32(len(binChunk)), glbChunkBIN})
+	glb.Write(binChunk)
+
+	scene, err := readGLTF(glb.Bytes(), ".", 320, 240)
+	if assert(t, err == nil, fmt.Sprint("glb: unexpected error ", err)) {
+		assertGLTFTestScene(t, scene, "glb")
+	}
+}
+
+func TestLoadGLTFRejectsRemoteBuffers(t *testing.T) {
+	_, err := readGLTF([]byte(gltfTestJSON("https://example.com/square.bin")), ".", 320, 240)
+	assert(t, err != nil && strings.Contains(err.Error(), "relative"), fmt.Sprint("glTF: expected an error, got ", err))
+}
+
+func TestLoadGLTFRejectsNegativeCounts(t *testing.T) {
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(gltfTestBuffer())
+	for _, accessor := range []string{
+		`{"bufferView": 0, "componentType": 5126, "count": -1, "type": "VEC3"}`,
+		`{"componentType": 5126, "count": -4, "type": "VEC3"}`, // (without a buffer view, the positions are zeros)
+	} {
+		json := strings.Replace(gltfTestJSON(uri), `{"bufferView": 0, "componentType": 5126, "count": 4, "type": "VEC3"}`, accessor, 1)
+		_, err := readGLTF([]byte(json), ".", 320, 240)
+		assert(t, err != nil && strings.Contains(err.Error(), "negative"), fmt.Sprint("glTF: expected an error for ", accessor, ", got ", err))
+	}
+}
+
+func TestLoadGLTFRejectsInvalidHierarchies(t *testing.T) {
+	uri := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(gltfTestBuffer())
+	for name, c := range map[string]struct{ old, new, msg string }{
+		"a cycle":        {`{"mesh": 0, "scale": [2, 2, 2]}`, `{"mesh": 0, "scale": [2, 2, 2], "children": [0]}`, "ancestor"},
+		"its own child":  {`{"camera": 0,`, `{"children": [3], "camera": 0,`, "ancestor"},
+		"a shared child": {`{"camera": 0,`, `{"children": [2], "camera": 0,`, "more than once"},
+	} {
+		json := strings.Replace(gltfTestJSON(uri), c.old, c.new, 1)
+		_, err := readGLTF([]byte(json), ".", 320, 240)
+		assert(t, err != nil && strings.Contains(err.Error(), c.msg), fmt.Sprint("glTF: expected an error for a hierarchy with ", name, ", got ", err))
+	}
+}
+
+func assertGLTFTestScene(t *testing.T, scene *GLTFScene, msg string) {
+	if !assertEquals(t, 2, len(scene.shapes), msg+": number of shapes") {
+		return
+	}
+
+	// both instances share the same mesh:
+	inst1, inst2 := scene.shapes[0].(*Instance), scene.shapes[1].(*Instance)
+	assert(t, inst1.shape == inst2.shape, msg+": expected the mesh to be shared")
+
+	// the first instance is the square, scaled by 2, at z=-5:
+	ray := Ray{Vec3{1.5, 1.5, 10}, Z_V3.scale(-ONE)}
+	exp := &Intersection{point: Vec3{1.5, 1.5, -5}, normal: Z_V3, dist: entry(15)}
+	assertIntersectionEquals(t, scene.shapes[0], ray, true, exp, msg+": instance 1")
+	_, res := scene.shapes[0].Intersect(ray, ZERO, INF)
+	mat := res.shape.GetMaterial()
+	assertEquals(t, Vec3{1, 0, 0}, mat.diffuse, msg+": diffuse colour")
+	assert(t, isMatEqual(mat.specular[:], []entry{0.04, 0.04, 0.04}, V3LEN), msg+fmt.Sprint(": specular colour ", mat.specular))
+
+	// the second instance is the square rotated into the x=10 plane (spanning z from -6 to -5):
+	ray = Ray{Vec3{0, 0.5, -5.5}, X_V3}
+	exp = &Intersection{point: Vec3{10, 0.5, -5.5}, normal: X_V3, dist: entry(10)}
+	assertIntersectionEquals(t, scene.shapes[1], ray, true, exp, msg+": instance 2")
+
+	// the camera:
+	if assertEquals(t, 1, len(scene.cameras), msg+": number of cameras") {
+		cam := scene.cameras[0]
+		assert(t, isMatEqual(cam.pos[:], []entry{0, 0, 5}, V3LEN), msg+fmt.Sprint(": camera position ", cam.pos))
+		assert(t, isMatEqual(cam.lookAt[:], []entry{0, 0, 4}, V3LEN), msg+fmt.Sprint(": camera look-at ", cam.lookAt))
+		assert(t, isMatEqual(cam.up[:], Y_V3[:], V3LEN), msg+fmt.Sprint(": camera up ", cam.up))
+		assert(t, !cam.fovY.neq(45) && cam.width == 320 && cam.height == 240, msg+fmt.Sprint(": camera ", *cam))
+	}
+
+	// the lights:
+	if assertEquals(t, 2, len(scene.lights), msg+": number of lights") {
+		dir := scene.lights[0].(*DirectionalLight)
+		assert(t, isMatEqual(dir.direction[:], Y_V3[:], V3LEN), msg+fmt.Sprint(": light direction ", dir.direction))
+		assertEquals(t, Vec3{2, 2, 2}, dir.color, msg+": directional light colour")
+
+		point := scene.lights[1].(*PointLight)
+		assertEquals(t, Vec3{1, 2, 3}, point.position, msg+": point light position")
+		assertEquals(t, Vec3{1, 0.5, 0}, point.color, msg+": point light colour")
+	}
+}
@@ -0,0 +1,218 @@
+// contains the golden-image tests: canonical scenes are rendered (small, and with fixed seeds),
+// and compared with the reference images in testdata/golden, so that changes to rendering cannot go unnoticed.
+//
+// After a deliberate change to rendering, check the images written for the failures (in testdata/golden/failed),
+// and then update the references with: go test -run Golden -update
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the reference images of the golden tests (in testdata/golden)")
+
+// the directories of the reference images, and of the images of the failures
+var (
+	goldenDir       = filepath.Join("testdata", "golden")
+	goldenFailedDir = filepath.Join(goldenDir, "failed")
+)
+
+// the lowest PSNR (in dB) at which a render matches its reference: renders are deterministic, but are allowed to
+// differ slightly (e.g. between float32 and float64 builds, or with the order of floating-point operations, which
+// changes a few pixels by a level, at around 90dB), while a light 5% brighter is around 40dB
+const goldenMinPSNR = 45
+
+// the size of the golden renders
+const goldenWidth, goldenHeight = 64, 48
+
+// a canonical scene: its ray tracer, shapes and lights
+type goldenScene struct {
+	name  string
+	build func(t *testing.T) (*RayTracer, []Shape, []Light)
+}
+
+var goldenScenes = []goldenScene{
+	{"spheres", goldenSpheres},
+	{"quads", goldenQuads},
+	{"lights", goldenLights},
+	{"materials", goldenMaterials},
+	{"scenefile", goldenSceneFile},
+}
+
+// a ray tracer for the golden renders
+func goldenRayTracer(view *Camera, maxDepth, numShadowRays int) *RayTracer {
+	view.width, view.height = goldenWidth, goldenHeight
+	return NewRayTracer(view, &RayTracerOptions{maxDepth, 2, numShadowRays, 4, ZERO, NewSobolSampler(1), nil, 0, ZERO})
+}
+
+// a grid of Blinn-Phong spheres on a floor, lit by two point lights
+func goldenSpheres(t *testing.T) (*RayTracer, []Shape, []Light) {
+	scene, lights := benchmarkScene()
+	return goldenRayTracer(&Camera{pos: Vec3{0, 0, 6}, lookAt: ZERO_V3, up: Y_V3, fovY: 60}, 2, 2), scene, lights
+}
+
+// a box of coloured quads, with a sphere casting soft shadows
+func goldenQuads(t *testing.T) (*RayTracer, []Shape, []Light) {
+	wall := func(diffuse Vec3) *Material {
+		return &Material{diffuse.scale(0.1), ZERO_V3, diffuse, ZERO_V3, ONE, ONE, nil}
+	}
+	white, red, green := wall(Vec3{0.7, 0.7, 0.7}), wall(Vec3{0.7, 0.1, 0.1}), wall(Vec3{0.1, 0.7, 0.1})
+	shiny := &Material{Vec3{0.05, 0.05, 0.1}, ZERO_V3, Vec3{0.2, 0.2, 0.6}, Vec3{0.5, 0.5, 0.5}, entry(40), ZERO, nil}
+	scene := []Shape{
+		NewQuad(Vec3{-2, -2, 2}, Vec3{2, -2, 2}, Vec3{2, -2, -2}, Vec3{-2, -2, -2}, white), // floor
+		NewQuad(Vec3{-2, 2, -2}, Vec3{2, 2, -2}, Vec3{2, 2, 2}, Vec3{-2, 2, 2}, white),     // ceiling
+		NewQuad(Vec3{-2, -2, -2}, Vec3{2, -2, -2}, Vec3{2, 2, -2}, Vec3{-2, 2, -2}, white), // back
+		NewQuad(Vec3{-2, -2, 2}, Vec3{-2, -2, -2}, Vec3{-2, 2, -2}, Vec3{-2, 2, 2}, red),   // left
+		NewQuad(Vec3{2, -2, -2}, Vec3{2, -2, 2}, Vec3{2, 2, 2}, Vec3{2, 2, -2}, green),     // right
+		NewSphere(entry(0.8), Vec3{0.5, -1.2, -0.5}, shiny),
+	}
+	lights := []Light{&PointLight{Vec3{0.8, 0.8, 0.8}, Vec3{0, 1.8, 0}, Vec3{1, 0, 0.05}}}
+	return goldenRayTracer(&Camera{pos: Vec3{0, 0, 6.5}, lookAt: ZERO_V3, up: Y_V3, fovY: 45}, 2, 4), scene, lights
+}
+
+// coloured point and directional lights (with attenuation) on spheres and a floor
+func goldenLights(t *testing.T) (*RayTracer, []Shape, []Light) {
+	mat := &Material{Vec3{0.02, 0.02, 0.02}, ZERO_V3, Vec3{0.8, 0.8, 0.8}, Vec3{0.3, 0.3, 0.3}, entry(20), ONE, nil}
+	scene := []Shape{
+		NewQuad(Vec3{-5, -1, 3}, Vec3{5, -1, 3}, Vec3{5, -1, -5}, Vec3{-5, -1, -5}, mat),
+		NewSphere(ONE, Vec3{-1.5, 0, -1}, mat),
+		NewEllipsoid(Vec3{0.6, 1, 0.6}, Vec3{1.2, 0, -0.5}, mat),
+	}
+	lights := []Light{
+		&PointLight{Vec3{0.9, 0.3, 0.2}, Vec3{-3, 2, 2}, Vec3{1, 0, 0.02}},
+		&PointLight{Vec3{0.2, 0.4, 0.9}, Vec3{3, 1, 1}, Vec3{0.5, 0.2, 0}},
+		&DirectionalLight{Vec3{0.2, 0.2, 0.15}, Vec3{0.3, 1, 0.5}},
+	}
+	return goldenRayTracer(&Camera{pos: Vec3{0, 1.5, 5}, lookAt: Vec3{0, -0.3, 0}, up: Y_V3, fovY: 50}, 1, 2), scene, lights
+}
+
+// a row of materials: diffuse, glossy, mirror, physically based metal and plastic, and emissive,
+// on a glossy floor (so that they are reflected)
+func goldenMaterials(t *testing.T) (*RayTracer, []Shape, []Light) {
+	floor := &Material{Vec3{0.05, 0.05, 0.05}, ZERO_V3, Vec3{0.4, 0.4, 0.4}, Vec3{0.3, 0.3, 0.3}, entry(20), entry(0.2), nil}
+	materials := []*Material{
+		{Vec3{0.05, 0.02, 0.02}, ZERO_V3, Vec3{0.8, 0.2, 0.2}, ZERO_V3, ONE, ONE, nil},
+		{Vec3{0.02, 0.05, 0.02}, ZERO_V3, Vec3{0.2, 0.6, 0.2}, Vec3{0.4, 0.4, 0.4}, entry(30), entry(0.3), nil},
+		{ZERO_V3, ZERO_V3, Vec3{0.05, 0.05, 0.05}, Vec3{0.9, 0.9, 0.9}, entry(200), ZERO, nil},
+		NewPBRMaterial(Vec3{1, 0.8, 0.4}, ZERO_V3, ONE, entry(0.3)),
+		NewPBRMaterial(Vec3{0.1, 0.2, 0.8}, ZERO_V3, ZERO, entry(0.4)),
+		{ZERO_V3, Vec3{0.9, 0.7, 0.3}, ZERO_V3, ZERO_V3, ONE, ONE, nil},
+	}
+	scene := []Shape{NewQuad(Vec3{-6, -0.5, 3}, Vec3{6, -0.5, 3}, Vec3{6, -0.5, -4}, Vec3{-6, -0.5, -4}, floor)}
+	for i, mat := range materials {
+		scene = append(scene, NewSphere(entry(0.45), Vec3{entry(i) - 2.5, 0, 0}, mat))
+	}
+	lights := []Light{
+		&PointLight{Vec3{0.7, 0.7, 0.7}, Vec3{0, 4, 4}, X_V3},
+		&DirectionalLight{Vec3{0.2, 0.2, 0.2}, Vec3{-1, 1, 1}},
+	}
+	return goldenRayTracer(&Camera{pos: Vec3{0, 1.2, 5}, lookAt: ZERO_V3, up: Y_V3, fovY: 45}, 3, 2), scene, lights
+}
+
+// the scene file of the golden render: spheres, a quad, meshes in a group and an instance, and the options of the file
+const goldenSceneJSON = `{
+	"Camera": {"Position": [0, 0, 6], "LookAt": [0, 0, 0], "Up": [0, 1, 0], "Width": 64, "Height": 48, "FovY": 60},
+	"Options": {"MaxDepth": 2, "SamplingFactor": 2, "Sampler": {"Type": "sobol", "Seed": 3}, "Filter": {"Type": "mitchell", "Radius": 2, "B": 0.33, "C": 0.33}},
+	"Materials": {
+		"red": {"Ambient": [0.3, 0.1, 0.1], "Diffuse": [0.6, 0.2, 0.2], "Specular": [0.3, 0.3, 0.3], "Shininess": 20},
+		"gold": {"Type": "pbr", "BaseColor": [1, 0.8, 0.3], "Metallic": 1, "Roughness": 0.4}
+	},
+	"Shapes": [
+		{"Type": "sphere", "Material": "red", "Center": [-1, 0, 0], "Radius": 1},
+		{"Type": "ellipsoid", "Material": "gold", "Center": [1.5, 0, 0], "Radii": [0.5, 1, 0.5], "Axis": [0, 0, 1], "Angle": 30},
+		{"Type": "quad", "Material": "red", "Points": [[-4, -2, 2], [4, -2, 2], [4, -2, -4], [-4, -2, -4]]},
+		{"Type": "group", "Shapes": [
+			{"Type": "instance", "Material": "gold", "Translation": [-1, 1, 0], "Rotation": [0, 45, 0], "Scale": [1, 1, 1],
+				"Shape": {"Type": "mesh", "Vertices": [[0, 0, 0], [1, 0, 0], [1, 1, 0], [0, 1, 0]], "Indices": [0, 1, 2, 0, 2, 3]}},
+			{"Type": "mesh", "Material": "red", "Vertices": [[0, 1, -1], [1, 1, -1], [1, 2, -1]], "Indices": [0, 1, 2]}
+		]}
+	],
+	"Lights": [
+		{"Type": "point", "Color": [0.6, 0.6, 0.6], "Position": [0, 5, 3], "Attenuation": [1, 0, 0]},
+		{"Type": "directional", "Color": [0.3, 0.3, 0.3], "Direction": [-1, 1, 1]}
+	]
+}`
+
+// the scene file, built
+func goldenSceneFile(t *testing.T) (*RayTracer, []Shape, []Light) {
+	s, err := ParseSceneFile([]byte(goldenSceneJSON), "")
+	if err != nil {
+		t.Fatal("Golden: " + err.Error())
+	}
+	rt, scene, lights, err := s.Build()
+	if err != nil {
+		t.Fatal("Golden: " + err.Error())
+	}
+	return rt, scene, lights
+}
+
+func loadPNG(path string) (image.Image, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return png.Decode(input)
+}
+
+// each canonical scene renders as its reference image
+func TestGolden(t *testing.T) {
+	for _, golden := range goldenScenes {
+		golden := golden
+		t.Run(golden.name, func(t *testing.T) {
+			rt, scene, lights := golden.build(t)
+			act := rt.Draw(scene, lights)
+			path := filepath.Join(goldenDir, golden.name+".png")
+			if *updateGolden {
+				os.MkdirAll(goldenDir, 0755)
+				if err := saveImg(path, act); err != nil {
+					t.Fatal(err)
+				}
+				t.Log("Golden: updated " + path)
+				return
+			}
+
+			exp, err := loadPNG(path)
+			if err != nil {
+				t.Fatalf("Golden: no reference image (%v): create it with go test -run Golden -update", err)
+			}
+			expColors, actColors, err := comparableImages(exp, act)
+			if err != nil {
+				t.Fatal("Golden: " + err.Error())
+			}
+			if psnr := PSNR(expColors, actColors); psnr < goldenMinPSNR {
+				// (write the render, and its differences from the reference, for inspection)
+				os.MkdirAll(goldenFailedDir, 0755)
+				actPath := filepath.Join(goldenFailedDir, golden.name+".png")
+				diffPath := filepath.Join(goldenFailedDir, golden.name+"-diff.png")
+				saveImg(actPath, act)
+				saveImg(diffPath, DiffImage(goldenWidth, goldenHeight, expColors, actColors, 8))
+				t.Errorf("Golden: the render differs from %s (PSNR %.1fdB, below %ddB): see %s and %s",
+					path, psnr, goldenMinPSNR, actPath, diffPath)
+			}
+		})
+	}
+}
+
+// small changes to a scene are noticed: they are below the PSNR threshold
+func TestGoldenThreshold(t *testing.T) {
+	rt, scene, lights := goldenLights(t)
+	exp := imageColors(rt.Draw(scene, lights))
+	for i, change := range []func(){
+		func() { lights[0].(*PointLight).color.scaleInPlace(1.05) },           // a light 5% brighter
+		func() { scene[2].(*Sphere).mat = &Material{} },                       // a shape with another material
+		func() { rt.options.numShadowRays, rt.options.samplingFactor = 1, 1 }, // fewer samples
+	} {
+		rt, scene, lights = goldenLights(t)
+		change()
+		psnr := PSNR(exp, imageColors(rt.Draw(scene, lights)))
+		assert(t, psnr < goldenMinPSNR, fmt.Sprint("Golden: change ", i, " has a PSNR of ", psnr))
+	}
+}
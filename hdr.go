@@ -0,0 +1,134 @@
+// hdr.go: Contains a reader of Radiance HDR (RGBE) images, whose pixels are high dynamic range colours:
+// 8-bit red, green and blue mantissas sharing an 8-bit exponent.
+//
+// The scanlines may be flat, or run-length encoded (as most writers do), from the top-left ("-Y height +X width").
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// ReadHDR reads the colours of a Radiance HDR image, returning its size and the colours of its pixels in rows from the top-left.
+func ReadHDR(r io.Reader) (width, height int, colors []Vec3, err error) {
+	in := bufio.NewReader(r)
+
+	// the header: lines of text (of which only the format matters), an empty line, and then the resolution
+	line, err := in.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return 0, 0, nil, errors.New("not a Radiance HDR image")
+	}
+	for {
+		if line, err = in.ReadString('\n'); err != nil {
+			return 0, 0, nil, fmt.Errorf("invalid Radiance HDR header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if format := strings.TrimPrefix(line, "FORMAT="); format != line && format != "32-bit_rle_rgbe" {
+			return 0, 0, nil, fmt.Errorf("unsupported Radiance HDR format %s", format)
+		}
+	}
+	if line, err = in.ReadString('\n'); err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid Radiance HDR resolution: %v", err)
+	}
+	if n, _ := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); n != 2 || width <= 0 || height <= 0 || width > 1<<16 || height > 1<<16 {
+		return 0, 0, nil, fmt.Errorf("unsupported Radiance HDR resolution %q (only -Y height +X width)", strings.TrimSpace(line))
+	}
+
+	// (the colours grow as the scanlines are read, rather than being allocated for the size claimed by the header)
+	scanline := make([][4]byte, width)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(in, scanline); err != nil {
+			return 0, 0, nil, fmt.Errorf("invalid Radiance HDR scanline %d: %v", y, err)
+		}
+		for _, rgbe := range scanline {
+			colors = append(colors, rgbeColor(rgbe))
+		}
+	}
+	return width, height, colors, nil
+}
+
+// LoadHDR reads the colours of the Radiance HDR image at path (as ReadHDR).
+func LoadHDR(path string) (width, height int, colors []Vec3, err error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer input.Close()
+	return ReadHDR(input)
+}
+
+// read a scanline of RGBE pixels: either flat, or run-length encoded (marked by 2, 2 and then its width),
+// in which each of the 4 components is encoded in turn, in runs (a count above 128, then a byte to repeat)
+// or literal bytes (a count of at most 128, then the bytes)
+func readHDRScanline(in *bufio.Reader, scanline [][4]byte) error {
+	var start [4]byte
+	if _, err := io.ReadFull(in, start[:]); err != nil {
+		return err
+	}
+	width := len(scanline)
+	if width < 8 || width > 0x7fff || start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+		scanline[0] = start
+		for x := 1; x < width; x++ {
+			if _, err := io.ReadFull(in, scanline[x][:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if int(start[2])<<8|int(start[3]) != width {
+		return errors.New("the width of the run-length encoding differs from the image")
+	}
+
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := in.ReadByte()
+			if err != nil {
+				return err
+			}
+			n, run := int(count), false
+			if n > 128 {
+				n, run = n-128, true
+			}
+			if n == 0 || x+n > width {
+				return errors.New("invalid run-length encoding")
+			}
+			if run {
+				b, err := in.ReadByte()
+				if err != nil {
+					return err
+				}
+				for ; n > 0; n-- {
+					scanline[x][c] = b
+					x++
+				}
+			} else {
+				for ; n > 0; n-- {
+					if scanline[x][c], err = in.ReadByte(); err != nil {
+						return err
+					}
+					x++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// the colour of an RGBE pixel: the mantissas (at the middle of their steps, as Radiance reads them) scaled by
+// 2^(exponent-128-8), or black for an exponent of 0
+func rgbeColor(rgbe [4]byte) Vec3 {
+	if rgbe[3] == 0 {
+		return ZERO_V3
+	}
+	f := entry(math.Ldexp(1, int(rgbe[3])-(128+8)))
+	return Vec3{(entry(rgbe[0]) + 0.5) * f, (entry(rgbe[1]) + 0.5) * f, (entry(rgbe[2]) + 0.5) * f}
+}
@@ -0,0 +1,58 @@
+// contains tests for hdr.go
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadHDR(t *testing.T) {
+	// flat scanlines (too narrow to be run-length encoded)
+	header := "#?RADIANCE\n# a comment\nFORMAT=32-bit_rle_rgbe\nEXPOSURE=1\n\n"
+	flat := header + "-Y 2 +X 2\n" + "\x80\x40\x00\x81" + "\x00\x00\x00\x00" + "\x80\x80\x80\x80" + "\xff\x00\x00\x7f"
+	width, height, colors, err := ReadHDR(strings.NewReader(flat))
+	if !assert(t, err == nil, "ReadHDR: error "+errString(err)) {
+		return
+	}
+	assert(t, width == 2 && height == 2, "ReadHDR: size")
+	assertVec3Equals(t, Vec3{128.5 / 128, 64.5 / 128, 0.5 / 128}, colors[0], "ReadHDR: pixel")
+	assertVec3Equals(t, ZERO_V3, colors[1], "ReadHDR: a black pixel")
+	assertVec3Equals(t, Vec3{128.5 / 256, 128.5 / 256, 128.5 / 256}, colors[2], "ReadHDR: pixel")
+	assertVec3Equals(t, Vec3{255.5 / 512, 0.5 / 512, 0.5 / 512}, colors[3], "ReadHDR: pixel")
+
+	// a run-length encoded scanline: the red of each pixel in a run, the green and blue literally (in 2 parts),
+	// and the exponent in 2 runs
+	rle := header + "-Y 1 +X 8\n" + "\x02\x02\x00\x08" +
+		"\x88\x80" +
+		"\x08\x00\x10\x20\x30\x40\x50\x60\x70" +
+		"\x03\x01\x02\x03\x05\x04\x05\x06\x07\x08" +
+		"\x84\x81\x84\x80"
+	width, height, colors, err = ReadHDR(strings.NewReader(rle))
+	if !assert(t, err == nil, "ReadHDR: RLE error "+errString(err)) {
+		return
+	}
+	assert(t, width == 8 && height == 1, "ReadHDR: RLE size")
+	for x, c := range colors {
+		f := entry(2)
+		if x >= 4 {
+			f = 1
+		}
+		exp := Vec3{128.5, entry(x*16) + 0.5, entry(x+1) + 0.5}.scale(f / 256)
+		assertVec3Equals(t, exp, c, "ReadHDR: RLE pixel")
+	}
+
+	// unsupported and invalid images
+	for name, data := range map[string]string{
+		"not an image":      "P6 3 2 255",
+		"of another format": "#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 2 +X 2\n",
+		"flipped":           header + "+Y 2 +X 2\n",
+		"truncated":         flat[:len(flat)-1],
+		"too long a run":    header + "-Y 1 +X 8\n" + "\x02\x02\x00\x08" + "\x89\x80",
+		"of another width":  header + "-Y 1 +X 8\n" + "\x02\x02\x00\x09",
+		"huge, but empty":   header + "-Y 65536 +X 65536\n",
+	} {
+		_, _, _, err := ReadHDR(strings.NewReader(data))
+		assert(t, err != nil, "ReadHDR: expected an error for an image which is "+name)
+	}
+}
@@ -0,0 +1,507 @@
+// imagediff.go: Contains the comparison of images (e.g. of a render with a reference render):
+// the error between them, and an image of where they differ.
+//
+// The metrics:
+//	- MSE: the mean squared error of the colours
+//	- PSNR: the peak signal-to-noise ratio, in decibels (from the MSE, for colours up to 1, or up to the largest of the reference)
+//	- SSIM: the structural similarity of the luminance (from 1 for the same, down to -1), over Gaussian windows
+//	- FLIP: an estimate of how different the images look when flipped between (from 0 for the same, up to 1),
+//	  after the LDR version of FLIP ("FLIP: A Difference Evaluator for Alternating Images", Andersson et al.):
+//	  the difference of the colours as seen (blurred by the contrast sensitivity of the eye), raised by the difference
+//	  of their edges and points
+// SSIM and FLIP are of the colours as displayed: clamped to [0,1], and (for FLIP) as sRGB. The linear colours of HDR images
+// are first exposed so that the largest colour of the reference is white, and encoded as sRGB.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/png" // (for decoding images)
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the colours of the pixels of an image (in [0,1]), in rows from the top-left of its bounds
+func imageColors(img image.Image) []Vec3 {
+	b := img.Bounds()
+	colors := make([]Vec3, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			colors = append(colors, Vec3{entry(r) / 0xffff, entry(g) / 0xffff, entry(b) / 0xffff})
+		}
+	}
+	return colors
+}
+
+// check that two images have the same size, returning their colours
+func comparableImages(a, b image.Image) ([]Vec3, []Vec3, error) {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return nil, nil, fmt.Errorf("the images differ in size: %dx%d and %dx%d",
+			a.Bounds().Dx(), a.Bounds().Dy(), b.Bounds().Dx(), b.Bounds().Dy())
+	}
+	return imageColors(a), imageColors(b), nil
+}
+
+// MSE returns the mean squared error between the colours of two images (of the same size),
+// averaged over the pixels and the channels.
+func MSE(a, b []Vec3) entry {
+	if len(a) == 0 {
+		return ZERO
+	}
+	var sum entry
+	for i := range a {
+		d := a[i].minus(b[i])
+		sum += d.dot(d)
+	}
+	return sum / entry(len(a)*V3LEN)
+}
+
+// PSNR returns the peak signal-to-noise ratio between the colours of a reference image a and a test image b (of the same size),
+// in decibels, for colours which range up to 1, or up to the largest component of the reference if higher (e.g. of an HDR image);
+// or infinity, if the images are the same. Higher is closer: renders which are visibly the same are usually above 40dB.
+func PSNR(a, b []Vec3) entry {
+	mse := MSE(a, b)
+	if mse == 0 {
+		return INF
+	}
+	peak := colorPeak(a)
+	return entry(10 * math.Log10(float64(peak*peak/mse)))
+}
+
+// the peak of the colours of an image: 1, or its largest component if higher
+func colorPeak(colors []Vec3) entry {
+	peak := ONE
+	for _, c := range colors {
+		peak = maxEntry(peak, c.maxComponent())
+	}
+	return peak
+}
+
+// ImagePSNR returns the PSNR of two images (of the same size).
+func ImagePSNR(a, b image.Image) (entry, error) {
+	ca, cb, err := comparableImages(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return PSNR(ca, cb), nil
+}
+
+// DiffImage returns an image of the difference between the colours of two images of width x height pixels:
+// the absolute difference of each channel, multiplied by gain (so that small differences can be seen).
+func DiffImage(width, height int, a, b []Vec3, gain entry) *image.RGBA {
+	diff := make([]Vec3, len(a))
+	for i := range a {
+		for c := range diff[i] {
+			diff[i][c] = abs(a[i][c]-b[i][c]) * gain
+		}
+	}
+	return colorsImage(width, height, diff)
+}
+
+// IsHDRImage returns whether the image at path (by its extension) has linear HDR colours: an OpenEXR or a Radiance HDR image.
+func IsHDRImage(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".exr" || ext == ".hdr"
+}
+
+// LoadImageColors reads the colours of the image at path: an OpenEXR image (.exr), a Radiance HDR image (.hdr),
+// or else a PNG. Returns its size and the colours of its pixels, in rows from the top-left.
+func LoadImageColors(path string) (width, height int, colors []Vec3, err error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".exr":
+		return LoadEXR(path)
+	case ".hdr":
+		return LoadHDR(path)
+	}
+	input, err := os.Open(path)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer input.Close()
+	img, _, err := image.Decode(input)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return img.Bounds().Dx(), img.Bounds().Dy(), imageColors(img), nil
+}
+
+// An ImageComparison holds the metrics of the differences between a test image and a reference image.
+type ImageComparison struct {
+	MSE, PSNR entry
+	SSIM      entry // the mean SSIM of the pixels
+	FLIP      entry // the mean FLIP error of the pixels
+	MaxFLIP   entry // the FLIP error of the pixel which differs most
+
+	width, height int
+	flip          []entry // the FLIP error of each pixel
+}
+
+// CompareImages compares the colours of a test image with those of a reference image, of width x height pixels,
+// as they are displayed (e.g. of PNG images).
+func CompareImages(width, height int, ref, test []Vec3) *ImageComparison {
+	return CompareHDRImages(width, height, ref, test, false, false)
+}
+
+// CompareHDRImages compares the colours of a test image with those of a reference image (as CompareImages), where
+// the colours of either may be linear HDR colours, as given by refHDR and testHDR (e.g. from IsHDRImage). The MSE and PSNR
+// are of the colours as they are, and SSIM and FLIP of the HDR colours as displayed: exposed by the peak of the reference
+// (as for PSNR), so that it is white, and encoded as sRGB.
+func CompareHDRImages(width, height int, ref, test []Vec3, refHDR, testHDR bool) *ImageComparison {
+	c := &ImageComparison{MSE: MSE(ref, test), PSNR: PSNR(ref, test), width: width, height: height}
+	peak := colorPeak(ref)
+	if refHDR {
+		ref = hdrDisplayColors(ref, peak)
+	}
+	if testHDR {
+		test = hdrDisplayColors(test, peak)
+	}
+	c.SSIM = ssim(width, height, ref, test)
+	c.flip = flipError(width, height, ref, test)
+	for _, e := range c.flip {
+		c.FLIP += e
+		c.MaxFLIP = maxEntry(c.MaxFLIP, e)
+	}
+	c.FLIP /= entry(len(c.flip))
+	return c
+}
+
+// Heatmap returns an image of the FLIP error of each pixel, in false colour: from blue for none, through green, to red for the most.
+func (c *ImageComparison) Heatmap() *image.RGBA {
+	img := NewOutputImage(c.width, c.height)
+	for i, e := range c.flip {
+		Set(img, i%c.width, i/c.width, heatColor(e))
+	}
+	return img
+}
+
+// a channel of an image (e.g. its luminance), of width x height pixels, in rows from the top-left
+type plane struct {
+	width, height int
+	values        []entry
+}
+
+// a 1D kernel (of odd length, centered on the middle value)
+type kernel []entry
+
+// convolve the plane with the (separable) kernel kx(x) * ky(y), clamping to the edges of the plane
+func (p plane) convolve(kx, ky kernel) plane {
+	tmp, res := make([]entry, len(p.values)), make([]entry, len(p.values))
+	rx, ry := len(kx)/2, len(ky)/2
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			var sum entry
+			for i, k := range kx {
+				sum += k * p.values[y*p.width+minInt(maxInt(x+i-rx, 0), p.width-1)]
+			}
+			tmp[y*p.width+x] = sum
+		}
+	}
+	for y := 0; y < p.height; y++ {
+		for x := 0; x < p.width; x++ {
+			var sum entry
+			for i, k := range ky {
+				sum += k * tmp[minInt(maxInt(y+i-ry, 0), p.height-1)*p.width+x]
+			}
+			res[y*p.width+x] = sum
+		}
+	}
+	return plane{p.width, p.height, res}
+}
+
+// a kernel of f(x) for the pixels x within the radius
+func newKernel(radius int, f func(x entry) entry) kernel {
+	k := make(kernel, 2*radius+1)
+	for i := range k {
+		k[i] = f(entry(i - radius))
+	}
+	return k
+}
+
+// a Gaussian kernel of standard deviation sigma (normalized), to 3 standard deviations
+func gaussianKernel(sigma entry) kernel {
+	k := newKernel(int(math.Ceil(float64(3*sigma))), func(x entry) entry { return gaussian(x, sigma) })
+	var sum entry
+	for _, v := range k {
+		sum += v
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// the luminance of the colours, clamped to [0,1]
+func luminancePlane(width, height int, colors []Vec3) plane {
+	p := plane{width, height, make([]entry, len(colors))}
+	for i, c := range colors {
+		p.values[i] = luminance(clampColor(c))
+	}
+	return p
+}
+
+// a colour clamped to [0,1]
+func clampColor(c Vec3) Vec3 {
+	for i := range c {
+		c[i] = maxEntry(ZERO, minEntry(c[i], ONE))
+	}
+	return c
+}
+
+// the constants of SSIM: the Gaussian window, and the stabilizing constants (for values up to 1)
+const (
+	ssimSigma = 1.5
+	ssimC1    = 0.01 * 0.01
+	ssimC2    = 0.03 * 0.03
+)
+
+// the mean SSIM of the luminance of two images
+func ssim(width, height int, a, b []Vec3) entry {
+	x, y := luminancePlane(width, height, a), luminancePlane(width, height, b)
+	xx, yy, xy := plane{width, height, make([]entry, len(a))}, plane{width, height, make([]entry, len(a))}, plane{width, height, make([]entry, len(a))}
+	for i := range x.values {
+		xx.values[i], yy.values[i], xy.values[i] = x.values[i]*x.values[i], y.values[i]*y.values[i], x.values[i]*y.values[i]
+	}
+	k := gaussianKernel(ssimSigma)
+	muX, muY, sXX, sYY, sXY := x.convolve(k, k), y.convolve(k, k), xx.convolve(k, k), yy.convolve(k, k), xy.convolve(k, k)
+
+	var sum entry
+	for i := range x.values {
+		mx, my := muX.values[i], muY.values[i]
+		varX, varY, cov := sXX.values[i]-mx*mx, sYY.values[i]-my*my, sXY.values[i]-mx*my
+		sum += (2*mx*my + ssimC1) * (2*cov + ssimC2) / ((mx*mx + my*my + ssimC1) * (varX + varY + ssimC2))
+	}
+	return sum / entry(len(x.values))
+}
+
+// the constants of FLIP:
+const (
+	flipPPD = 67    // the pixels per degree of the view (a 0.7m wide 4K monitor, seen from 0.7m)
+	flipQc  = 0.7   // the exponent of the colour difference
+	flipPc  = 0.4   // the fraction of the largest colour difference below which differences are compressed
+	flipPt  = 0.95  // (to this fraction of the range)
+	flipQf  = 0.5   // the exponent of the feature difference
+	flipW   = 0.082 // the width (in degrees) of the edges and points detected
+)
+
+// the contrast sensitivity of the eye to the channels of YCxCz, as sums of Gaussians: a * sqrt(pi/b) * exp(-pi^2 x^2 / b),
+// for x in degrees
+var flipCSF = [V3LEN][][2]entry{
+	{{1, 0.0047}},                 // achromatic
+	{{1, 0.0053}},                 // red-green
+	{{34.1, 0.04}, {13.5, 0.025}}, // blue-yellow
+}
+
+// the reference white (D65) of XYZ
+var whiteD65 = Vec3{0.950428545, 1, 1.088900371}
+
+// convert a (display) sRGB colour in [0,1] to linear RGB
+func srgbToLinear(c Vec3) Vec3 {
+	for i, v := range c {
+		if v <= 0.04045 {
+			c[i] = v / 12.92
+		} else {
+			c[i] = entry(math.Pow(float64((v+0.055)/1.055), 2.4))
+		}
+	}
+	return c
+}
+
+// convert a linear RGB colour in [0,1] to (display) sRGB
+func linearToSRGB(c Vec3) Vec3 {
+	for i, v := range c {
+		if v <= 0.0031308 {
+			c[i] = v * 12.92
+		} else {
+			c[i] = 1.055*entry(math.Pow(float64(v), 1/2.4)) - 0.055
+		}
+	}
+	return c
+}
+
+// the colours of an HDR image as displayed: exposed so that the peak is white, clamped to [0,1], and encoded as sRGB
+func hdrDisplayColors(colors []Vec3, peak entry) []Vec3 {
+	res := make([]Vec3, len(colors))
+	for i, c := range colors {
+		res[i] = linearToSRGB(clampColor(c.scale(ONE / peak)))
+	}
+	return res
+}
+
+// convert linear RGB (with sRGB primaries) to XYZ, and back
+func linearToXYZ(c Vec3) Vec3 {
+	return Vec3{
+		0.4124564*c[cX] + 0.3575761*c[cY] + 0.1804375*c[cZ],
+		0.2126729*c[cX] + 0.7151522*c[cY] + 0.0721750*c[cZ],
+		0.0193339*c[cX] + 0.1191920*c[cY] + 0.9503041*c[cZ],
+	}
+}
+
+func xyzToLinear(c Vec3) Vec3 {
+	return Vec3{
+		3.2404542*c[cX] - 1.5371385*c[cY] - 0.4985314*c[cZ],
+		-0.9692660*c[cX] + 1.8760108*c[cY] + 0.0415560*c[cZ],
+		0.0556434*c[cX] - 0.2040259*c[cY] + 1.0572252*c[cZ],
+	}
+}
+
+// convert XYZ to YCxCz (a linear, opponent colour space), and back
+func xyzToYCxCz(c Vec3) Vec3 {
+	x, y, z := c[cX]/whiteD65[cX], c[cY]/whiteD65[cY], c[cZ]/whiteD65[cZ]
+	return Vec3{116*y - 16, 500 * (x - y), 200 * (y - z)}
+}
+
+func yCxCzToXYZ(c Vec3) Vec3 {
+	y := (c[cX] + 16) / 116
+	return Vec3{(y + c[cY]/500) * whiteD65[cX], y * whiteD65[cY], (y - c[cZ]/200) * whiteD65[cZ]}
+}
+
+// convert XYZ to CIELAB
+func xyzToLab(c Vec3) Vec3 {
+	f := func(t entry) entry {
+		if t > 216.0/24389 {
+			return entry(math.Cbrt(float64(t)))
+		}
+		return t*24389/27/116 + 16.0/116
+	}
+	x, y, z := f(c[cX]/whiteD65[cX]), f(c[cY]/whiteD65[cY]), f(c[cZ]/whiteD65[cZ])
+	return Vec3{116*y - 16, 500 * (x - y), 200 * (y - z)}
+}
+
+// the HyAB distance of two CIELAB colours: the difference of their lightness, and the distance of their colour
+func hyab(a, b Vec3) entry {
+	da, db := a[cY]-b[cY], a[cZ]-b[cZ]
+	return abs(a[cX]-b[cX]) + sqrt(da*da+db*db)
+}
+
+// the colours of an image in YCxCz (from display sRGB, clamped to [0,1]), as a plane for each channel
+func yCxCzPlanes(width, height int, colors []Vec3) [V3LEN]plane {
+	var planes [V3LEN]plane
+	for c := range planes {
+		planes[c] = plane{width, height, make([]entry, len(colors))}
+	}
+	for i, color := range colors {
+		v := xyzToYCxCz(linearToXYZ(srgbToLinear(clampColor(color))))
+		for c := range planes {
+			planes[c].values[i] = v[c]
+		}
+	}
+	return planes
+}
+
+// the colours of an image as seen: blurred by the contrast sensitivity of each channel, in CIELAB
+func flipPerceived(ycxcz [V3LEN]plane) []Vec3 {
+	var filtered [V3LEN]plane
+	for c, terms := range flipCSF {
+		// (each Gaussian is separable, and they are normalized together)
+		var maxB entry
+		for _, term := range terms {
+			maxB = maxEntry(maxB, term[1])
+		}
+		radius := int(math.Ceil(float64(3 * sqrt(maxB/(2*math.Pi*math.Pi)) * flipPPD)))
+		var kernels []kernel
+		var weights []entry
+		var total entry
+		for _, term := range terms {
+			a, b := term[0], term[1]
+			k := newKernel(radius, func(x entry) entry {
+				d := x / flipPPD
+				return entry(math.Exp(float64(-math.Pi * math.Pi * d * d / b)))
+			})
+			var sum entry
+			for _, v := range k {
+				sum += v
+			}
+			w := a * sqrt(math.Pi/b)
+			kernels, weights, total = append(kernels, k), append(weights, w), total+w*sum*sum
+		}
+		filtered[c] = plane{ycxcz[c].width, ycxcz[c].height, make([]entry, len(ycxcz[c].values))}
+		for t, k := range kernels {
+			for i, v := range ycxcz[c].convolve(k, k).values {
+				filtered[c].values[i] += v * weights[t] / total
+			}
+		}
+	}
+
+	lab := make([]Vec3, len(filtered[0].values))
+	for i := range lab {
+		ycxcz := Vec3{filtered[0].values[i], filtered[1].values[i], filtered[2].values[i]}
+		lab[i] = xyzToLab(linearToXYZ(clampColor(xyzToLinear(yCxCzToXYZ(ycxcz)))))
+	}
+	return lab
+}
+
+// the magnitudes of the edges and of the points of the achromatic channel (of YCxCz, normalized to [0,1])
+func flipFeatures(y plane) (edges, points plane) {
+	norm := plane{y.width, y.height, make([]entry, len(y.values))}
+	for i, v := range y.values {
+		norm.values[i] = (v + 16) / 116
+	}
+	sigma := entry(0.5 * flipW * flipPPD)
+	radius := int(math.Ceil(float64(3 * sigma)))
+	g := newKernel(radius, func(x entry) entry { return gaussian(x, sigma) })
+	d1 := newKernel(radius, func(x entry) entry { return -x * gaussian(x, sigma) })
+	d2 := newKernel(radius, func(x entry) entry { return (x*x/(sigma*sigma) - 1) * gaussian(x, sigma) })
+
+	// (the Gaussian sums to 1, the positive weights of the first derivative sum to 1, and the positive and negative
+	// weights of the second derivative sum to 1 and -1)
+	normalize := func(k kernel, positive, negative bool) {
+		var pos, neg entry
+		for _, v := range k {
+			if v > 0 {
+				pos += v
+			} else {
+				neg -= v
+			}
+		}
+		for i, v := range k {
+			if v > 0 && positive {
+				k[i] /= pos
+			} else if v < 0 && negative {
+				k[i] /= neg
+			}
+		}
+	}
+	normalize(g, true, false)
+	normalize(d1, true, true)
+	normalize(d2, true, true)
+
+	ex, ey := norm.convolve(d1, g), norm.convolve(g, d1)
+	px, py := norm.convolve(d2, g), norm.convolve(g, d2)
+	edges, points = plane{y.width, y.height, ex.values}, plane{y.width, y.height, px.values}
+	for i := range ex.values {
+		edges.values[i] = sqrt(ex.values[i]*ex.values[i] + ey.values[i]*ey.values[i])
+		points.values[i] = sqrt(px.values[i]*px.values[i] + py.values[i]*py.values[i])
+	}
+	return edges, points
+}
+
+// the FLIP error of each pixel of the test image, from the reference
+func flipError(width, height int, ref, test []Vec3) []entry {
+	refYCxCz, testYCxCz := yCxCzPlanes(width, height, ref), yCxCzPlanes(width, height, test)
+	refLab, testLab := flipPerceived(refYCxCz), flipPerceived(testYCxCz)
+	refEdges, refPoints := flipFeatures(refYCxCz[0])
+	testEdges, testPoints := flipFeatures(testYCxCz[0])
+
+	// the colour differences are compressed, relative to the largest (between green and blue)
+	lab := func(c Vec3) Vec3 { return xyzToLab(linearToXYZ(c)) }
+	cmax := entry(math.Pow(float64(hyab(lab(Y_V3), lab(Z_V3))), flipQc))
+	pccmax := flipPc * cmax
+
+	errs := make([]entry, len(ref))
+	for i := range errs {
+		dc := entry(math.Pow(float64(hyab(refLab[i], testLab[i])), flipQc))
+		if dc < pccmax {
+			dc *= flipPt / pccmax
+		} else {
+			dc = flipPt + (dc-pccmax)/(cmax-pccmax)*(1-flipPt)
+		}
+		df := maxEntry(abs(refEdges.values[i]-testEdges.values[i]), abs(refPoints.values[i]-testPoints.values[i]))
+		df = entry(math.Pow(float64(df/math.Sqrt2), flipQf))
+		errs[i] = entry(math.Pow(float64(dc), float64(1-df)))
+	}
+	return errs
+}
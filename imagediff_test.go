@@ -0,0 +1,159 @@
+// contains tests for imagediff.go
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestMSEAndPSNR(t *testing.T) {
+	a := []Vec3{{0, 0.5, 1}, {0.25, 0.25, 0.25}}
+	b := []Vec3{{0.1, 0.5, 1}, {0.25, 0.25, 0.15}}
+	assertEquals(t, ZERO, MSE(a, a), "MSE: the same colours")
+	assert(t, !MSE(a, b).neq(0.02/6), "MSE: different colours")
+	assertEquals(t, INF, PSNR(a, a), "PSNR: the same colours")
+	assert(t, !PSNR(a, b).neq(entry(10*math.Log10(300))), "PSNR: different colours")
+
+	// the peak of HDR colours is the largest of the reference
+	hdrA, hdrB := []Vec3{{0, 0.5, 4}, {0.25, 0.25, 0.25}}, []Vec3{{0.1, 0.5, 4}, {0.25, 0.25, 0.15}}
+	assert(t, !PSNR(hdrA, hdrB).neq(entry(10*math.Log10(16*300))), "PSNR: different HDR colours")
+}
+
+func TestImagePSNR(t *testing.T) {
+	a, b := NewOutputImage(4, 2), image.NewNRGBA(image.Rect(10, 10, 14, 12))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			a.Set(x, y, color.RGBA{0, 0, 0, 255})
+			b.Set(x+10, y+10, color.NRGBA{0, 0, 0, 255})
+		}
+	}
+	psnr, err := ImagePSNR(a, b)
+	assert(t, err == nil && psnr == INF, "ImagePSNR: the same pixels (in other bounds)")
+
+	// one channel of one pixel is white: the MSE is 1/24
+	b.Set(10, 10, color.NRGBA{255, 0, 0, 255})
+	psnr, _ = ImagePSNR(a, b)
+	assert(t, !psnr.neq(entry(10*math.Log10(24))), "ImagePSNR: a different pixel")
+
+	_, err = ImagePSNR(a, NewOutputImage(4, 3))
+	assert(t, err != nil, "ImagePSNR: expected an error for images of different sizes")
+}
+
+func TestDiffImage(t *testing.T) {
+	a := []Vec3{{0, 0.5, 1}, {0.25, 0.25, 0.25}}
+	b := []Vec3{{0.1, 0.5, 0.9}, {0.25, 0.25, 0.25}}
+	img := DiffImage(2, 1, a, b, 4)
+	assertEquals(t, color.RGBA{102, 0, 102, 255}, img.RGBAAt(0, 0), "DiffImage: a different pixel")
+	assertEquals(t, color.RGBA{0, 0, 0, 255}, img.RGBAAt(1, 0), "DiffImage: the same pixel")
+}
+
+// a test image: smooth gradients, with a sphere-like bump, and noise of the given amount
+func imageDiffTestColors(width, height int, noise entry) []Vec3 {
+	colors := make([]Vec3, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := entry(x)/entry(width), entry(y)/entry(height)
+			d := (u-0.5)*(u-0.5) + (v-0.5)*(v-0.5)
+			n := noise * (entry(hashUint32(uint32(y*width+x))%1000)/1000 - 0.5)
+			colors[y*width+x] = Vec3{u, v, 0.5}.scale(maxEntry(0.3, 1-4*d) + n)
+		}
+	}
+	return colors
+}
+
+func TestCompareImages(t *testing.T) {
+	const width, height = 48, 32
+	ref := imageDiffTestColors(width, height, 0)
+	same := CompareImages(width, height, ref, ref)
+	assertEquals(t, ZERO, same.MSE, "CompareImages: MSE of the same images")
+	assertEquals(t, INF, same.PSNR, "CompareImages: PSNR of the same images")
+	assert(t, !same.SSIM.neq(ONE), fmt.Sprint("CompareImages: SSIM of the same images is ", same.SSIM))
+	assert(t, same.FLIP == 0 && same.MaxFLIP == 0, "CompareImages: FLIP of the same images")
+
+	// more noise is more different
+	prev := same
+	for _, noise := range []entry{0.05, 0.2, 0.5} {
+		c := CompareImages(width, height, ref, imageDiffTestColors(width, height, noise))
+		assert(t, c.MSE > prev.MSE && c.PSNR < prev.PSNR, fmt.Sprint("CompareImages: MSE and PSNR with noise ", noise))
+		assert(t, c.SSIM < prev.SSIM, fmt.Sprint("CompareImages: SSIM with noise ", noise, " is ", c.SSIM, ", not below ", prev.SSIM))
+		assert(t, c.FLIP > prev.FLIP, fmt.Sprint("CompareImages: FLIP with noise ", noise, " is ", c.FLIP, ", not above ", prev.FLIP))
+		assert(t, c.MaxFLIP >= c.FLIP && c.MaxFLIP <= 1, fmt.Sprint("CompareImages: max FLIP with noise ", noise))
+		prev = c
+	}
+
+	// black and white are as different as can be, and colours above 1 are displayed as 1
+	black, white, bright := make([]Vec3, width*height), make([]Vec3, width*height), make([]Vec3, width*height)
+	for i := range white {
+		white[i], bright[i] = ONE_V3, Vec3{2, 5, 1}
+	}
+	c := CompareImages(width, height, black, white)
+	assert(t, c.SSIM < 0.01 && c.FLIP > 0.95, fmt.Sprint("CompareImages: black and white have an SSIM of ", c.SSIM, " and a FLIP of ", c.FLIP))
+	c = CompareImages(width, height, white, bright)
+	assert(t, !c.SSIM.neq(ONE) && c.FLIP < 1e-6, fmt.Sprint("CompareImages: white and brighter have an SSIM of ", c.SSIM, " and a FLIP of ", c.FLIP))
+}
+
+// HDR images are compared as displayed: exposed so that the peak of the reference is white
+func TestCompareHDRImages(t *testing.T) {
+	const width, height = 48, 32
+	ref, test := imageDiffTestColors(width, height, 0), imageDiffTestColors(width, height, 0.2)
+	scaled := func(colors []Vec3, f entry) []Vec3 {
+		res := make([]Vec3, len(colors))
+		for i, c := range colors {
+			res[i] = c.scale(f)
+		}
+		return res
+	}
+
+	// clamped to [0,1], images mostly above 1 look much the same, but not when exposed
+	bright, dim := scaled(ref, 64), scaled(ref, 32)
+	ldr, hdr := CompareImages(width, height, bright, dim), CompareHDRImages(width, height, bright, dim, true, true)
+	assert(t, hdr.SSIM < 0.99 && hdr.FLIP > 2*ldr.FLIP,
+		fmt.Sprint("CompareHDRImages: an image at half the exposure has an SSIM of ", hdr.SSIM, " and a FLIP of ", hdr.FLIP,
+			" (and when clamped, ", ldr.SSIM, " and ", ldr.FLIP, ")"))
+
+	// the same images at another exposure compare the same
+	c1 := CompareHDRImages(width, height, scaled(ref, 2), scaled(test, 2), true, true)
+	c2 := CompareHDRImages(width, height, scaled(ref, 32), scaled(test, 32), true, true)
+	assert(t, c1.PSNR == c2.PSNR && c1.SSIM == c2.SSIM && c1.FLIP == c2.FLIP,
+		fmt.Sprint("CompareHDRImages: PSNR, SSIM and FLIP of ", c1.PSNR, c1.SSIM, c1.FLIP, " and ", c2.PSNR, c2.SSIM, c2.FLIP, " at another exposure"))
+	assert(t, c1.PSNR < INF && c1.SSIM < ONE && c1.FLIP > 0, "CompareHDRImages: the images differ")
+}
+
+// the heatmap is blue where the images are the same, and warmer where they differ
+func TestHeatmap(t *testing.T) {
+	const width, height = 16, 8
+	ref, test := imageDiffTestColors(width, height, 0), imageDiffTestColors(width, height, 0)
+	test[3*width+12] = Vec3{1, 0, 1}
+	img := CompareImages(width, height, ref, test).Heatmap()
+	assertEquals(t, image.Rect(0, 0, width, height), img.Bounds(), "Heatmap: size")
+	same, diff := img.RGBAAt(2, 2), img.RGBAAt(12, 3)
+	assert(t, same.B > 0 && same.R == 0, fmt.Sprint("Heatmap: the same pixel is ", same))
+	assert(t, diff.B < same.B && diff.G > same.G, fmt.Sprint("Heatmap: the different pixel is ", diff))
+}
+
+func TestLoadImageColors(t *testing.T) {
+	dir := t.TempDir()
+	colors := []Vec3{{0, 0.5, 1}, {2, 3, 4}, {0.25, 0.75, 0.125}, {1, 1, 1}, {5, 6, 7}, {0, 0, 0}}
+	pngPath, exrPath := filepath.Join(dir, "image.png"), filepath.Join(dir, "image.EXR")
+	saveImg(pngPath, colorsImage(3, 2, colors))
+	SaveEXR(exrPath, 3, 2, colors)
+
+	assert(t, IsHDRImage(exrPath) && IsHDRImage("image.hdr") && !IsHDRImage(pngPath), "IsHDRImage: by the extension")
+	width, height, act, err := LoadImageColors(exrPath)
+	assert(t, err == nil && width == 3 && height == 2, "LoadImageColors: EXR "+errString(err))
+	assertEquals(t, fmt.Sprint(colors), fmt.Sprint(act), "LoadImageColors: EXR colours")
+
+	width, height, act, err = LoadImageColors(pngPath)
+	assert(t, err == nil && width == 3 && height == 2, "LoadImageColors: PNG "+errString(err))
+	assertEquals(t, fmt.Sprint(imageColors(colorsImage(3, 2, colors))), fmt.Sprint(act), "LoadImageColors: PNG colours")
+
+	for _, path := range []string{filepath.Join(dir, "missing.png"), filepath.Join(dir, "image.hdr")} {
+		_, _, _, err = LoadImageColors(path)
+		assert(t, err != nil, "LoadImageColors: expected an error for "+path)
+	}
+}
@@ -6,17 +6,19 @@ package main
 type Material struct {
 	ambient, emission, diffuse, specular Vec3
 	shininess                            entry
+	roughness                            entry        // blurs reflections: 0 for a mirror, up to 1 for very rough surfaces
+	pbr                                  *PBRMaterial // if not nil, shading uses this (physically based) BSDF
 }
 
 // A Light is a source of light in the scene.
 type Light interface {
-	OffsetFrom(point *Vec3) *Vec3   // get un-normalized direction to light from point
+	OffsetFrom(point Vec3) Vec3     // get un-normalized direction to light from point
 	AttenuationAt(dist entry) entry // get attentuation factor at the distance
-	GetColor() *Vec3                // get color of the light
+	GetColor() Vec3                 // get color of the light
 }
 
 // A Shader determines the color of a point in the scene using the Lights and Materials.
-type Shader func(light *Light, lightDir, normal *Vec3, ray *Ray, mat *Material, dist entry) *Vec3
+type Shader func(light Light, lightDir, normal Vec3, ray Ray, mat *Material, dist entry) Vec3
 
 // Implementations of Lights: Point and Directional
 type PointLight struct {
@@ -24,11 +26,11 @@ type PointLight struct {
 	color, position, atten Vec3
 }
 
-func (p *PointLight) GetColor() *Vec3 {
-	return &(p.color)
+func (p *PointLight) GetColor() Vec3 {
+	return p.color
 }
 
-func (p *PointLight) OffsetFrom(point *Vec3) *Vec3 {
+func (p *PointLight) OffsetFrom(point Vec3) Vec3 {
 	return p.position.minus(point)
 }
 
@@ -42,12 +44,12 @@ type DirectionalLight struct {
 	color, direction Vec3
 }
 
-func (d *DirectionalLight) GetColor() *Vec3 {
-	return &(d.color)
+func (d *DirectionalLight) GetColor() Vec3 {
+	return d.color
 }
 
-func (d *DirectionalLight) OffsetFrom(point *Vec3) *Vec3 {
-	return &(d.direction) // direction is const for these lights
+func (d *DirectionalLight) OffsetFrom(point Vec3) Vec3 {
+	return d.direction // direction is const for these lights
 }
 
 func (d *DirectionalLight) AttenuationAt(dist entry) entry {
@@ -55,21 +57,18 @@ func (d *DirectionalLight) AttenuationAt(dist entry) entry {
 }
 
 // An implementation of a Shader: Blinn-Phong Lighting model
-func BlinnPhongShader(lightPtr *Light, lightDir, normal *Vec3, ray *Ray, mat *Material, dist entry) *Vec3 {
-	// resolve light pointer:
-	light := *lightPtr
-
+func BlinnPhongShader(light Light, lightDir, normal Vec3, ray Ray, mat *Material, dist entry) Vec3 {
 	// compute the halfway vector between eye direction and light direction:
-	halfVec := lightDir.minus(&(ray.direction)).direction()
+	halfVec := lightDir.minus(ray.direction).direction()
 
 	// diffuse factor is normal dot light direction (if > 0)
-	diffuseColor := &ZERO_V3
+	diffuseColor := ZERO_V3
 	if diffuse := lightDir.dot(normal); diffuse > 0 {
 		diffuseColor = mat.diffuse.scale(diffuse)
 	}
 
 	// specular factor is (normal dot halfvec)^shininess (if > 0)
-	specularColor := &ZERO_V3
+	specularColor := ZERO_V3
 	if specular := normal.dot(halfVec); specular > 0 {
 		specularColor = mat.specular.scale(specular.pow(mat.shininess))
 	}
@@ -1,14 +1,234 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"image"
 	"image/png"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 func main() {
-	sampleScene1()
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := diffImages(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	scenePath := flag.String("scene", "", "render the scene `file` (json), rather than the sample scene")
+	outPath := flag.String("out", "out.png", "the `file` to save the image to")
+	coordinatorAddr := flag.String("coordinator", "", "serve the tiles of the scene to workers at the `address` (e.g. :8080)")
+	tileSize := flag.Int("tile", 32, "the size of the tiles given to workers, in pixels")
+	lease := flag.Duration("lease", time.Minute, "the time a worker has to render a tile, before it is given to another")
+	workerURL := flag.String("worker", "", "render tiles for the coordinator at the `url` (e.g. http://host:8080)")
+	serveAddr := flag.String("serve", "", "serve the render API at the `address` (e.g. localhost:8080)")
+	jobs := flag.Int("jobs", 1, "the number of jobs the render API renders at a time")
+	queue := flag.Int("queue", 16, "the number of jobs the render API queues")
+	previewAddr := flag.String("preview", "", "render the scene progressively, with a live preview at the `address` (e.g. localhost:8080)")
+	spp := flag.Int("spp", 64, "the samples per pixel of a progressive render")
+	aovList := flag.String("aov", "", "render the `passes` (a comma-separated list, e.g. depth,normal, or all) of a scene file alongside the image")
+	denoise := flag.Float64("denoise", 0, "denoise the render of a scene file, with the `strength` (e.g. 1)")
+	watch := flag.Bool("watch", false, "render the scene progressively, and again whenever the scene file (or its meshes) change")
+	checkpointPath := flag.String("checkpoint", "", "render the scene progressively, saving its progress to the `file` (at intervals, and when interrupted)")
+	checkpointInterval := flag.Duration("checkpoint-interval", time.Minute, "the time between saves of the progress of a checkpointed render")
+	resume := flag.Bool("resume", false, "continue a checkpointed render from its checkpoint file, if it exists")
+	flag.Parse()
+
+	if *resume && *checkpointPath == "" {
+		log.Fatal("-resume needs a -checkpoint file")
+	}
+	progressive := &ProgressiveOptions{targetSamples: *spp, checkpointPath: *checkpointPath, checkpointInterval: *checkpointInterval}
+
+	switch {
+	case *scenePath != "" && *watch:
+		if err := watchSceneFile(*scenePath, *outPath, *spp); err != nil && err != context.Canceled {
+			log.Fatal(err)
+		}
+	case *scenePath != "" && (*previewAddr != "" || *checkpointPath != ""):
+		if err := previewSceneFile(*scenePath, *outPath, *previewAddr, progressive, *resume); err != nil && err != context.Canceled {
+			log.Fatal(err)
+		}
+	case *serveAddr != "":
+		service := NewRenderService(*jobs, *queue)
+		defer service.Close()
+		log.Fatal(http.ListenAndServe(*serveAddr, service))
+	case *workerURL != "":
+		host, _ := os.Hostname()
+		if err := RunWorker(context.Background(), *workerURL, fmt.Sprintf("%s-%d", host, os.Getpid())); err != nil {
+			log.Fatal(err)
+		}
+	case *scenePath != "" && (*aovList != "" || *denoise > 0):
+		if err := renderScenePasses(*scenePath, *outPath, *aovList, entry(*denoise)); err != nil {
+			log.Fatal(err)
+		}
+	case *scenePath != "":
+		if err := renderSceneFile(*scenePath, *outPath, *coordinatorAddr, *tileSize, *lease); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		sampleScene1()
+	}
+}
+
+// render a scene file (or coordinate its rendering by workers, if addr is not empty), saving the image to out
+// (as an OpenEXR file, if its extension is .exr, or else a PNG)
+func renderSceneFile(path, out, addr string, tileSize int, lease time.Duration) error {
+	sceneFile, err := LoadSceneFile(path)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	var colors []Vec3
+	if addr == "" {
+		rayTracer, scene, lights, err := sceneFile.Build()
+		if err != nil {
+			return err
+		}
+		if colors, err = rayTracer.DrawTile(context.Background(), scene, lights, 0, 0, rayTracer.width, rayTracer.height); err != nil {
+			return err
+		}
+	} else {
+		coordinator, err := NewCoordinator(sceneFile, tileSize, lease)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Addr: addr, Handler: coordinator}
+		go func() {
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		coordinator.Wait(context.Background())
+		// (give the workers time to be told the image is done)
+		time.Sleep(2 * workerPollInterval)
+		server.Shutdown(context.Background())
+		colors = coordinator.Pixels()
+	}
+	println("Done in", time.Since(start).String())
+
+	width, height := sceneFile.Camera.Width, sceneFile.Camera.Height
+	if strings.EqualFold(filepath.Ext(out), ".exr") {
+		return SaveEXR(out, width, height, colors)
+	}
+	return saveImg(out, colorsImage(width, height, colors))
+}
+
+// render a scene file along with the passes of the list (comma-separated names, "all", or empty for none), saving them to out:
+// as layers of an OpenEXR file, if its extension is .exr, or else as a PNG of the image, and a PNG of each pass
+// (named by the pass, e.g. scene-depth.png for scene.png). The image is denoised if the strength is positive.
+func renderScenePasses(path, out, list string, denoise entry) error {
+	var aovs []AOV
+	switch list {
+	case "":
+	case "all":
+		aovs = AllAOVs()
+	default:
+		for _, name := range strings.Split(list, ",") {
+			aov, err := ParseAOV(strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+			aovs = append(aovs, aov)
+		}
+	}
+	sceneFile, err := LoadSceneFile(path)
+	if err != nil {
+		return err
+	}
+	rayTracer, scene, lights, err := sceneFile.Build()
+	if err != nil {
+		return err
+	}
+
+	// (denoising needs the albedo and normal passes, which are only saved as PNGs if they were asked for)
+	rendered := aovs
+	if denoise > 0 {
+		rendered = append([]AOV{AOVAlbedo, AOVNormal}, aovs...)
+	}
+	start := time.Now()
+	passes, _, err := rayTracer.DrawPasses(context.Background(), scene, lights, rendered...)
+	if err != nil {
+		return err
+	}
+	if denoise > 0 {
+		if passes, err = passes.Denoised(denoise); err != nil {
+			return err
+		}
+	}
+	println("Done in", time.Since(start).String())
+
+	ext := filepath.Ext(out)
+	if strings.EqualFold(ext, ".exr") {
+		return passes.SaveEXR(out)
+	}
+	if err := saveImg(out, passes.Image()); err != nil {
+		return err
+	}
+	for _, aov := range aovs {
+		if err := saveImg(strings.TrimSuffix(out, ext)+"-"+aov.String()+ext, passes.PassImage(aov)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the diff command: compare a test image with a reference image (each a PNG, an OpenEXR or a Radiance HDR image),
+// printing the metrics of their differences, and optionally saving a heatmap of them.
+// Fails if the images differ by more than the thresholds given.
+func diffImages(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	heatmapPath := flags.String("heatmap", "", "save a heatmap of the (FLIP) error of each pixel to the `file` (png)")
+	minPSNR := flags.Float64("min-psnr", 0, "fail if the PSNR is below the `threshold` (in dB)")
+	maxFLIP := flags.Float64("max-flip", 1, "fail if the mean FLIP error is above the `threshold`")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: raytracer diff [flags] reference test")
+		flags.PrintDefaults()
+	}
+	// (the flags may come before or after the images)
+	var paths []string
+	for flags.Parse(args); flags.NArg() > 0; flags.Parse(args) {
+		paths, args = append(paths, flags.Arg(0)), flags.Args()[1:]
+	}
+	if len(paths) != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	refWidth, refHeight, ref, err := LoadImageColors(paths[0])
+	if err != nil {
+		return err
+	}
+	width, height, test, err := LoadImageColors(paths[1])
+	if err != nil {
+		return err
+	}
+	if width != refWidth || height != refHeight {
+		return fmt.Errorf("the images differ in size: %dx%d and %dx%d", refWidth, refHeight, width, height)
+	}
+
+	c := CompareHDRImages(width, height, ref, test, IsHDRImage(paths[0]), IsHDRImage(paths[1]))
+	fmt.Printf("MSE:  %.6g\nPSNR: %.2fdB\nSSIM: %.4f\nFLIP: %.4f (max %.4f)\n", c.MSE, c.PSNR, c.SSIM, c.FLIP, c.MaxFLIP)
+	if *heatmapPath != "" {
+		if err := saveImg(*heatmapPath, c.Heatmap()); err != nil {
+			return err
+		}
+	}
+	if float64(c.PSNR) < *minPSNR {
+		return fmt.Errorf("the PSNR (%.2fdB) is below %gdB", c.PSNR, *minPSNR)
+	}
+	if float64(c.FLIP) > *maxFLIP {
+		return fmt.Errorf("the FLIP error (%.4f) is above %g", c.FLIP, *maxFLIP)
+	}
+	return nil
 }
 
 func sampleScene1() {
@@ -20,41 +240,119 @@ func sampleScene1() {
 	view := &Camera{eyePos, lookAt, up, width, height, fovY}
 
 	// init ray tracer
-	//rayTracer := NewRayTracer(view, &RayTracerOptions{5, 2, 8})
-	rayTracer := NewRayTracer(view, &RayTracerOptions{2, 1, 1})
+	//rayTracer := NewRayTracer(view, &RayTracerOptions{5, 2, 8, 4, 0.01, NewSobolSampler(0), NewMitchellFilter(2, 1.0/3, 1.0/3), 16, 0.01})
+	rayTracer := NewRayTracer(view, &RayTracerOptions{2, 1, 1, 4, 0.01, NewSobolSampler(0), NewMitchellFilter(2, 1.0/3, 1.0/3), 16, 0.01})
 
 	// create materials, scene and lights:
-	mat1 := &Material{Vec3{0.3, 0.3, 0.3}, ZERO_V3, Vec3{0.2, 0.4, 0.2}, Vec3{0.2, 0.35, 0.2}, entry(15)}
-	mat2 := &Material{Vec3{0.4, 0.2, 0.2}, ZERO_V3, Vec3{0.4, 0.2, 0.2}, Vec3{0.4, 0.2, 0.2}, entry(5)}
+	mat1 := &Material{Vec3{0.3, 0.3, 0.3}, ZERO_V3, Vec3{0.2, 0.4, 0.2}, Vec3{0.2, 0.35, 0.2}, entry(15), ZERO, nil}
+	mat2 := &Material{Vec3{0.4, 0.2, 0.2}, ZERO_V3, Vec3{0.4, 0.2, 0.2}, Vec3{0.4, 0.2, 0.2}, entry(5), entry(0.3), nil}
 	atten := X_V3
 	lights := []Light{
 		&PointLight{Vec3{0.2, 0.4, 0.2}, Vec3{0, 5, 3}, atten},
 		&PointLight{Vec3{0.4, 0.3, 0.3}, Vec3{-6, 1, 3}, atten},
 	}
 
-	// scene1 := []Shape{NewSphere(TWO, &ZERO_V3, mat), NewSphere(ONE, X_V3.scale(FOUR), mat)}
+	// scene1 := []Shape{NewSphere(TWO, ZERO_V3, mat), NewSphere(ONE, X_V3.scale(FOUR), mat)}
 	s2size := 5
 	numQuads := 1
 	scene2 := make([]Shape, s2size*s2size + numQuads)
 	for i := 0; i < s2size; i++ {
 		for j := 0; j < s2size; j++ {
 			si, sj, ci, cj := entry(1.5), entry(-2), entry(i-2), entry(j)
-			scene2[i*s2size+j] = NewSphere(entry(0.5), &Vec3{si * ci, -TWO, sj * cj}, mat1)
+			scene2[i*s2size+j] = NewSphere(entry(0.5), Vec3{si * ci, -TWO, sj * cj}, mat1)
 		}
 	}
-	ptA := &Vec3{entry(-3), -FOUR, entry(0)}
-	ptB := &Vec3{entry(4),  -FOUR, entry(0)}
-	ptC := &Vec3{entry(4),  -FOUR, entry(-4)}
-	ptD := &Vec3{entry(-3), -FOUR, entry(-4)}
+	ptA := Vec3{entry(-3), -FOUR, entry(0)}
+	ptB := Vec3{entry(4),  -FOUR, entry(0)}
+	ptC := Vec3{entry(4),  -FOUR, entry(-4)}
+	ptD := Vec3{entry(-3), -FOUR, entry(-4)}
 	scene2[s2size*s2size] = NewQuad(ptA, ptB, ptC, ptD, mat2)
 
 	//	saveImg("scene1.png", rayTracer.Draw(scene1, lights))
 	start := time.Now()
-	saveImg("scene2.png", rayTracer.Draw(scene2, lights))
+	img, stats, _ := rayTracer.DrawContext(context.Background(), scene2, lights)
+	saveImg("scene2.png", img)
 	end := time.Now()
+	saveImg("scene2-samples.png", SampleHeatmap(stats.SampleCounts, width, height))
 	println("Done in", end.Sub(start).String())
 }
 
+// render a scene file progressively (with the options), with a live preview served at addr (if not empty),
+// saving the image to out (and then serving the preview until interrupted). With a checkpoint file in the options,
+// the render is resumed from it (if resume is set, and it exists), and interrupting the render saves its progress.
+func previewSceneFile(path, out, addr string, options *ProgressiveOptions, resume bool) error {
+	sceneFile, err := LoadSceneFile(path)
+	if err != nil {
+		return err
+	}
+	rayTracer, scene, lights, err := sceneFile.Build()
+	if err != nil {
+		return err
+	}
+
+	var preview *PreviewServer
+	if addr != "" {
+		preview = NewPreviewServer(options)
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, preview))
+		}()
+		println("Preview at http://" + addr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var img *image.RGBA
+	if _, statErr := os.Stat(options.checkpointPath); resume && statErr == nil {
+		println("Resuming from", options.checkpointPath)
+		img, err = rayTracer.ResumeProgressive(ctx, scene, lights, options)
+	} else {
+		img, err = rayTracer.DrawProgressive(ctx, scene, lights, options)
+	}
+	stop()
+	if preview != nil {
+		preview.Done(err)
+	}
+	if err == context.Canceled && options.checkpointPath != "" {
+		println("Interrupted: the progress is saved to", options.checkpointPath)
+	}
+	if err != nil {
+		return err
+	}
+	if err := saveImg(out, img); err != nil {
+		return err
+	}
+	if preview == nil {
+		return nil
+	}
+
+	// (keep the preview, to inspect the pixels of the final image)
+	println("Done: press Ctrl-C to stop the preview")
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	return nil
+}
+
+// render a scene file progressively (to spp samples per pixel), saving the image of each pass to out,
+// and render it again whenever it changes (until interrupted)
+func watchSceneFile(path, out string, spp int) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	println("Watching", path, "(press Ctrl-C to stop)")
+	return WatchSceneFile(ctx, path, 200*time.Millisecond, func(ctx context.Context, rt *RayTracer, scene []Shape, lights []Light) error {
+		save := SnapshotWriter(out)
+		options := &ProgressiveOptions{targetSamples: spp, onPass: func(pass ProgressivePass) error {
+			println("Pass", pass.Pass, "of", path, "done in", pass.Elapsed.String(), "with", pass.SamplesPerPixel, "samples per pixel")
+			return save(pass)
+		}}
+		_, err := rt.DrawProgressive(ctx, scene, lights, options)
+		return err
+	}, func(err error) {
+		log.Println(err)
+	})
+}
+
 func saveImg(path string, img *image.RGBA) error {
 	output, err := os.Create(path)
 	if err != nil {
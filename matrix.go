@@ -4,6 +4,8 @@ import "math"
 
 // Matrix.go: contains common operations for matrices and vectors.
 // Only required types for the raytracer are 3D and 4D vectors and matrices.
+// Operations take and return values (not pointers), so that they do not allocate,
+// and the in-place variants (at the end of the file) modify their receiver.
 
 // define the length of each data type
 const (
@@ -13,8 +15,9 @@ const (
 	V4LEN = 4
 )
 
+// each element of a matrix/vector is an entry, whose precision is
+// selected at build time (see entry_float64.go and entry_float32.go)
 type (
-	entry float64      // each element of a matrix/vector
 	Vec3  [V3LEN]entry // 3D vector
 	Vec4  [V4LEN]entry // 4D vector
 	Mat3  [M3LEN]entry // 3x3 matrix
@@ -37,6 +40,7 @@ var (
 
 	X_V3, Y_V3, Z_V3 Vec3 = Vec3{1, 0, 0}, Vec3{0, 1, 0}, Vec3{0, 0, 1}
 	ZERO_V3          Vec3 = Vec3{0, 0, 0}
+	ONE_V3           Vec3 = Vec3{1, 1, 1}
 
 	X_V4, Y_V4, Z_V4, W_V4 Vec4 = Vec4{1, 0, 0, 0}, Vec4{0, 1, 0, 0}, Vec4{0, 0, 1, 0}, Vec4{0, 0, 0, 1}
 	ZERO_V4                Vec4 = Vec4{0, 0, 0, 0}
@@ -50,28 +54,28 @@ func add(m1, m2, m3 []entry, n int) {
 }
 
 // dot product: 3-vectors
-func (m *Vec3) dot(n *Vec3) entry {
+func (m Vec3) dot(n Vec3) entry {
 	return m[cX]*n[cX] + m[cY]*n[cY] + m[cZ]*n[cZ]
 }
 
 // dot product: 4-vectors
-func (m *Vec4) dot(n *Vec4) entry {
+func (m Vec4) dot(n Vec4) entry {
 	return m[cX]*n[cX] + m[cY]*n[cY] + m[cZ]*n[cZ] + m[cW]*n[cW]
 }
 
 // elementwise product: 3-vectors
-func (m *Vec3) times(n *Vec3) *Vec3 {
-	return &Vec3{m[cX] * n[cX], m[cY] * n[cY], m[cZ] * n[cZ]}
+func (m Vec3) times(n Vec3) Vec3 {
+	return Vec3{m[cX] * n[cX], m[cY] * n[cY], m[cZ] * n[cZ]}
 }
 
 // elementwise product: 4-vectors
-func (m *Vec4) times(n *Vec4) *Vec4 {
-	return &Vec4{m[cX] * n[cX], m[cY] * n[cY], m[cZ] * n[cZ], m[cW] * n[cW]}
+func (m Vec4) times(n Vec4) Vec4 {
+	return Vec4{m[cX] * n[cX], m[cY] * n[cY], m[cZ] * n[cZ], m[cW] * n[cW]}
 }
 
 // cross product: (only defined for) 3-vectors
-func (m *Vec3) cross(n *Vec3) *Vec3 {
-	return &Vec3{
+func (m Vec3) cross(n Vec3) Vec3 {
+	return Vec3{
 		m[cY]*n[cZ] - n[cY]*m[cZ],
 		m[cZ]*n[cX] - n[cZ]*m[cX],
 		m[cX]*n[cY] - n[cX]*m[cY],
@@ -120,6 +124,10 @@ func radians(degrees entry) float64 {
 	return float64(degrees) * (math.Pi / 180.0)
 }
 
+func degrees(radians float64) entry {
+	return entry(radians * (180.0 / math.Pi))
+}
+
 // wrap around math.Tan
 func tan(degrees entry) entry {
 	return entry(math.Tan(radians(degrees)))
@@ -136,34 +144,39 @@ func sin(degrees entry) entry {
 }
 
 // distanceTo: 3-vectors
-func (v *Vec3) distanceTo(u *Vec3) entry {
+func (v Vec3) distanceTo(u Vec3) entry {
 	dx, dy, dz := v[cX]-u[cX], v[cY]-u[cY], v[cZ]-u[cZ]
 	return sqrt(dx*dx + dy*dy + dz*dz)
 }
 
 // distanceTo: 4-vectors
-func (v *Vec4) distanceTo(u *Vec4) entry {
+func (v Vec4) distanceTo(u Vec4) entry {
 	dx, dy, dz, dw := v[cX]-u[cX], v[cY]-u[cY], v[cZ]-u[cZ], v[cW]-u[cW]
 	return sqrt(dx*dx + dy*dy + dz*dz + dw*dw)
 }
 
 // length: 3-vectors
-func (v *Vec3) magnitude() entry {
+func (v Vec3) magnitude() entry {
 	return sqrt(v.dot(v))
 }
 
+// largest component: 3-vectors
+func (v Vec3) maxComponent() entry {
+	return maxEntry(v[cX], maxEntry(v[cY], v[cZ]))
+}
+
 // length: 4-vectors
-func (v *Vec4) magnitude() entry {
+func (v Vec4) magnitude() entry {
 	return sqrt(v.dot(v))
 }
 
 // normalized direction: 3-vectors
-func (v *Vec3) direction() *Vec3 {
+func (v Vec3) direction() Vec3 {
 	return v.scale(1 / v.magnitude())
 }
 
 // normalized direction: 4-vectors
-func (v *Vec4) direction() *Vec4 {
+func (v Vec4) direction() Vec4 {
 	return v.scale(1 / v.magnitude())
 }
 
@@ -184,7 +197,7 @@ func det2(m []entry, n, r1, r2, c1, c2 int) entry {
 }
 
 // determinant: 3x3 matrix
-func (m *Mat3) determinant() entry {
+func (m Mat3) determinant() entry {
 	ms, n := m[:], V3LEN
 
 	// approach: expand coefficients across the first row
@@ -195,7 +208,7 @@ func (m *Mat3) determinant() entry {
 }
 
 // determinant: 4x4 matrix
-func (m *Mat4) determinant() entry {
+func (m Mat4) determinant() entry {
 
 	// precompute required 2-determinants:
 	// each such 2-det is a 2x2 matrix using only 
@@ -222,10 +235,10 @@ func (m *Mat4) determinant() entry {
 
 // inverse: 3x3 matrices
 // only defined if matrix is invertible (i.e. det != 0)
-func (m *Mat3) inverse() *Mat3 {
+func (m Mat3) inverse() Mat3 {
 	sc := entry(1) / m.determinant()
 	r0, r1, r2 := 0, V3LEN, 2*V3LEN
-	return &Mat3{ // formula adapted from the GLM library
+	return Mat3{ // formula adapted from the GLM library
 		+sc * (m[r1+1]*m[r2+2] - m[r2+1]*m[r1+2]),
 		-sc * (m[r0+1]*m[r2+2] - m[r2+1]*m[r0+2]),
 		+sc * (m[r0+1]*m[r1+2] - m[r1+1]*m[r0+2]),
@@ -243,7 +256,7 @@ func (m *Mat3) inverse() *Mat3 {
 // inverse: 4x4 matrices
 // only defined if matrix is invertible (i.e. det != 0)
 // formula adapted from the GLM library
-func (m *Mat4) inverse() *Mat4 {
+func (m Mat4) inverse() Mat4 {
 	sc := entry(1) / m.determinant()
 	r0, r1, r2, r3 := 0, V4LEN, 2*V4LEN, 3*V4LEN
 
@@ -272,7 +285,7 @@ func (m *Mat4) inverse() *Mat4 {
 	c22 := m[r1+0]*m[r3+1] - m[r3+0]*m[r1+1]
 	c23 := m[r1+0]*m[r2+1] - m[r2+0]*m[r1+1]
 
-	return &Mat4{
+	return Mat4{
 		+sc * (m[r1+1]*c00 - m[r1+2]*c04 + m[r1+3]*c08),
 		-sc * (m[r0+1]*c00 - m[r0+2]*c04 + m[r0+3]*c08),
 		+sc * (m[r0+1]*c02 - m[r0+2]*c06 + m[r0+3]*c10),
@@ -298,123 +311,172 @@ func (m *Mat4) inverse() *Mat4 {
 // Wrappers for each of the types:
 
 // addition: 3x3 matrices
-func (m *Mat3) plus(n *Mat3) *Mat3 {
-	res := new(Mat3)
+func (m Mat3) plus(n Mat3) Mat3 {
+	var res Mat3
 	add(m[:], n[:], res[:], M3LEN)
 	return res
 }
 
 // addition: 4x4 matrices
-func (m *Mat4) plus(n *Mat4) *Mat4 {
-	res := new(Mat4)
+func (m Mat4) plus(n Mat4) Mat4 {
+	var res Mat4
 	add(m[:], n[:], res[:], M4LEN)
 	return res
 }
 
 // addition: 3-vectors
-func (m *Vec3) plus(n *Vec3) *Vec3 {
-	res := new(Vec3)
-	add(m[:], n[:], res[:], V3LEN)
-	return res
+func (m Vec3) plus(n Vec3) Vec3 {
+	return Vec3{m[cX] + n[cX], m[cY] + n[cY], m[cZ] + n[cZ]}
 }
 
 // addition: 4-vectors
-func (m *Vec4) plus(n *Vec4) *Vec4 {
-	res := new(Vec4)
-	add(m[:], n[:], res[:], V4LEN)
-	return res
+func (m Vec4) plus(n Vec4) Vec4 {
+	return Vec4{m[cX] + n[cX], m[cY] + n[cY], m[cZ] + n[cZ], m[cW] + n[cW]}
 }
 
 // subtraction: 3-vectors
-func (m *Vec3) minus(n *Vec3) *Vec3 {
-	return m.plus(n.scale(-ONE))
+func (m Vec3) minus(n Vec3) Vec3 {
+	return Vec3{m[cX] - n[cX], m[cY] - n[cY], m[cZ] - n[cZ]}
 }
 
 // subtraction: 4-vectors
-func (m *Vec4) minus(n *Vec4) *Vec4 {
-	return m.plus(n.scale(-ONE))
+func (m Vec4) minus(n Vec4) Vec4 {
+	return Vec4{m[cX] - n[cX], m[cY] - n[cY], m[cZ] - n[cZ], m[cW] - n[cW]}
 }
 
 // scalar product for 3-vectors
-func (m *Vec3) scale(s entry) *Vec3 {
-	res := new(Vec3)
-	multScalar(m[:], res[:], V3LEN, s)
-	return res
+func (m Vec3) scale(s entry) Vec3 {
+	return Vec3{m[cX] * s, m[cY] * s, m[cZ] * s}
 }
 
 // scalar product for 4-vectors
-func (m *Vec4) scale(s entry) *Vec4 {
-	res := new(Vec4)
-	multScalar(m[:], res[:], V4LEN, s)
-	return res
+func (m Vec4) scale(s entry) Vec4 {
+	return Vec4{m[cX] * s, m[cY] * s, m[cZ] * s, m[cW] * s}
 }
 
 // scalar product for 3x3 matrices
-func (m *Mat3) scale(s entry) *Mat3 {
-	res := new(Mat3)
+func (m Mat3) scale(s entry) Mat3 {
+	var res Mat3
 	multScalar(m[:], res[:], M3LEN, s)
 	return res
 }
 
 // scalar product for 4x4 matrices
-func (m *Mat4) scale(s entry) *Mat4 {
-	res := new(Mat4)
+func (m Mat4) scale(s entry) Mat4 {
+	var res Mat4
 	multScalar(m[:], res[:], M4LEN, s)
 	return res
 }
 
 // multiplication: 3x3 matrices
-func (m *Mat3) times(n *Mat3) *Mat3 {
-	res := new(Mat3)
+func (m Mat3) times(n Mat3) Mat3 {
+	var res Mat3
 	mult(m[:], n[:], res[:], V3LEN, V3LEN, V3LEN)
 	return res
 }
 
 // multiplication: 4x4 matrices
-func (m *Mat4) times(n *Mat4) *Mat4 {
-	res := new(Mat4)
+func (m Mat4) times(n Mat4) Mat4 {
+	var res Mat4
 	mult(m[:], n[:], res[:], V4LEN, V4LEN, V4LEN)
 	return res
 }
 
 // multiplication: 3-vec * 3x3 mat
-func (v *Vec3) timesMat(m *Mat3) *Vec3 {
-	res := new(Vec3)
+func (v Vec3) timesMat(m Mat3) Vec3 {
+	var res Vec3
 	mult(v[:], m[:], res[:], 1, V3LEN, V3LEN)
 	return res
 }
 
 // multiplication: 3x3 mat * 3-vec
-func (m *Mat3) timesVec(v *Vec3) *Vec3 {
-	res := new(Vec3)
+func (m Mat3) timesVec(v Vec3) Vec3 {
+	var res Vec3
 	mult(m[:], v[:], res[:], V3LEN, V3LEN, 1)
 	return res
 }
 
 // multiplication: 4-vec * 4x4 mat
-func (v *Vec4) timesMat(m *Mat4) *Vec4 {
-	res := new(Vec4)
+func (v Vec4) timesMat(m Mat4) Vec4 {
+	var res Vec4
 	mult(v[:], m[:], res[:], 1, V4LEN, V4LEN)
 	return res
 }
 
 // multiplication: 4x4 mat * 4-vec
-func (m *Mat4) timesVec(v *Vec4) *Vec4 {
-	res := new(Vec4)
+func (m Mat4) timesVec(v Vec4) Vec4 {
+	var res Vec4
 	mult(m[:], v[:], res[:], V4LEN, V4LEN, 1)
 	return res
 }
 
 // transpose: 3-vectors
-func (m *Mat3) transpose() *Mat3 {
-	res := new(Mat3)
+func (m Mat3) transpose() Mat3 {
+	var res Mat3
 	transpose(m[:], res[:], V3LEN)
 	return res
 }
 
 // transpose: 4-vectors
-func (m *Mat4) transpose() *Mat4 {
-	res := new(Mat4)
+func (m Mat4) transpose() Mat4 {
+	var res Mat4
 	transpose(m[:], res[:], V4LEN)
 	return res
 }
+
+// In-place variants (which modify the receiver) for accumulating into vectors:
+
+// in-place addition: 3-vectors
+func (m *Vec3) addInPlace(n Vec3) {
+	m[cX], m[cY], m[cZ] = m[cX]+n[cX], m[cY]+n[cY], m[cZ]+n[cZ]
+}
+
+// in-place addition: 4-vectors
+func (m *Vec4) addInPlace(n Vec4) {
+	m[cX], m[cY], m[cZ], m[cW] = m[cX]+n[cX], m[cY]+n[cY], m[cZ]+n[cZ], m[cW]+n[cW]
+}
+
+// in-place subtraction: 3-vectors
+func (m *Vec3) subInPlace(n Vec3) {
+	m[cX], m[cY], m[cZ] = m[cX]-n[cX], m[cY]-n[cY], m[cZ]-n[cZ]
+}
+
+// in-place subtraction: 4-vectors
+func (m *Vec4) subInPlace(n Vec4) {
+	m[cX], m[cY], m[cZ], m[cW] = m[cX]-n[cX], m[cY]-n[cY], m[cZ]-n[cZ], m[cW]-n[cW]
+}
+
+// in-place scalar product: 3-vectors
+func (m *Vec3) scaleInPlace(s entry) {
+	m[cX], m[cY], m[cZ] = m[cX]*s, m[cY]*s, m[cZ]*s
+}
+
+// in-place scalar product: 4-vectors
+func (m *Vec4) scaleInPlace(s entry) {
+	m[cX], m[cY], m[cZ], m[cW] = m[cX]*s, m[cY]*s, m[cZ]*s, m[cW]*s
+}
+
+// in-place elementwise product: 3-vectors
+func (m *Vec3) timesInPlace(n Vec3) {
+	m[cX], m[cY], m[cZ] = m[cX]*n[cX], m[cY]*n[cY], m[cZ]*n[cZ]
+}
+
+// in-place elementwise product: 4-vectors
+func (m *Vec4) timesInPlace(n Vec4) {
+	m[cX], m[cY], m[cZ], m[cW] = m[cX]*n[cX], m[cY]*n[cY], m[cZ]*n[cZ], m[cW]*n[cW]
+}
+
+// in-place addition of a scaled vector (m += n*s): 3-vectors
+func (m *Vec3) addScaledInPlace(n Vec3, s entry) {
+	m[cX], m[cY], m[cZ] = m[cX]+n[cX]*s, m[cY]+n[cY]*s, m[cZ]+n[cZ]*s
+}
+
+// in-place normalization: 3-vectors
+func (m *Vec3) normalize() {
+	m.scaleInPlace(1 / m.magnitude())
+}
+
+// in-place normalization: 4-vectors
+func (m *Vec4) normalize() {
+	m.scaleInPlace(1 / m.magnitude())
+}
@@ -0,0 +1,154 @@
+// mesh.go: Contains the triangle Mesh shape.
+
+package main
+
+// A Mesh is a set of triangles which share indexed vertex buffers.
+// The triangles are not Shapes themselves: the mesh intersects them
+// through its own BVH, and reports the triangle hit as Intersection.index.
+type Mesh struct {
+	vertices []Vec3   // vertex positions
+	normals  []Vec3   // per-vertex normals (optional: if nil, the face normals are used)
+	uvs      []Vec3   // per-vertex texture co-ordinates in x,y (optional)
+	colors   []Vec3   // per-vertex colours, which replace the diffuse colour of the material (optional)
+	indices  []uint32 // 3 vertex indices per triangle
+	bvh      *bvh
+	mat      *Material
+}
+
+// NewMesh creates a mesh from indexed vertex buffers, with 3 indices per triangle.
+// normals, uvs and colors may be nil, otherwise they hold one entry per vertex.
+func NewMesh(vertices, normals, uvs, colors []Vec3, indices []uint32, mat *Material) *Mesh {
+	if len(indices)%3 != 0 {
+		panic("The number of mesh indices is not a multiple of 3")
+	}
+	for _, buf := range [][]Vec3{normals, uvs, colors} {
+		if buf != nil && len(buf) != len(vertices) {
+			panic("The mesh normals, uvs and colors must have one entry per vertex")
+		}
+	}
+
+	m := &Mesh{vertices, normals, uvs, colors, indices, nil, mat}
+
+	// build the bvh over the bounding boxes of the triangles:
+	bounds := make([]AABB, m.NumTriangles())
+	for i := range bounds {
+		a, b, c := m.triangle(i)
+		bounds[i] = emptyAABB()
+		bounds[i].extend(a)
+		bounds[i].extend(b)
+		bounds[i].extend(c)
+	}
+	m.bvh = newBVH(bounds)
+	return m
+}
+
+// a copy of the mesh with another material (which shares its vertex buffers and bvh)
+func (m *Mesh) withMaterial(mat *Material) *Mesh {
+	res := *m
+	res.mat = mat
+	return &res
+}
+
+// NumTriangles returns the number of triangles in the mesh.
+func (m *Mesh) NumTriangles() int {
+	return len(m.indices) / 3
+}
+
+// the vertices of the i'th triangle
+func (m *Mesh) triangle(i int) (a, b, c Vec3) {
+	return m.vertices[m.indices[3*i]], m.vertices[m.indices[3*i+1]], m.vertices[m.indices[3*i+2]]
+}
+
+// Bounds returns the bounding box of the mesh.
+func (m *Mesh) Bounds() AABB {
+	if len(m.bvh.nodes) == 0 {
+		return emptyAABB()
+	}
+	return m.bvh.nodes[0].bounds
+}
+
+// GetMaterial returns the material of the mesh.
+func (m *Mesh) GetMaterial() *Material {
+	return m.mat
+}
+
+// Intersect checks if the ray intersects any triangle of the mesh within (tMin, tMax).
+func (m *Mesh) Intersect(ray Ray, tMin, tMax entry) (hit bool, res Intersection) {
+
+	// find the closest triangle, and the barycentric co-ordinates of the hit:
+	tri, dist, baryU, baryV := -1, ZERO, ZERO, ZERO
+	m.bvh.traverse(ray, tMin, tMax, false, func(i int, tMax entry) (bool, entry) {
+		a, b, c := m.triangle(i)
+		h, t, u, v := intersectTriangle(ray, a, b, c)
+		if h = h && t > tMin && t < tMax; h {
+			tri, dist, baryU, baryV = i, t, u, v
+		}
+		return h, t
+	})
+	if tri < 0 {
+		return false, res
+	}
+
+	// interpolate the point and normal across the triangle:
+	i0, i1, i2 := m.indices[3*tri], m.indices[3*tri+1], m.indices[3*tri+2]
+	a, b, c := m.vertices[i0], m.vertices[i1], m.vertices[i2]
+	pt := interpolate(a, b, c, baryU, baryV)
+	pError := interpolate(absVec(a), absVec(b), absVec(c), abs(baryU), abs(baryV)).scale(gamma(7))
+
+	var normal Vec3
+	if m.normals != nil {
+		normal = interpolate(m.normals[i0], m.normals[i1], m.normals[i2], baryU, baryV).direction()
+	} else {
+		normal = b.minus(a).cross(c.minus(a)).direction()
+	}
+
+	res = Intersection{point: pt, normal: normal, pError: pError, dist: dist, shape: m, index: tri}
+	if m.uvs != nil {
+		res.uv = interpolate(m.uvs[i0], m.uvs[i1], m.uvs[i2], baryU, baryV)
+	}
+	if m.colors != nil {
+		res.color, res.hasColor = interpolate(m.colors[i0], m.colors[i1], m.colors[i2], baryU, baryV), true
+	}
+	return true, res
+}
+
+// Occluded checks if the ray hits any triangle of the mesh within (tMin, tMax),
+// stopping at the first one found.
+func (m *Mesh) Occluded(ray Ray, tMin, tMax entry) bool {
+	return m.bvh.traverse(ray, tMin, tMax, true, func(i int, tMax entry) (bool, entry) {
+		a, b, c := m.triangle(i)
+		h, t, _, _ := intersectTriangle(ray, a, b, c)
+		return h && t > tMin && t < tMax, t
+	})
+}
+
+// interpolate the values at the corners of a triangle, using barycentric co-ordinates (u,v)
+func interpolate(a, b, c Vec3, u, v entry) Vec3 {
+	return a.scale(ONE - u - v).plus(b.scale(u)).plus(c.scale(v))
+}
+
+// ray-triangle intersection (using the Moller-Trumbore algorithm).
+// Returns whether the triangle was hit, the distance along the ray,
+// and the barycentric co-ordinates (u,v) of the hit, relative to b and c.
+func intersectTriangle(ray Ray, a, b, c Vec3) (hit bool, t, u, v entry) {
+	edge1, edge2 := b.minus(a), c.minus(a)
+	p := ray.direction.cross(edge2)
+	det := edge1.dot(p)
+	if det == 0 {
+		return // ray is parallel to the triangle
+	}
+	invDet := ONE / det
+
+	s := ray.start.minus(a)
+	if u = s.dot(p) * invDet; u < 0 || u > 1 {
+		return
+	}
+	q := s.cross(edge1)
+	if v = ray.direction.dot(q) * invDet; v < 0 || u+v > 1 {
+		return
+	}
+	if t = edge2.dot(q) * invDet; t <= 0 {
+		return
+	}
+	return true, t, u, v
+}
@@ -0,0 +1,159 @@
+// contains tests for mesh.go and bvh.go
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// a unit square in the z=0 plane, made of two triangles, with uvs matching x,y.
+func newSquareMesh(normals []Vec3) *Mesh {
+	vertices := []Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+	return NewMesh(vertices, normals, vertices, nil, []uint32{0, 1, 2, 0, 2, 3}, &Material{})
+}
+
+func TestIntersectionForSquareMesh(t *testing.T) {
+	m := newSquareMesh(nil)
+	msg := "Ray-Mesh intersection "
+
+	// case 0: hitting the first triangle (below the diagonal)
+	ray := Ray{Vec3{0.75, 0.25, 2}, Z_V3.scale(-ONE)}
+	exp := &Intersection{point: Vec3{0.75, 0.25, 0}, normal: Z_V3, dist: TWO}
+	assertIntersectionEquals(t, m, ray, true, exp, msg+"0")
+	_, res := m.Intersect(ray, ZERO, INF)
+	assert(t, res.index == 0, msg+fmt.Sprint("0: Expected triangle 0, got ", res.index))
+	assert(t, isMatEqual(res.uv[:], []entry{0.75, 0.25, 0}, V3LEN), msg+fmt.Sprint("0: unexpected uv ", res.uv))
+
+	// case 1: hitting the second triangle (above the diagonal), from below
+	ray = Ray{Vec3{0.25, 0.75, -3}, Z_V3}
+	exp = &Intersection{point: Vec3{0.25, 0.75, 0}, normal: Z_V3, dist: entry(3)}
+	assertIntersectionEquals(t, m, ray, true, exp, msg+"1")
+	_, res = m.Intersect(ray, ZERO, INF)
+	assert(t, res.index == 1, msg+fmt.Sprint("1: Expected triangle 1, got ", res.index))
+
+	// case 2: missing the square
+	ray = Ray{Vec3{1.5, 0.5, 2}, Z_V3.scale(-ONE)}
+	assertIntersectionEquals(t, m, ray, false, nil, msg+"2")
+
+	// case 3: with per-vertex normals, which are interpolated
+	n := Vec3{1, 0, 1}.direction()
+	m = newSquareMesh([]Vec3{Z_V3, n, n, Z_V3})
+	ray = Ray{Vec3{0.5, 0.25, 2}, Z_V3.scale(-ONE)}
+	exp = &Intersection{point: Vec3{0.5, 0.25, 0}, normal: Z_V3.plus(n.scale(ONE)).direction(), dist: TWO}
+	assertIntersectionEquals(t, m, ray, true, exp, msg+"3")
+}
+
+// the bvh should find the same closest triangle as testing every triangle.
+func TestMeshBVHMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rv := func(sc float64) Vec3 {
+		return Vec3{entry(rng.Float64() * sc), entry(rng.Float64() * sc), entry(rng.Float64() * sc)}
+	}
+
+	// a soup of random small triangles within a 10x10x10 cube:
+	numTris := 2000
+	vertices := make([]Vec3, 3*numTris)
+	indices := make([]uint32, 3*numTris)
+	for i := 0; i < numTris; i++ {
+		base := rv(10)
+		for j := 0; j < 3; j++ {
+			offset := rv(1)
+			vertices[3*i+j] = base.plus(offset)
+			indices[3*i+j] = uint32(3*i + j)
+		}
+	}
+	m := NewMesh(vertices, nil, nil, nil, indices, &Material{})
+
+	for k := 0; k < 500; k++ {
+		start, target := rv(10), rv(10)
+		start[cZ] -= 20
+		ray := Ray{start, target.minus(start).direction()}
+
+		// brute force:
+		expTri, expDist := -1, INF
+		for i := 0; i < numTris; i++ {
+			a, b, c := m.triangle(i)
+			if h, d, _, _ := intersectTriangle(ray, a, b, c); h && d < expDist {
+				expTri, expDist = i, d
+			}
+		}
+
+		hit, res := m.Intersect(ray, ZERO, INF)
+		if !assert(t, hit == (expTri >= 0), fmt.Sprint("BVH ray ", k, ": Expected Hit: ", expTri >= 0)) || !hit {
+			continue
+		}
+		assert(t, res.index == expTri, fmt.Sprint("BVH ray ", k, ": Expected triangle ", expTri, ", got ", res.index))
+
+		// the interval excludes the closest triangle when it ends before it:
+		assert(t, m.Occluded(ray, ZERO, expDist*1.01), fmt.Sprint("BVH ray ", k, ": expected to be occluded"))
+		assert(t, !m.Occluded(ray, ZERO, expDist*0.99), fmt.Sprint("BVH ray ", k, ": expected not to be occluded"))
+		h, _ := m.Intersect(ray, ZERO, expDist*0.99)
+		assert(t, !h, fmt.Sprint("BVH ray ", k, ": expected no hit before the closest triangle"))
+	}
+}
+
+// starting the interval after a hit should find the next hit along the ray.
+func TestMeshIntervalFindsEachHit(t *testing.T) {
+	// a stack of 5 unit squares, at z=0..4:
+	var vertices []Vec3
+	var indices []uint32
+	for z := 0; z < 5; z++ {
+		base := uint32(len(vertices))
+		for _, v := range []Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}} {
+			vertices = append(vertices, v.plus(Vec3{0, 0, entry(z)}))
+		}
+		indices = append(indices, base, base+1, base+2, base, base+2, base+3)
+	}
+	m := NewMesh(vertices, nil, nil, nil, indices, &Material{})
+
+	ray, tMin := Ray{Vec3{0.3, 0.6, -1}, Z_V3}, ZERO
+	for z := 0; z < 5; z++ {
+		hit, res := m.Intersect(ray, tMin, INF)
+		if !assert(t, hit, fmt.Sprint("Mesh interval: expected a hit at z=", z)) {
+			return
+		}
+		assert(t, !res.dist.neq(entry(z+1)), fmt.Sprint("Mesh interval: distance to z=", z, ": ", res.dist))
+		tMin = res.dist
+	}
+	hit, _ := m.Intersect(ray, tMin, INF)
+	assert(t, !hit && !m.Occluded(ray, tMin, INF), "Mesh interval: expected no hit after the last square")
+}
+
+// a mesh of many overlapping random triangles, and rays through it
+func benchmarkMesh() (*Mesh, []Ray) {
+	rng := rand.New(rand.NewSource(2))
+	rv := func(sc float64) Vec3 {
+		return Vec3{entry(rng.Float64() * sc), entry(rng.Float64() * sc), entry(rng.Float64() * sc)}
+	}
+	vertices := make([]Vec3, 3*20000)
+	indices := make([]uint32, len(vertices))
+	for i := range vertices {
+		vertices[i], indices[i] = rv(10).plus(Vec3{0, 0, entry(i / 3 % 10)}), uint32(i)
+	}
+	rays := make([]Ray, 1000)
+	for i := range rays {
+		start := rv(10).minus(Vec3{0, 0, 20})
+		rays[i] = Ray{start, rv(10).minus(start).direction()}
+	}
+	return NewMesh(vertices, nil, nil, nil, indices, &Material{}), rays
+}
+
+func BenchmarkMeshIntersect(b *testing.B) {
+	m, rays := benchmarkMesh()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Intersect(rays[i%len(rays)], ZERO, INF)
+	}
+}
+
+func BenchmarkMeshOccluded(b *testing.B) {
+	m, rays := benchmarkMesh()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Occluded(rays[i%len(rays)], ZERO, INF)
+	}
+}
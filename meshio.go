@@ -0,0 +1,51 @@
+// meshio.go: Contains loading of meshes from files.
+// The supported formats are PLY (see ply.go) and STL (see stl.go).
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadMesh reads a mesh file, choosing the format from the file extension.
+func LoadMesh(path string, mat *Material) (*Mesh, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	read, err := meshReader(filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	mesh, err := read(bufio.NewReader(file), mat)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return mesh, nil
+}
+
+// the reader of the mesh format of a file extension (e.g. ".ply")
+func meshReader(ext string) (func(r io.Reader, mat *Material) (*Mesh, error), error) {
+	switch ext = strings.ToLower(ext); ext {
+	case ".ply":
+		return ReadPLY, nil
+	case ".stl":
+		return ReadSTL, nil
+	}
+	return nil, fmt.Errorf("unsupported mesh format %q", ext)
+}
+
+// triangulate a polygon (given by vertex indices) as a fan, appending the triangles to indices.
+func triangulate(indices []uint32, polygon []uint32) []uint32 {
+	for i := 2; i < len(polygon); i++ {
+		indices = append(indices, polygon[0], polygon[i-1], polygon[i])
+	}
+	return indices
+}
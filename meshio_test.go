@@ -0,0 +1,152 @@
+// contains tests for meshio.go, ply.go and stl.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// a unit square in the z=0 plane, as a single PLY polygon, with (8-bit) vertex colours
+const asciiSquarePLY = `ply
+format ascii 1.0
+comment a unit square
+element vertex 4
+property float x
+property float y
+property float z
+property uchar red
+property uchar green
+property uchar blue
+element face 1
+property list uchar int vertex_indices
+element edge 1
+property int vertex1
+property int vertex2
+end_header
+0 0 0 255 0 0
+1 0 0 255 0 0
+1 1 0 0 0 255
+0 1 0 0 0 255
+4 0 1 2 3
+0 1
+`
+
+func TestReadASCIIPLY(t *testing.T) {
+	m, err := ReadPLY(strings.NewReader(asciiSquarePLY), &Material{diffuse: X_V3})
+	if !assert(t, err == nil, fmt.Sprint("ASCII PLY: unexpected error ", err)) {
+		return
+	}
+	assertSquareMesh(t, m, "ASCII PLY")
+
+	// the colours are interpolated, half-way between red and blue:
+	_, res := m.Intersect(Ray{Vec3{0.5, 0.5, 1}, Z_V3.scale(-ONE)}, ZERO, INF)
+	assert(t, res.hasColor && isMatEqual(res.color[:], []entry{0.5, 0, 0.5}, V3LEN), "ASCII PLY: unexpected colour")
+
+	// and replace the diffuse colour of the material:
+	mat := surfaceMaterial(m.GetMaterial(), &res)
+	assertEquals(t, res.color, mat.diffuse, "ASCII PLY: diffuse colour")
+	assertEquals(t, X_V3, m.GetMaterial().diffuse, "ASCII PLY: original diffuse colour")
+}
+
+func TestReadBinaryPLY(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		name := "binary_little_endian"
+		if order == binary.BigEndian {
+			name = "binary_big_endian"
+		}
+
+		buf := &bytes.Buffer{}
+		fmt.Fprint(buf, "ply\nformat ", name, " 1.0\nelement vertex 4\n",
+			"property float x\nproperty float y\nproperty double z\n",
+			"element face 2\nproperty list uchar uint vertex_index\nend_header\n")
+		for _, v := range []Vec3{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}} {
+			binary.Write(buf, order, float32(v[cX]))
+			binary.Write(buf, order, float32(v[cY]))
+			binary.Write(buf, order, float64(v[cZ]))
+		}
+		for _, face := range [][]uint32{{0, 1, 2}, {0, 2, 3}} {
+			buf.WriteByte(3)
+			binary.Write(buf, order, face)
+		}
+
+		m, err := ReadPLY(buf, &Material{})
+		if assert(t, err == nil, fmt.Sprint(name, " PLY: unexpected error ", err)) {
+			assertSquareMesh(t, m, name+" PLY")
+		}
+	}
+}
+
+func TestReadASCIISTL(t *testing.T) {
+	stl := `solid square
+facet normal 0 0 1
+  outer loop
+    vertex 0 0 0
+    vertex 1 0 0
+    vertex 1 1 0
+  endloop
+endfacet
+facet normal 0 0 1
+  outer loop
+    vertex 0 0 0
+    vertex 1 1 0
+    vertex 0 1 0
+  endloop
+endfacet
+endsolid square
+`
+	m, err := ReadSTL(strings.NewReader(stl), &Material{})
+	if assert(t, err == nil, fmt.Sprint("ASCII STL: unexpected error ", err)) {
+		assertSquareMesh(t, m, "ASCII STL")
+	}
+}
+
+func TestReadBinarySTL(t *testing.T) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("solid (binary files may start with solid too)")
+	buf.Write(make([]byte, 80-buf.Len()))
+	binary.Write(buf, binary.LittleEndian, uint32(2))
+	for _, tri := range [][3]Vec3{{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}}, {{0, 0, 0}, {1, 1, 0}, {0, 1, 0}}} {
+		data := []float32{0, 0, 1} // normal
+		for _, v := range tri {
+			data = append(data, float32(v[cX]), float32(v[cY]), float32(v[cZ]))
+		}
+		binary.Write(buf, binary.LittleEndian, data)
+		binary.Write(buf, binary.LittleEndian, uint16(0))
+	}
+
+	m, err := ReadSTL(buf, &Material{})
+	if assert(t, err == nil, fmt.Sprint("binary STL: unexpected error ", err)) {
+		assertSquareMesh(t, m, "binary STL")
+	}
+}
+
+func TestReadInvalidMeshes(t *testing.T) {
+	_, err := ReadPLY(strings.NewReader("solid x\nendsolid x\n"), &Material{})
+	assert(t, err != nil, "PLY: expected an error for a non-PLY file")
+
+	_, err = ReadPLY(strings.NewReader(strings.Replace(asciiSquarePLY, "4 0 1 2 3", "4 0 1 2 7", 1)), &Material{})
+	assert(t, err != nil, "PLY: expected an error for an out-of-range index")
+
+	_, err = ReadSTL(strings.NewReader("ply\n"), &Material{})
+	assert(t, err != nil, "STL: expected an error for a non-STL file")
+}
+
+// check that the mesh is the unit square in the z=0 plane, as 2 triangles sharing 4 vertices
+func assertSquareMesh(t *testing.T, m *Mesh, msg string) {
+	assertEquals(t, 2, m.NumTriangles(), msg+": number of triangles")
+	assertEquals(t, 4, len(m.vertices), msg+": number of vertices")
+
+	for _, pt := range []Vec3{{0.75, 0.25, 0}, {0.25, 0.75, 0}} {
+		ray := Ray{pt.plus(Z_V3), Z_V3.scale(-ONE)}
+		hit, res := m.Intersect(ray, ZERO, INF)
+		if assert(t, hit, msg+fmt.Sprint(": expected a hit at ", pt)) {
+			assert(t, isMatEqual(pt[:], res.point[:], V3LEN) && !math.IsNaN(float64(res.normal[cZ])),
+				msg+fmt.Sprint(": unexpected intersection ", res))
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// pbr.go: Contains a physically based (metallic-roughness) material.
+//
+// The BSDF is the sum of a Lambertian diffuse lobe and a microfacet specular lobe, using:
+//	- the GGX (Trowbridge-Reitz) normal distribution
+//	- the Smith (separable) masking-shadowing function
+//	- Schlick's approximation of the Fresnel reflectance
+// All directions are unit vectors pointing away from the surface:
+// wo towards the viewer, and wi towards the light.
+
+package main
+
+import "math"
+
+// A PBRMaterial holds the parameters of the metallic-roughness model.
+type PBRMaterial struct {
+	baseColor Vec3  // the diffuse colour of dielectrics, or the specular colour of metals
+	metallic  entry // 0 for dielectrics, up to 1 for metals
+	roughness entry // 0 for smooth (mirror-like) surfaces, up to 1 for rough surfaces
+}
+
+// the reflectance at normal incidence of dielectrics (e.g. plastic, glass)
+var dielectricF0 = Vec3{0.04, 0.04, 0.04}
+
+// the least roughness used by the distribution (smoother surfaces are numerically unstable)
+const minRoughness = 0.03
+
+// NewPBRMaterial creates a Material using the metallic-roughness model.
+// The Blinn-Phong colours of the Material are set to approximate it:
+// the specular colour (which also scales mirror reflections) is the reflectance at normal incidence.
+func NewPBRMaterial(baseColor, emission Vec3, metallic, roughness entry) *Material {
+	pbr := &PBRMaterial{baseColor, metallic, roughness}
+	return &Material{ZERO_V3, emission, pbr.diffuse(), pbr.f0(), roughnessToShininess(roughness), roughness, pbr}
+}
+
+// the Blinn-Phong diffuse colour: metals have none
+func (p *PBRMaterial) diffuse() Vec3 {
+	return p.baseColor.scale(ONE - p.metallic)
+}
+
+// the GGX distribution parameter
+func (p *PBRMaterial) alpha() entry {
+	return roughnessToAlpha(p.roughness)
+}
+
+// the GGX distribution parameter of a roughness
+func roughnessToAlpha(roughness entry) entry {
+	r := maxEntry(roughness, minRoughness)
+	return r * r
+}
+
+// the Blinn-Phong shininess of a roughness:
+// a GGX lobe of alpha (=roughness^2) is similar to a Blinn-Phong lobe of shininess 2/alpha^2 - 2
+func roughnessToShininess(roughness entry) entry {
+	alpha := roughnessToAlpha(roughness)
+	return maxEntry(TWO/(alpha*alpha)-TWO, ONE)
+}
+
+// the Fresnel reflectance at normal incidence: blended from dielectrics to the base colour of metals
+func (p *PBRMaterial) f0() Vec3 {
+	return dielectricF0.scale(ONE - p.metallic).plus(p.baseColor.scale(p.metallic))
+}
+
+// Schlick's approximation of the Fresnel reflectance, for the cosine between the direction and the half-vector
+func fresnelSchlick(f0 Vec3, cosTheta entry) Vec3 {
+	w := (ONE - cosTheta).pow(5)
+	return f0.scale(ONE - w).plus(Vec3{w, w, w})
+}
+
+// the GGX normal distribution, for the cosine between the normal and the half-vector
+func ggxD(cosH, alpha entry) entry {
+	a2 := alpha * alpha
+	d := cosH*cosH*(a2-ONE) + ONE
+	return a2 / (math.Pi * d * d)
+}
+
+// the Smith masking function of one direction, for its cosine to the normal
+func smithG1(cosV, alpha entry) entry {
+	a2 := alpha * alpha
+	return TWO * cosV / (cosV + sqrt(a2+(ONE-a2)*cosV*cosV))
+}
+
+// the probability of sampling the specular lobe (rather than the diffuse lobe)
+func (p *PBRMaterial) specularProbability() entry {
+	return (ONE + p.metallic) / TWO
+}
+
+// flip the normal to the side of wo (e.g. when viewing the back of a mesh)
+func facingNormal(normal, wo Vec3) Vec3 {
+	if normal.dot(wo) < 0 {
+		return normal.scale(-ONE)
+	}
+	return normal
+}
+
+// Eval returns the value of the BSDF for light arriving along wi and leaving along wo.
+func (p *PBRMaterial) Eval(normal, wo, wi Vec3) Vec3 {
+	n := facingNormal(normal, wo)
+	cosO, cosI := n.dot(wo), n.dot(wi)
+	if cosO <= 0 || cosI <= 0 {
+		return ZERO_V3 // light from below the surface
+	}
+
+	// specular: D * G * F / (4 cosO cosI)
+	alpha := p.alpha()
+	half := wo.plus(wi).direction()
+	fresnel := fresnelSchlick(p.f0(), maxEntry(wo.dot(half), ZERO))
+	g := smithG1(cosO, alpha) * smithG1(cosI, alpha)
+	specular := fresnel.scale(ggxD(n.dot(half), alpha) * g / (FOUR * cosO * cosI))
+
+	// diffuse: the light which is not reflected (on the way in or out) by the surface, nor absorbed by metals.
+	// (using the fresnel terms of both directions keeps this reciprocal)
+	white := Vec3{1, 1, 1}
+	kd := white.minus(fresnelSchlick(p.f0(), cosO)).times(white.minus(fresnelSchlick(p.f0(), cosI))).scale(ONE - p.metallic)
+	diffuse := kd.times(p.baseColor).scale(ONE / math.Pi)
+
+	return diffuse.plus(specular)
+}
+
+// Pdf returns the probability density (per solid angle) with which Sample chooses wi.
+func (p *PBRMaterial) Pdf(normal, wo, wi Vec3) entry {
+	n := facingNormal(normal, wo)
+	cosO, cosI := n.dot(wo), n.dot(wi)
+	if cosO <= 0 || cosI <= 0 {
+		return ZERO
+	}
+	half := wo.plus(wi).direction()
+	pSpec := p.specularProbability()
+	specPdf := ggxD(n.dot(half), p.alpha()) * n.dot(half) / (FOUR * wo.dot(half))
+	diffPdf := cosI / math.Pi
+	return pSpec*specPdf + (ONE-pSpec)*diffPdf
+}
+
+// Sample chooses a direction wi (given wo and three uniform random numbers in [0,1)),
+// returning it with the value of the BSDF and the pdf. A zero pdf means no direction was chosen.
+func (p *PBRMaterial) Sample(normal, wo Vec3, u1, u2, u3 entry) (wi, f Vec3, pdf entry) {
+	n := facingNormal(normal, wo)
+	if n.dot(wo) <= 0 {
+		return ZERO_V3, ZERO_V3, ZERO
+	}
+	tangent, bitangent := orthonormalBasis(n)
+
+	if u3 < p.specularProbability() {
+		// specular: sample a half-vector from the distribution, and reflect wo about it
+		wi = reflect(wo.scale(-ONE), p.sampleHalfVector(n, u1, u2))
+	} else {
+		// diffuse: cosine-weighted
+		wi = fromLocal(cosineHemisphere(u1, u2), tangent, bitangent, n)
+	}
+
+	if pdf = p.Pdf(n, wo, wi); pdf <= 0 {
+		return ZERO_V3, ZERO_V3, ZERO
+	}
+	return wi, p.Eval(n, wo, wi), pdf
+}
+
+// sample a half-vector about the normal from the GGX distribution (with pdf D(cosH) * cosH)
+func (p *PBRMaterial) sampleHalfVector(normal Vec3, u1, u2 entry) Vec3 {
+	tangent, bitangent := orthonormalBasis(normal)
+	a2 := p.alpha() * p.alpha()
+	cosH := sqrt((ONE - u1) / (ONE + (a2-ONE)*u1))
+	return fromLocal(sphericalDirection(cosH, u2), tangent, bitangent, normal)
+}
+
+// SampleReflection chooses a direction wi from the specular lobe only (for tracing glossy reflections),
+// returning it with its weight: the specular BSDF times the cosine, divided by the pdf.
+// A zero weight means the direction is below the surface.
+func (p *PBRMaterial) SampleReflection(normal, wo Vec3, u1, u2 entry) (wi, weight Vec3) {
+	n := facingNormal(normal, wo)
+	half := p.sampleHalfVector(n, u1, u2)
+	wi = reflect(wo.scale(-ONE), half)
+
+	cosO, cosI, cosH, woH := n.dot(wo), n.dot(wi), n.dot(half), wo.dot(half)
+	if cosO <= 0 || cosI <= 0 || woH <= 0 {
+		return wi, ZERO_V3
+	}
+
+	// F*D*G / (4 cosO cosI) * cosI, divided by the pdf D*cosH / (4 woH):
+	alpha := p.alpha()
+	g := smithG1(cosO, alpha) * smithG1(cosI, alpha)
+	return wi, fresnelSchlick(p.f0(), woH).scale(g * woH / (cosO * cosH))
+}
+
+// An implementation of a Shader: the physically based BSDF (only for materials which have one).
+func PBRShader(light Light, lightDir, normal Vec3, ray Ray, mat *Material, dist entry) Vec3 {
+	// the incident light is scaled by the cosine to the normal (on the side of the viewer):
+	wo := ray.direction.scale(-ONE)
+	cosI := facingNormal(normal, wo).dot(lightDir)
+	if cosI <= 0 {
+		return ZERO_V3
+	}
+	f := mat.pbr.Eval(normal, wo, lightDir)
+	return light.GetColor().scale(cosI / light.AttenuationAt(dist)).times(f)
+}
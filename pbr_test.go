@@ -0,0 +1,98 @@
+// contains tests for pbr.go
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// a grid of materials to test: (metallic, roughness) pairs, all with a white base colour
+func pbrTestMaterials() []*PBRMaterial {
+	var res []*PBRMaterial
+	for _, metallic := range []entry{0, 0.5, 1} {
+		for _, roughness := range []entry{0.1, 0.4, 1} {
+			res = append(res, &PBRMaterial{Vec3{1, 1, 1}, metallic, roughness})
+		}
+	}
+	return res
+}
+
+// a random direction in the hemisphere about the normal
+func randomHemisphere(rng *rand.Rand, normal Vec3) Vec3 {
+	t, b := orthonormalBasis(normal)
+	return fromLocal(sphericalDirection(entry(rng.Float64()), entry(rng.Float64())), t, b, normal)
+}
+
+func TestPBRReciprocity(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	normal := Vec3{1, 2, 3}.direction()
+	for _, p := range pbrTestMaterials() {
+		for k := 0; k < 20; k++ {
+			wo, wi := randomHemisphere(rng, normal), randomHemisphere(rng, normal)
+			f1, f2 := p.Eval(normal, wo, wi), p.Eval(normal, wi, wo)
+			assert(t, isMatEqual(f1[:], f2[:], V3LEN), fmt.Sprint("PBR reciprocity ", *p, ": ", f1, " vs ", f2))
+		}
+	}
+}
+
+// the values returned by Sample should match Eval and Pdf
+func TestPBRSampleMatchesEvalAndPdf(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	normal := Y_V3
+	for _, p := range pbrTestMaterials() {
+		for k := 0; k < 50; k++ {
+			wo := randomHemisphere(rng, normal)
+			wi, f, pdf := p.Sample(normal, wo, entry(rng.Float64()), entry(rng.Float64()), entry(rng.Float64()))
+			if pdf == 0 {
+				continue // sampled below the surface
+			}
+			expF, expPdf := p.Eval(normal, wo, wi), p.Pdf(normal, wo, wi)
+			assert(t, isMatEqual(f[:], expF[:], V3LEN), fmt.Sprint("PBR sample value ", *p, ": ", f, " vs ", expF))
+			assert(t, !pdf.neq(expPdf), fmt.Sprint("PBR sample pdf ", *p, ": ", pdf, " vs ", expPdf))
+			assert(t, wi.dot(normal) > 0, fmt.Sprint("PBR sample ", *p, ": direction below the surface"))
+		}
+	}
+}
+
+// the pdf should integrate to (at most) one over the hemisphere
+// (less than one, since some specular samples are reflected below the surface)
+func TestPBRPdfIsNormalized(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	normal, numSamples := Z_V3, 100000
+	wo := Vec3{0.3, 0, 1}.direction()
+	for _, p := range pbrTestMaterials() {
+		if p.roughness < 0.4 {
+			continue // too peaked for uniform sampling
+		}
+		sum := ZERO
+		for k := 0; k < numSamples; k++ {
+			sum += p.Pdf(normal, wo, randomHemisphere(rng, normal)) * 2 * math.Pi // uniform pdf is 1/(2pi)
+		}
+		integral := sum / entry(numSamples)
+		assert(t, integral > 0.4 && integral < 1.05, fmt.Sprint("PBR pdf integral ", *p, ": ", integral))
+	}
+}
+
+// white furnace test: a white material should reflect at most all of the light
+// (rough metals lose some energy, since the microfacet model only accounts for single scattering)
+func TestPBREnergyConservation(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	normal, numSamples := Z_V3, 50000
+	for _, cosO := range []entry{0.2, 0.7, 1} {
+		wo := sphericalDirection(cosO, 0)
+		for _, p := range pbrTestMaterials() {
+			albedo := ZERO_V3
+			for k := 0; k < numSamples; k++ {
+				wi, f, pdf := p.Sample(normal, wo, entry(rng.Float64()), entry(rng.Float64()), entry(rng.Float64()))
+				if pdf > 0 {
+					albedo = albedo.plus(f.scale(wi.dot(normal) / pdf))
+				}
+			}
+			a := albedo[cX] / entry(numSamples)
+			assert(t, a <= 1.02 && a > 0.25, fmt.Sprint("PBR albedo ", *p, " at cos ", cosO, ": ", a))
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// ply.go: Contains a reader for PLY (polygon file format) meshes,
+// in ASCII and binary (little or big endian) form.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A property of a PLY element: either a scalar, or a list (of scalars, preceded by a count)
+type plyProperty struct {
+	name           string
+	typ, countType string // countType is only used for lists
+	isList         bool
+}
+
+// An element of a PLY file (e.g. vertex or face), which is repeated count times.
+type plyElement struct {
+	name  string
+	count int
+	props []plyProperty
+}
+
+// the size (in bytes) of each PLY scalar type, including the alternative names
+var plyTypeSizes = map[string]int{
+	"char": 1, "uchar": 1, "int8": 1, "uint8": 1,
+	"short": 2, "ushort": 2, "int16": 2, "uint16": 2,
+	"int": 4, "uint": 4, "int32": 4, "uint32": 4,
+	"float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+// a source of PLY values, either ASCII or binary
+type plyReader interface {
+	read(typ string) (float64, error)
+}
+
+// ReadPLY reads an ASCII or binary PLY file into a mesh.
+// Vertex positions (x,y,z) are required; normals (nx,ny,nz), texture co-ordinates (u,v or s,t)
+// and colours (red,green,blue) are used if present. Polygon faces are triangulated.
+func ReadPLY(r io.Reader, mat *Material) (*Mesh, error) {
+	// (the whole file is read first, so that the counts of the elements can be checked against its size)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("ply: %v", err)
+	}
+	in := bufio.NewReader(bytes.NewReader(data))
+	format, elements, err := readPLYHeader(in, len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var values plyReader
+	switch format {
+	case "ascii":
+		scanner := bufio.NewScanner(in)
+		scanner.Split(bufio.ScanWords)
+		values = &plyASCIIReader{scanner}
+	case "binary_little_endian":
+		values = &plyBinaryReader{in, binary.LittleEndian}
+	case "binary_big_endian":
+		values = &plyBinaryReader{in, binary.BigEndian}
+	default:
+		return nil, fmt.Errorf("ply: unknown format %q", format)
+	}
+
+	var vertices, normals, uvs, colors []Vec3
+	var indices []uint32
+	for _, el := range elements {
+		switch el.name {
+		case "vertex":
+			vertices, normals, uvs, colors, err = readPLYVertices(values, &el)
+		case "face":
+			indices, err = readPLYFaces(values, &el, len(vertices))
+		default:
+			err = skipPLYElement(values, &el)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ply: reading %s: %v", el.name, err)
+		}
+	}
+	if len(indices) == 0 {
+		return nil, errors.New("ply: no faces")
+	}
+	return NewMesh(vertices, normals, uvs, colors, indices, mat), nil
+}
+
+// read the header of a PLY file (of size bytes), up to (and including) the end_header line
+func readPLYHeader(in *bufio.Reader, size int) (format string, elements []plyElement, err error) {
+	for line := 0; ; line++ {
+		text, err := in.ReadString('\n')
+		if err != nil {
+			return "", nil, fmt.Errorf("ply: reading header: %v", err)
+		}
+		fields := strings.Fields(text)
+		if line == 0 {
+			if len(fields) != 1 || fields[0] != "ply" {
+				return "", nil, errors.New("ply: not a PLY file")
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("ply: line %d: invalid format", line)
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("ply: line %d: invalid element", line)
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return "", nil, fmt.Errorf("ply: line %d: %v", line, err)
+			}
+			if count < 0 {
+				return "", nil, fmt.Errorf("ply: line %d: negative element count %d", line, count)
+			}
+			elements = append(elements, plyElement{fields[1], count, nil})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, fmt.Errorf("ply: line %d: property outside of an element", line)
+			}
+			var prop plyProperty
+			if len(fields) == 5 && fields[1] == "list" {
+				prop = plyProperty{fields[4], fields[3], fields[2], true}
+			} else if len(fields) == 3 {
+				prop = plyProperty{fields[2], fields[1], "", false}
+			} else {
+				return "", nil, fmt.Errorf("ply: line %d: invalid property", line)
+			}
+			if plyTypeSizes[prop.typ] == 0 || (prop.isList && plyTypeSizes[prop.countType] == 0) {
+				return "", nil, fmt.Errorf("ply: line %d: unknown property type", line)
+			}
+			el := &elements[len(elements)-1]
+			el.props = append(el.props, prop)
+		case "end_header":
+			for _, el := range elements {
+				if el.count > size/el.minSize(format == "ascii") {
+					return "", nil, fmt.Errorf("ply: %d %s elements are more than the file can hold", el.count, el.name)
+				}
+			}
+			return format, elements, nil
+		}
+		// anything else (e.g. comment, obj_info) is ignored
+	}
+}
+
+// the fewest bytes an element can take in the file (at least 1): in ASCII, each value and a separator,
+// and in binary, the size of each scalar (or of the count of each list)
+func (el *plyElement) minSize(ascii bool) int {
+	size := 0
+	for _, prop := range el.props {
+		switch {
+		case ascii:
+			size += 2
+		case prop.isList:
+			size += plyTypeSizes[prop.countType]
+		default:
+			size += plyTypeSizes[prop.typ]
+		}
+	}
+	return maxInt(size, 1)
+}
+
+// the vertex buffers which can be read from a PLY file
+const (
+	plyPositions = iota
+	plyNormals
+	plyUVs
+	plyColors
+	plyNumBuffers
+)
+
+// where each vertex property is stored: the buffer and the component
+var plyVertexProperties = map[string][2]int{
+	"x": {plyPositions, cX}, "y": {plyPositions, cY}, "z": {plyPositions, cZ},
+	"nx": {plyNormals, cX}, "ny": {plyNormals, cY}, "nz": {plyNormals, cZ},
+	"u": {plyUVs, cX}, "v": {plyUVs, cY}, "s": {plyUVs, cX}, "t": {plyUVs, cY},
+	"texture_u": {plyUVs, cX}, "texture_v": {plyUVs, cY}, "texture_s": {plyUVs, cX}, "texture_t": {plyUVs, cY},
+	"red": {plyColors, cX}, "green": {plyColors, cY}, "blue": {plyColors, cZ},
+	"diffuse_red": {plyColors, cX}, "diffuse_green": {plyColors, cY}, "diffuse_blue": {plyColors, cZ},
+}
+
+// read the vertex positions, and any normals, texture co-ordinates and colours
+func readPLYVertices(values plyReader, el *plyElement) (vertices, normals, uvs, colors []Vec3, err error) {
+	var bufs [plyNumBuffers][]Vec3
+
+	// allocate the buffers which are present:
+	for _, prop := range el.props {
+		if target, ok := plyVertexProperties[prop.name]; ok && !prop.isList && bufs[target[0]] == nil {
+			bufs[target[0]] = make([]Vec3, el.count)
+		}
+	}
+	if bufs[plyPositions] == nil {
+		return nil, nil, nil, nil, errors.New("no vertex positions")
+	}
+
+	for v := 0; v < el.count; v++ {
+		for _, prop := range el.props {
+			target, ok := plyVertexProperties[prop.name]
+			if !ok || prop.isList {
+				if err = skipPLYProperty(values, &prop); err != nil {
+					return
+				}
+				continue
+			}
+			x, err := values.read(prop.typ)
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			if target[0] == plyColors && (prop.typ == "uchar" || prop.typ == "uint8") {
+				x /= 255 // 8-bit colours
+			}
+			bufs[target[0]][v][target[1]] = entry(x)
+		}
+	}
+	return bufs[plyPositions], bufs[plyNormals], bufs[plyUVs], bufs[plyColors], nil
+}
+
+// read the faces as (triangulated) vertex indices
+func readPLYFaces(values plyReader, el *plyElement, numVertices int) (indices []uint32, err error) {
+	indices = make([]uint32, 0, 3*el.count)
+	polygon := make([]uint32, 0, 4)
+	for f := 0; f < el.count; f++ {
+		for _, prop := range el.props {
+			if !prop.isList || (prop.name != "vertex_indices" && prop.name != "vertex_index") {
+				if err = skipPLYProperty(values, &prop); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			n, err := values.read(prop.countType)
+			if err != nil {
+				return nil, err
+			}
+			polygon = polygon[:0]
+			for i := 0; i < int(n); i++ {
+				x, err := values.read(prop.typ)
+				if err != nil {
+					return nil, err
+				}
+				if x < 0 || int(x) >= numVertices {
+					return nil, fmt.Errorf("face %d: vertex index %v out of range", f, x)
+				}
+				polygon = append(polygon, uint32(x))
+			}
+			indices = triangulate(indices, polygon)
+		}
+	}
+	return indices, nil
+}
+
+// skip all of the instances of an element
+func skipPLYElement(values plyReader, el *plyElement) error {
+	for i := 0; i < el.count; i++ {
+		for _, prop := range el.props {
+			if err := skipPLYProperty(values, &prop); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func skipPLYProperty(values plyReader, prop *plyProperty) error {
+	if prop.isList {
+		return skipPLYList(values, prop)
+	}
+	_, err := values.read(prop.typ)
+	return err
+}
+
+func skipPLYList(values plyReader, prop *plyProperty) error {
+	n, err := values.read(prop.countType)
+	for i := 0; err == nil && i < int(n); i++ {
+		_, err = values.read(prop.typ)
+	}
+	return err
+}
+
+// reads whitespace-separated values
+type plyASCIIReader struct {
+	scanner *bufio.Scanner
+}
+
+func (p *plyASCIIReader) read(typ string) (float64, error) {
+	if !p.scanner.Scan() {
+		if err := p.scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrUnexpectedEOF
+	}
+	return strconv.ParseFloat(p.scanner.Text(), 64)
+}
+
+// reads values of the given byte order
+type plyBinaryReader struct {
+	in    *bufio.Reader
+	order binary.ByteOrder
+}
+
+func (p *plyBinaryReader) read(typ string) (float64, error) {
+	var buf [8]byte
+	b := buf[:plyTypeSizes[typ]]
+	if _, err := io.ReadFull(p.in, b); err != nil {
+		return 0, err
+	}
+	switch typ {
+	case "char", "int8":
+		return float64(int8(b[0])), nil
+	case "uchar", "uint8":
+		return float64(b[0]), nil
+	case "short", "int16":
+		return float64(int16(p.order.Uint16(b))), nil
+	case "ushort", "uint16":
+		return float64(p.order.Uint16(b)), nil
+	case "int", "int32":
+		return float64(int32(p.order.Uint32(b))), nil
+	case "uint", "uint32":
+		return float64(p.order.Uint32(b)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(p.order.Uint32(b))), nil
+	default: // double, float64
+		return math.Float64frombits(p.order.Uint64(b)), nil
+	}
+}
@@ -0,0 +1,23 @@
+// contains tests for the PLY header checks of ply.go (the meshes read are tested in meshio_test.go)
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadMalformedPLYHeaders(t *testing.T) {
+	for name, replace := range map[string][2]string{
+		"a negative vertex count":     {"element vertex 4", "element vertex -3"},
+		"a negative face count":       {"element face 1", "element face -1"},
+		"a negative count of others":  {"element edge 1", "element edge -2"},
+		"more vertices than the file": {"element vertex 4", "element vertex 1000000000"},
+		"more faces than the file":    {"element face 1", "element face 1000"},
+		"a count beyond an int":       {"element vertex 4", "element vertex 99999999999999999999"},
+	} {
+		ply := strings.Replace(asciiSquarePLY, replace[0], replace[1], 1)
+		_, err := ReadPLY(strings.NewReader(ply), &Material{})
+		assert(t, err != nil, "PLY: expected an error for "+name)
+	}
+}
@@ -0,0 +1,316 @@
+// preview.go: Contains the live preview of progressive renders: a small web page which shows the image refining,
+// as the passes of the render are streamed to it (as Server-Sent Events).
+//
+// The routes:
+//	GET /             the page
+//	GET /events       a stream of events: "pass" (with the status of the render, as json) after each pass,
+//	                  and "done" once the render has finished
+//	GET /image.png    the image of the latest pass
+//	GET /pixel?x=&y=  the colour (as floats) and the sample count of a pixel of the latest pass, as json
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the status of a progressive render, as sent to the page
+type previewStatus struct {
+	Pass            int
+	SamplesPerPixel int
+	TargetSamples   int `json:",omitempty"` // (0 for no target)
+	Width, Height   int
+	Elapsed         float64 // in seconds
+	ETA             float64 `json:",omitempty"` // the estimated time until the render finishes, in seconds (0 if unknown)
+	SamplesPerSec   float64
+	Done            bool
+	Error           string `json:",omitempty"`
+}
+
+// A PreviewServer serves a live preview (as an http.Handler) of a progressive render.
+type PreviewServer struct {
+	targetSamples int
+	timeBudget    time.Duration
+
+	mu          sync.Mutex
+	status      *previewStatus // the status of the latest pass (nil before the first)
+	pass        ProgressivePass
+	png         []byte                      // the image of the latest pass, as a PNG (once encoded)
+	subscribers map[chan previewStatus]bool // the event streams
+}
+
+// NewPreviewServer creates a PreviewServer for the render with the given options, which it changes so that
+// each pass is sent to the preview (after the onPass callback of the options, if any).
+// Call Done once the render has finished.
+func NewPreviewServer(options *ProgressiveOptions) *PreviewServer {
+	p := &PreviewServer{
+		targetSamples: options.targetSamples, timeBudget: options.timeBudget,
+		subscribers: make(map[chan previewStatus]bool),
+	}
+	onPass := options.onPass
+	options.onPass = func(pass ProgressivePass) error {
+		if onPass != nil {
+			if err := onPass(pass); err != nil {
+				return err
+			}
+		}
+		p.setPass(pass)
+		return nil
+	}
+	return p
+}
+
+// set the latest pass, and send its status to the event streams
+func (p *PreviewServer) setPass(pass ProgressivePass) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pass, p.png = pass, nil
+	w, h := pass.Image.Bounds().Dx(), pass.Image.Bounds().Dy()
+	elapsed := pass.Elapsed.Seconds()
+	p.status = &previewStatus{
+		Pass: pass.Pass, SamplesPerPixel: pass.SamplesPerPixel, TargetSamples: p.targetSamples, Width: w, Height: h,
+		Elapsed: elapsed, ETA: p.eta(pass),
+	}
+	if elapsed > 0 {
+		p.status.SamplesPerSec = float64(pass.SamplesPerPixel*w*h) / elapsed
+	}
+	p.publish()
+}
+
+// the estimated time until the render finishes, after a pass (or 0 if it is unknown):
+// the time of a pass is about proportional to its samples
+func (p *PreviewServer) eta(pass ProgressivePass) float64 {
+	eta := 0.0
+	if p.targetSamples > 0 {
+		eta = pass.Elapsed.Seconds() * float64(p.targetSamples-pass.SamplesPerPixel) / float64(pass.SamplesPerPixel)
+	}
+	if p.timeBudget > 0 {
+		remaining := (p.timeBudget - pass.Elapsed).Seconds()
+		if eta == 0 || remaining < eta {
+			eta = remaining
+		}
+	}
+	if eta < 0 {
+		return 0
+	}
+	return eta
+}
+
+// Done marks the render as finished (with the error which stopped it, if any).
+func (p *PreviewServer) Done(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status == nil {
+		p.status = &previewStatus{}
+	}
+	p.status.Done, p.status.ETA = true, 0
+	if err != nil {
+		p.status.Error = err.Error()
+	}
+	p.publish()
+}
+
+// send the latest status to the event streams (replacing any status they have not sent yet)
+// (p.mu must be held)
+func (p *PreviewServer) publish() {
+	for events := range p.subscribers {
+		select {
+		case <-events:
+		default:
+		}
+		events <- *p.status
+	}
+}
+
+// ServeHTTP handles the requests of the page.
+func (p *PreviewServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(previewPage))
+	case "/events":
+		p.serveEvents(w, req)
+	case "/image.png":
+		p.serveImage(w, req)
+	case "/pixel":
+		p.servePixel(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (p *PreviewServer) serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// (starting with the latest status)
+	events := make(chan previewStatus, 1)
+	p.mu.Lock()
+	p.subscribers[events] = true
+	if p.status != nil {
+		events <- *p.status
+	}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.subscribers, events)
+		p.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case status := <-events:
+			data, _ := json.Marshal(status)
+			event := "pass"
+			if status.Done {
+				event = "done"
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+			if status.Done {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (p *PreviewServer) serveImage(w http.ResponseWriter, req *http.Request) {
+	// (the image is encoded outside the lock, so as not to hold up the render)
+	p.mu.Lock()
+	img, data := p.pass.Image, p.png
+	p.mu.Unlock()
+	if data == nil && img != nil {
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		data = buf.Bytes()
+		p.mu.Lock()
+		if p.pass.Image == img { // (unless a later pass arrived meanwhile)
+			p.png = data
+		}
+		p.mu.Unlock()
+	}
+
+	if data == nil {
+		http.Error(w, "no pass has finished yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+func (p *PreviewServer) servePixel(w http.ResponseWriter, req *http.Request) {
+	x, errX := strconv.Atoi(req.URL.Query().Get("x"))
+	y, errY := strconv.Atoi(req.URL.Query().Get("y"))
+	p.mu.Lock()
+	pass := p.pass
+	p.mu.Unlock()
+
+	if pass.Image == nil {
+		http.Error(w, "no pass has finished yet", http.StatusNotFound)
+		return
+	}
+	width, height := pass.Image.Bounds().Dx(), pass.Image.Bounds().Dy()
+	if errX != nil || errY != nil || x < 0 || y < 0 || x >= width || y >= height {
+		http.Error(w, "the pixel is outside the image", http.StatusBadRequest)
+		return
+	}
+	color, samples := pass.Pixels[y*width+x], pass.SamplesPerPixel
+	if len(pass.SampleCounts) == width*height {
+		samples = pass.SampleCounts[y*width+x] // (the pixels may have different numbers of samples)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		X, Y    int
+		Color   [V3LEN]float64
+		Samples int
+	}{x, y, [V3LEN]float64{float64(color[cX]), float64(color[cY]), float64(color[cZ])}, samples})
+}
+
+// the page of the preview
+const previewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Render preview</title>
+<style>
+	body { font-family: sans-serif; background: #222; color: #ddd; margin: 1em; }
+	#image { image-rendering: pixelated; cursor: crosshair; max-width: 100%; border: 1px solid #555; }
+	#status, #pixel { font-family: monospace; margin: 0.5em 0; white-space: pre; }
+	.swatch { display: inline-block; width: 1em; height: 1em; vertical-align: middle; border: 1px solid #888; }
+</style>
+</head>
+<body>
+<div id="status">Waiting for the first pass...</div>
+<img id="image" alt="">
+<div id="pixel">Click the image to inspect a pixel.</div>
+<script>
+	const image = document.getElementById("image");
+	const statusText = document.getElementById("status");
+	const pixelText = document.getElementById("pixel");
+	let selected = null;
+
+	function seconds(s) {
+		return s < 60 ? s.toFixed(1) + "s" : Math.floor(s / 60) + "m" + Math.round(s % 60) + "s";
+	}
+
+	function show(status) {
+		if (status.Pass > 0) {
+			const target = status.TargetSamples ? " / " + status.TargetSamples : "";
+			statusText.textContent = "pass " + status.Pass + ": " + status.SamplesPerPixel + target + " samples per pixel, " +
+				status.Width + "x" + status.Height + ", " + seconds(status.Elapsed) + " elapsed, " +
+				Math.round(status.SamplesPerSec).toLocaleString() + " samples/s" +
+				(status.Done ? ", done" : status.ETA ? ", about " + seconds(status.ETA) + " left" : "") +
+				(status.Error ? " (" + status.Error + ")" : "");
+			image.src = "/image.png?pass=" + status.Pass;
+			if (selected) inspect(selected.x, selected.y);
+		} else if (status.Done) {
+			statusText.textContent = "done" + (status.Error ? " (" + status.Error + ")" : "");
+		}
+	}
+
+	function inspect(x, y) {
+		selected = {x: x, y: y};
+		fetch("/pixel?x=" + x + "&y=" + y).then(r => r.ok ? r.json() : null).then(p => {
+			if (!p) return;
+			const c = p.Color.map(v => v.toFixed(4)).join(", ");
+			const rgb = p.Color.map(v => Math.round(255 * Math.min(Math.max(v, 0), 1))).join(",");
+			pixelText.innerHTML = "";
+			const swatch = document.createElement("span");
+			swatch.className = "swatch";
+			swatch.style.background = "rgb(" + rgb + ")";
+			pixelText.appendChild(swatch);
+			pixelText.appendChild(document.createTextNode(" pixel (" + p.X + ", " + p.Y + "): " + c + ", " + p.Samples + " samples"));
+		});
+	}
+
+	image.addEventListener("click", e => {
+		const x = Math.floor(e.offsetX * image.naturalWidth / image.clientWidth);
+		const y = Math.floor(e.offsetY * image.naturalHeight / image.clientHeight);
+		inspect(x, y);
+	});
+
+	const events = new EventSource("/events");
+	events.addEventListener("pass", e => show(JSON.parse(e.data)));
+	events.addEventListener("done", e => { show(JSON.parse(e.data)); events.close(); });
+</script>
+</body>
+</html>
+`
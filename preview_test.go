@@ -0,0 +1,182 @@
+// contains tests for preview.go
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// read the events of a stream (as their names, and statuses) until the "done" event
+func readPreviewEvents(t *testing.T, url string) ([]string, []previewStatus) {
+	res, err := http.Get(url + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assertEquals(t, "text/event-stream", res.Header.Get("Content-Type"), "Preview: content type of the events")
+
+	var names []string
+	var statuses []previewStatus
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			names = append(names, strings.TrimPrefix(line, "event: "))
+		case strings.HasPrefix(line, "data: "):
+			var status previewStatus
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &status)
+			statuses = append(statuses, status)
+		}
+	}
+	return names, statuses
+}
+
+// the passes of a render are streamed to the page as they finish
+func TestPreviewEvents(t *testing.T) {
+	scene, lights := benchmarkScene()
+	options := &ProgressiveOptions{targetSamples: 8}
+	preview := NewPreviewServer(options)
+	server := httptest.NewServer(preview)
+	defer server.Close()
+
+	// (the stream starts before the render)
+	type result struct {
+		names    []string
+		statuses []previewStatus
+	}
+	results := make(chan result)
+	go func() {
+		names, statuses := readPreviewEvents(t, server.URL)
+		results <- result{names, statuses}
+	}()
+	for {
+		preview.mu.Lock()
+		n := len(preview.subscribers)
+		preview.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := progressiveRayTracer(1).DrawProgressive(context.Background(), scene, lights, options)
+	preview.Done(err)
+	res := <-results
+
+	// (passes may be skipped by a slow stream, but the last event is the end of the render)
+	n := len(res.names)
+	if !assert(t, n >= 2 && len(res.statuses) == n, "Preview: expected some passes, and then done") {
+		return
+	}
+	assertEquals(t, "done", res.names[n-1], "Preview: the last event")
+	for i := 0; i < n-1; i++ {
+		assertEquals(t, "pass", res.names[i], "Preview: event")
+		assert(t, i == 0 || res.statuses[i].Pass > res.statuses[i-1].Pass, "Preview: the passes are in order")
+	}
+	last := res.statuses[n-1]
+	assert(t, last.Done && last.Error == "", "Preview: the render is done")
+	assertEquals(t, 4, last.Pass, "Preview: the last pass")
+	assertEquals(t, 8, last.SamplesPerPixel, "Preview: samples per pixel")
+	assertEquals(t, 8, last.TargetSamples, "Preview: target samples")
+	assertEquals(t, 16, last.Width, "Preview: width")
+
+	// a stream which starts after the render is only told that it is done
+	names, _ := readPreviewEvents(t, server.URL)
+	assertEquals(t, "[done]", fmt.Sprint(names), "Preview: events after the render")
+}
+
+// the page, the image and the pixels of the latest pass
+func TestPreviewImage(t *testing.T) {
+	scene, lights := benchmarkScene()
+	var final ProgressivePass
+	options := &ProgressiveOptions{targetSamples: 4, onPass: func(pass ProgressivePass) error {
+		final = pass
+		return nil
+	}}
+	preview := NewPreviewServer(options)
+	server := httptest.NewServer(preview)
+	defer server.Close()
+
+	res, _ := http.Get(server.URL + "/image.png")
+	res.Body.Close()
+	assertEquals(t, http.StatusNotFound, res.StatusCode, "Preview: image before the first pass")
+
+	img, err := progressiveRayTracer(1).DrawProgressive(context.Background(), scene, lights, options)
+	preview.Done(err)
+	assertEquals(t, 4, final.SamplesPerPixel, "Preview: the onPass callback of the options is still called")
+
+	res, _ = http.Get(server.URL + "/")
+	res.Body.Close()
+	assertEquals(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"), "Preview: content type of the page")
+
+	res, err = http.Get(server.URL + "/image.png")
+	if assert(t, err == nil && res.StatusCode == http.StatusOK, "Preview: get the image") {
+		act, err := png.Decode(res.Body)
+		res.Body.Close()
+		if assert(t, err == nil, "Preview: decode the image") {
+			exp := NewOutputImage(16, 12)
+			for y := 0; y < 12; y++ {
+				for x := 0; x < 16; x++ {
+					exp.Set(x, y, act.At(x, y))
+				}
+			}
+			assert(t, string(img.Pix) == string(exp.Pix), "Preview: the image of the last pass")
+		}
+	}
+
+	res, err = http.Get(server.URL + "/pixel?x=5&y=7")
+	if assert(t, err == nil && res.StatusCode == http.StatusOK, "Preview: get a pixel") {
+		var pixel struct {
+			X, Y    int
+			Color   [V3LEN]float64
+			Samples int
+		}
+		json.NewDecoder(res.Body).Decode(&pixel)
+		res.Body.Close()
+		exp := final.Pixels[7*16+5]
+		assertVec3Equals(t, exp, Vec3{entry(pixel.Color[cX]), entry(pixel.Color[cY]), entry(pixel.Color[cZ])}, "Preview: the colour of the pixel")
+		assertEquals(t, 4, pixel.Samples, "Preview: the samples of the pixel")
+	}
+	for _, query := range []string{"x=16&y=0", "x=-1&y=0", "x=a&y=0", ""} {
+		res, _ = http.Get(server.URL + "/pixel?" + query)
+		res.Body.Close()
+		assertEquals(t, http.StatusBadRequest, res.StatusCode, "Preview: get the pixel "+query)
+	}
+}
+
+// the samples of a pixel are its own (which differ between pixels with adaptive sampling)
+func TestPreviewPixelSamples(t *testing.T) {
+	preview := NewPreviewServer(&ProgressiveOptions{})
+	server := httptest.NewServer(preview)
+	defer server.Close()
+	preview.setPass(ProgressivePass{Pass: 1, SamplesPerPixel: 4, Image: NewOutputImage(2, 1), Pixels: make([]Vec3, 2), SampleCounts: []int{4, 16}})
+
+	for x, exp := range []int{4, 16} {
+		res, err := http.Get(fmt.Sprintf("%s/pixel?x=%d&y=0", server.URL, x))
+		if !assert(t, err == nil && res.StatusCode == http.StatusOK, "Preview: get the pixel") {
+			return
+		}
+		var pixel struct{ Samples int }
+		json.NewDecoder(res.Body).Decode(&pixel)
+		res.Body.Close()
+		assertEquals(t, exp, pixel.Samples, fmt.Sprint("Preview: the samples of pixel ", x))
+	}
+}
+
+func TestPreviewETA(t *testing.T) {
+	pass := ProgressivePass{Pass: 2, SamplesPerPixel: 4, Elapsed: 2 * time.Second}
+	assertEquals(t, 6.0, (&PreviewServer{targetSamples: 16}).eta(pass), "Preview: ETA from the target")
+	assertEquals(t, 3.0, (&PreviewServer{targetSamples: 16, timeBudget: 5 * time.Second}).eta(pass), "Preview: ETA from the time budget")
+	assertEquals(t, 0.0, (&PreviewServer{}).eta(pass), "Preview: ETA without a target")
+	assertEquals(t, 0.0, (&PreviewServer{timeBudget: time.Second}).eta(pass), "Preview: ETA after the time budget")
+}
@@ -0,0 +1,158 @@
+// progressive.go: Contains progressive rendering, which refines the image over passes of increasing sample counts.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"time"
+)
+
+// ProgressivePass describes the image after a pass of progressive rendering.
+type ProgressivePass struct {
+	Pass            int           // the number of the pass (from 1)
+	SamplesPerPixel int           // the total number of samples of each pixel, after the pass
+	Elapsed         time.Duration // the time since rendering started
+	Image           *image.RGBA   // the image, from all the samples so far
+	Pixels          []Vec3        // the colours of the pixels of the image (as floats), in rows from the top-left
+	SampleCounts    []int         // the number of samples of each pixel, in rows from the top-left
+}
+
+// the options controlling progressive rendering
+type ProgressiveOptions struct {
+	targetSamples int                              // stop once each pixel has this many samples (0 for no limit)
+	timeBudget    time.Duration                    // stop after this long, even within a pass (0 for no limit)
+	onPass        func(pass ProgressivePass) error // called after each pass (nil for none); an error stops rendering
+
+	checkpointPath     string        // if not empty, the file to save the progress to (when rendering stops, and at intervals)
+	checkpointInterval time.Duration // the time between saving checkpoints (0 for only when rendering stops)
+}
+
+// SnapshotWriter returns a callback (for ProgressiveOptions) which saves the image of each pass as a PNG file,
+// named by formatting pattern with the number of the pass (e.g. "preview-%03d.png"), or overwriting
+// the same file if pattern has no verb (e.g. "preview.png").
+func SnapshotWriter(pattern string) func(pass ProgressivePass) error {
+	return func(pass ProgressivePass) error {
+		path := pattern
+		if containsVerb(pattern) {
+			path = fmt.Sprintf(pattern, pass.Pass)
+		}
+		return saveImg(path, pass.Image)
+	}
+}
+
+// check if a format string has a verb (other than the literal %%)
+func containsVerb(format string) bool {
+	for i := 0; i < len(format)-1; i++ {
+		if format[i] == '%' {
+			if format[i+1] != '%' {
+				return true
+			}
+			i++
+		}
+	}
+	return false
+}
+
+// DrawProgressive renders the scene in full-frame passes into an accumulation buffer: the first pass has one sample
+// per pixel, and each later pass doubles the samples of each pixel (so the image refines quickly at first).
+// It stops once the target number of samples is reached, or the time budget runs out, or ctx is cancelled
+// (returning the image of all the samples so far, including any part of a pass, along with ctx.Err() if ctx was
+// cancelled, but no error when the time budget ran out), or when onPass fails.
+// (adaptive sampling does not apply: every pixel has the same number of samples)
+// With a checkpointPath, the progress is saved at intervals (and when it stops), to be resumed by ResumeProgressive.
+func (r *RayTracer) DrawProgressive(ctx context.Context, scene []Shape, lights []Light, options *ProgressiveOptions) (*image.RGBA, error) {
+	c := &checkpoint{
+		Version: checkpointVersion, Width: r.width, Height: r.height, Sampler: fmt.Sprintf("%T", r.sampler), Pass: 1,
+		Colors: make([]Vec3, r.width*r.height), Weights: make([]entry, r.width*r.height), SampleCounts: make([]int, r.width*r.height),
+	}
+	if options.checkpointPath != "" {
+		c.SceneHash = r.SceneHash(scene, lights)
+	}
+	return r.drawProgressive(ctx, scene, lights, options, c)
+}
+
+// ResumeProgressive continues a progressive render (as DrawProgressive) from the checkpoint at the checkpointPath
+// of the options, exactly where it stopped. It fails (with ErrSceneChanged) if the scene, camera or options of the
+// ray tracer are not the same as when the checkpoint was saved.
+func (r *RayTracer) ResumeProgressive(ctx context.Context, scene []Shape, lights []Light, options *ProgressiveOptions) (*image.RGBA, error) {
+	c, err := loadCheckpoint(options.checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.SceneHash != r.SceneHash(scene, lights) || c.Width != r.width || c.Height != r.height || c.Sampler != fmt.Sprintf("%T", r.sampler) {
+		return nil, ErrSceneChanged
+	}
+	return r.drawProgressive(ctx, scene, lights, options, c)
+}
+
+// render the passes of a progressive render, continuing from the progress in the checkpoint c
+func (r *RayTracer) drawProgressive(ctx context.Context, scene []Shape, lights []Light, options *ProgressiveOptions, c *checkpoint) (*image.RGBA, error) {
+	start := time.Now()
+	renderCtx := ctx // (which is ctx, unless the time budget limits it)
+	if options.timeBudget > 0 {
+		var cancel context.CancelFunc
+		renderCtx, cancel = context.WithTimeout(ctx, options.timeBudget)
+		defer cancel()
+	}
+
+	// save the progress (if there is a checkpoint file)
+	lastSaved := start
+	save := func() error {
+		if options.checkpointPath == "" {
+			return nil
+		}
+		lastSaved = time.Now()
+		return c.save(options.checkpointPath)
+	}
+
+	rt := r.forRender()
+	fb := &Framebuffer{0, 0, r.width, r.height, c.Colors, c.Weights}
+	for ; options.targetSamples <= 0 || c.Total < options.targetSamples; c.Pass++ {
+
+		// the samples of this pass: as many as all the previous passes (and at least one), but no more than the target
+		if c.Row == 0 {
+			c.PassSamples = maxInt(c.Total, 1)
+			if options.targetSamples > 0 {
+				c.PassSamples = minInt(c.PassSamples, options.targetSamples-c.Total)
+			}
+		}
+
+		// iterate through the image (checking for cancellation, and saving at intervals, after each row)
+		for ; c.Row < r.height; c.Row++ {
+			if renderCtx.Err() != nil {
+				// (running out of time budget is not an error, but failing to save the progress is)
+				if err := save(); err != nil {
+					return fb.Image(), err
+				}
+				return fb.Image(), ctx.Err()
+			}
+			if options.checkpointInterval > 0 && time.Since(lastSaved) >= options.checkpointInterval {
+				if err := save(); err != nil {
+					return fb.Image(), err
+				}
+			}
+
+			y := c.Row
+			for x := 0; x < r.width; x++ {
+				// (the samples of each pass are stratified by the sampler, continuing from those of the previous passes)
+				rt.sampler.StartPixel(x, y, c.PassSamples)
+				for i := c.Total; i < c.Total+c.PassSamples; i++ {
+					rt.traceSample(fb, scene, lights, x, y, i)
+				}
+				c.SampleCounts[y*r.width+x] += c.PassSamples
+			}
+		}
+		c.Total += c.PassSamples
+		c.Row = 0
+
+		if options.onPass != nil {
+			counts := append([]int(nil), c.SampleCounts...)
+			if err := options.onPass(ProgressivePass{c.Pass, c.Total, time.Since(start), fb.Image(), fb.Pixels(), counts}); err != nil {
+				return fb.Image(), err
+			}
+		}
+	}
+	return fb.Image(), save()
+}
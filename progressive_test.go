@@ -0,0 +1,108 @@
+// contains tests for progressive.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a small ray tracer (with a deterministic sampler), for progressive rendering
+func progressiveRayTracer(samplingFactor int) *RayTracer {
+	view := &Camera{Vec3{0, 0, 6}, ZERO_V3, Y_V3, 16, 12, entry(60)}
+	return NewRayTracer(view, &RayTracerOptions{1, samplingFactor, 1, 1, ZERO, NewSobolSampler(3), nil, 0, ZERO})
+}
+
+// the passes double the samples of each pixel, up to the target
+func TestProgressivePasses(t *testing.T) {
+	scene, lights := benchmarkScene()
+	r := progressiveRayTracer(1)
+	var samples []int
+	options := &ProgressiveOptions{targetSamples: 6, onPass: func(pass ProgressivePass) error {
+		assertEquals(t, len(samples)+1, pass.Pass, "Progressive: pass number")
+		assert(t, pass.Image.Bounds().Dx() == 16 && pass.Image.Bounds().Dy() == 12, "Progressive: image size")
+		assertEquals(t, 16*12, len(pass.SampleCounts), "Progressive: sample counts")
+		for _, c := range pass.SampleCounts {
+			assertEquals(t, pass.SamplesPerPixel, c, "Progressive: samples of each pixel")
+		}
+		samples = append(samples, pass.SamplesPerPixel)
+		return nil
+	}}
+	_, err := r.DrawProgressive(context.Background(), scene, lights, options)
+	assert(t, err == nil, fmt.Sprint("Progressive: error ", err))
+	assertEquals(t, fmt.Sprint([]int{1, 2, 4, 6}), fmt.Sprint(samples), "Progressive: samples after each pass")
+}
+
+// progressive rendering of n samples gives the same image as drawing n samples at once
+func TestProgressiveMatchesDraw(t *testing.T) {
+	scene, lights := benchmarkScene()
+	exp := progressiveRayTracer(2).Draw(scene, lights)
+	act, _ := progressiveRayTracer(1).DrawProgressive(context.Background(), scene, lights, &ProgressiveOptions{targetSamples: 4})
+	assert(t, string(exp.Pix) == string(act.Pix), "Progressive: different image from Draw")
+}
+
+// rendering (without a target) stops when the context is cancelled, or the time budget runs out
+func TestProgressiveStops(t *testing.T) {
+	scene, lights := benchmarkScene()
+	r := progressiveRayTracer(1)
+
+	// cancelled after the second pass
+	ctx, cancel := context.WithCancel(context.Background())
+	passes := 0
+	_, err := r.DrawProgressive(ctx, scene, lights, &ProgressiveOptions{onPass: func(pass ProgressivePass) error {
+		if passes++; passes == 2 {
+			cancel()
+		}
+		return nil
+	}})
+	assert(t, err == context.Canceled && passes == 2, fmt.Sprint("Progressive: cancelled after ", passes, " passes, with error ", err))
+
+	// failing to save the progress when cancelled
+	path := filepath.Join(t.TempDir(), "missing", "render.checkpoint")
+	_, err = r.DrawProgressive(ctx, scene, lights, &ProgressiveOptions{checkpointPath: path})
+	assert(t, err != nil && err != context.Canceled, fmt.Sprint("Progressive: cancelled without saving, with error ", err))
+
+	// a time budget
+	start := time.Now()
+	img, err := r.DrawProgressive(context.Background(), scene, lights, &ProgressiveOptions{timeBudget: 50 * time.Millisecond})
+	elapsed := time.Since(start)
+	assert(t, err == nil && img != nil, fmt.Sprint("Progressive: time budget error ", err))
+	assert(t, elapsed < 2*time.Second, fmt.Sprint("Progressive: took ", elapsed, " for a budget of 50ms"))
+
+	// an error from the callback
+	fail := errors.New("disk full")
+	_, err = r.DrawProgressive(context.Background(), scene, lights, &ProgressiveOptions{onPass: func(pass ProgressivePass) error {
+		return fail
+	}})
+	assert(t, err == fail, fmt.Sprint("Progressive: callback error ", err))
+}
+
+func TestSnapshotWriter(t *testing.T) {
+	scene, lights := benchmarkScene()
+	dir := t.TempDir()
+	r := progressiveRayTracer(1)
+
+	options := &ProgressiveOptions{targetSamples: 4, onPass: SnapshotWriter(filepath.Join(dir, "pass-%02d.png"))}
+	_, err := r.DrawProgressive(context.Background(), scene, lights, options)
+	assert(t, err == nil, fmt.Sprint("Snapshots: error ", err))
+	for _, name := range []string{"pass-01.png", "pass-02.png", "pass-03.png"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert(t, err == nil, "Snapshots: missing "+name)
+	}
+
+	// without a verb, the same file is overwritten
+	options.onPass = SnapshotWriter(filepath.Join(dir, "100%%-preview.png"))
+	r.DrawProgressive(context.Background(), scene, lights, options)
+	_, err = os.Stat(filepath.Join(dir, "100%%-preview.png"))
+	assert(t, err == nil, "Snapshots: missing the preview")
+}
+
+func TestContainsVerb(t *testing.T) {
+	assert(t, containsVerb("a-%d.png") && containsVerb("%%-%03d"), "Verbs: expected a verb")
+	assert(t, !containsVerb("a.png") && !containsVerb("100%%.png") && !containsVerb("a%"), "Verbs: expected no verb")
+}
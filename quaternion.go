@@ -0,0 +1,138 @@
+// quaternion.go: Contains quaternions, which represent rotations.
+// As elsewhere, angles are in degrees.
+
+package main
+
+import "math"
+
+// A Quaternion (x,y,z,w) is w + xi + yj + zk. Rotations are unit quaternions.
+type Quaternion [V4LEN]entry
+
+// the rotation which does nothing
+var IDENTITY_Q = Quaternion{0, 0, 0, 1}
+
+// NewQuaternion creates the rotation by angle about axis.
+func NewQuaternion(axis Vec3, angle entry) Quaternion {
+	axis = axis.direction()
+	s, c := sin(angle/TWO), cos(angle/TWO)
+	return Quaternion{axis[cX] * s, axis[cY] * s, axis[cZ] * s, c}
+}
+
+// QuaternionFromEuler creates the rotation by x about the X axis, then by y about the Y axis,
+// then by z about the Z axis (i.e. the "XYZ" Euler angles of most modelling tools).
+func QuaternionFromEuler(x, y, z entry) Quaternion {
+	return NewQuaternion(Z_V3, z).times(NewQuaternion(Y_V3, y)).times(NewQuaternion(X_V3, x))
+}
+
+// QuaternionLookAt creates the rotation which turns -Z towards forward, and +Y towards up
+// (as for cameras: up only has to be somewhere above forward, rather than perpendicular to it).
+func QuaternionLookAt(forward, up Vec3) Quaternion {
+	back := forward.scale(-ONE).direction()
+	right := up.cross(back).direction()
+	trueUp := back.cross(right)
+
+	// the columns of the rotation matrix are the rotated axes:
+	return quaternionFromMat3(Mat3{
+		right[cX], trueUp[cX], back[cX],
+		right[cY], trueUp[cY], back[cY],
+		right[cZ], trueUp[cZ], back[cZ],
+	})
+}
+
+// quaternionFromMat3 converts a rotation matrix into a quaternion.
+func quaternionFromMat3(m Mat3) Quaternion {
+	// find the largest of 4w^2, 4x^2, 4y^2, 4z^2 (from the trace and diagonal), to divide by:
+	var q Quaternion
+	switch trace := m[0] + m[4] + m[8]; {
+	case trace > 0:
+		s := TWO * sqrt(ONE+trace) // 4w
+		q = Quaternion{(m[7] - m[5]) / s, (m[2] - m[6]) / s, (m[3] - m[1]) / s, s / FOUR}
+	case m[0] > m[4] && m[0] > m[8]:
+		s := TWO * sqrt(ONE+m[0]-m[4]-m[8]) // 4x
+		q = Quaternion{s / FOUR, (m[1] + m[3]) / s, (m[2] + m[6]) / s, (m[7] - m[5]) / s}
+	case m[4] > m[8]:
+		s := TWO * sqrt(ONE+m[4]-m[0]-m[8]) // 4y
+		q = Quaternion{(m[1] + m[3]) / s, s / FOUR, (m[5] + m[7]) / s, (m[2] - m[6]) / s}
+	default:
+		s := TWO * sqrt(ONE+m[8]-m[0]-m[4]) // 4z
+		q = Quaternion{(m[2] + m[6]) / s, (m[5] + m[7]) / s, s / FOUR, (m[3] - m[1]) / s}
+	}
+	return q.normalized()
+}
+
+// the product q*r: the rotation r, followed by q
+func (q Quaternion) times(r Quaternion) Quaternion {
+	return Quaternion{
+		q[cW]*r[cX] + q[cX]*r[cW] + q[cY]*r[cZ] - q[cZ]*r[cY],
+		q[cW]*r[cY] - q[cX]*r[cZ] + q[cY]*r[cW] + q[cZ]*r[cX],
+		q[cW]*r[cZ] + q[cX]*r[cY] - q[cY]*r[cX] + q[cZ]*r[cW],
+		q[cW]*r[cW] - q[cX]*r[cX] - q[cY]*r[cY] - q[cZ]*r[cZ],
+	}
+}
+
+// the conjugate, which is the inverse rotation (of a unit quaternion)
+func (q Quaternion) conjugate() Quaternion {
+	return Quaternion{-q[cX], -q[cY], -q[cZ], q[cW]}
+}
+
+func (q Quaternion) dot(r Quaternion) entry {
+	return Vec4(q).dot(Vec4(r))
+}
+
+// the unit quaternion in the same direction
+func (q Quaternion) normalized() Quaternion {
+	return Quaternion(Vec4(q).direction())
+}
+
+// rotate a vector (by a unit quaternion)
+func (q Quaternion) rotateVec(v Vec3) Vec3 {
+	// v + 2w(u x v) + 2u x (u x v), where u = (x,y,z):
+	u := Vec3{q[cX], q[cY], q[cZ]}
+	t := u.cross(v).scale(TWO)
+	return v.plus(t.scale(q[cW])).plus(u.cross(t))
+}
+
+// the rotation matrix of a unit quaternion
+func (q Quaternion) toMat3() Mat3 {
+	x, y, z, w := q[cX], q[cY], q[cZ], q[cW]
+	return Mat3{
+		1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w),
+		2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w),
+		2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y),
+	}
+}
+
+// toEuler returns the "XYZ" Euler angles of the rotation (the inverse of QuaternionFromEuler).
+// y is in [-90, 90]; when it is at either end, the X and Z axes coincide, so z is zero.
+func (q Quaternion) toEuler() (x, y, z entry) {
+	// the matrix is Rz * Ry * Rx, whose first column is (cos(y)cos(z), cos(y)sin(z), -sin(y)):
+	m := q.toMat3()
+	cosY := sqrt(m[0]*m[0] + m[3]*m[3])
+	y = degrees(math.Atan2(float64(-m[6]), float64(cosY)))
+	if cosY > 1e-6 {
+		x = degrees(math.Atan2(float64(m[7]), float64(m[8])))
+		z = degrees(math.Atan2(float64(m[3]), float64(m[0])))
+	} else {
+		x = degrees(math.Atan2(float64(-m[5]), float64(m[4])))
+	}
+	return
+}
+
+// slerp interpolates (spherically, at a constant angular speed) from q (at t=0) to r (at t=1),
+// along the shorter way around.
+func slerp(q, r Quaternion, t entry) Quaternion {
+	cosTheta := q.dot(r)
+	if cosTheta < 0 {
+		r, cosTheta = Quaternion(Vec4(r).scale(-ONE)), -cosTheta
+	}
+
+	// for nearly equal rotations, interpolate linearly (avoiding the division by sin(theta)):
+	if cosTheta > 0.9995 {
+		return Quaternion(Vec4(q).scale(ONE - t).plus(Vec4(r).scale(t))).normalized()
+	}
+	theta := math.Acos(float64(cosTheta))
+	sinTheta := math.Sin(theta)
+	a := entry(math.Sin((1-float64(t))*theta) / sinTheta)
+	b := entry(math.Sin(float64(t)*theta) / sinTheta)
+	return Quaternion(Vec4(q).scale(a).plus(Vec4(r).scale(b)))
+}
@@ -0,0 +1,118 @@
+// contains tests for quaternion.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// quaternions q and -q are the same rotation
+func isQuaternionEqual(q, r Quaternion) bool {
+	return isQuaternionEqualTol(q, r, TOLERANCE)
+}
+
+func isQuaternionEqualTol(q, r Quaternion, tol float64) bool {
+	neg := Vec4(r).scale(-ONE)
+	return isMatEqualTol(q[:], r[:], V4LEN, tol) || isMatEqualTol(q[:], neg[:], V4LEN, tol)
+}
+
+func assertVec3Equals(t *testing.T, exp, act Vec3, msg string) bool {
+	return assertVec3EqualsTol(t, exp, act, TOLERANCE, msg)
+}
+
+func assertVec3EqualsTol(t *testing.T, exp, act Vec3, tol float64, msg string) bool {
+	return assert(t, isMatEqualTol(exp[:], act[:], V3LEN, tol), msg+fmt.Sprint(":\n\t\tExp: ", exp, "\n\t\tAct: ", act))
+}
+
+// some rotations to test, including half-turns (which exercise each branch of quaternionFromMat3)
+var testRotations = []struct {
+	axis  Vec3
+	angle entry
+}{
+	{X_V3, 30}, {Y_V3, -75}, {Z_V3, 90}, {Vec3{1, 2, 3}, 123}, {Vec3{-2, 1, 0.5}, 250},
+	{X_V3, 180}, {Y_V3, 180}, {Z_V3, 180}, {Vec3{1, 1, 0}, 180}, {Vec3{0, 0, 1}, 0},
+}
+
+func TestQuaternionRotation(t *testing.T) {
+	// (the rotated vectors are up to ~100 long)
+	tol := ROUNDING_TOLERANCE
+	assertVec3EqualsTol(t, Y_V3, NewQuaternion(Z_V3, 90).rotateVec(X_V3), tol, "Quaternion: X rotated about Z")
+	assertVec3EqualsTol(t, Z_V3, NewQuaternion(X_V3, 90).rotateVec(Y_V3), tol, "Quaternion: Y rotated about X")
+	assertVec3EqualsTol(t, v31, IDENTITY_Q.rotateVec(v31), tol, "Quaternion: identity")
+
+	for i, r := range testRotations {
+		q := NewQuaternion(r.axis, r.angle)
+		msg := fmt.Sprint("Quaternion ", i)
+
+		// the rotation matrix should match Rodrigues' formula:
+		m := q.toMat3()
+		assertM3EqualsTol(t, rotate(r.axis.direction(), r.angle), m, tol, msg+": matrix")
+		assertVec3EqualsTol(t, m.timesVec(v31), q.rotateVec(v31), tol, msg+": rotated vector")
+
+		// the conjugate undoes the rotation:
+		assertVec3EqualsTol(t, v32, q.conjugate().rotateVec(q.rotateVec(v32)), tol, msg+": conjugate")
+
+		// the matrix converts back into the same rotation:
+		assert(t, isQuaternionEqualTol(q, quaternionFromMat3(m), tol), msg+fmt.Sprint(": from matrix ", quaternionFromMat3(m)))
+	}
+}
+
+func TestQuaternionComposition(t *testing.T) {
+	q, r := NewQuaternion(Vec3{1, 2, 3}, 40), NewQuaternion(Vec3{-1, 0, 2}, 110)
+	assertVec3Equals(t, q.rotateVec(r.rotateVec(v31)), q.times(r).rotateVec(v31), "Quaternion composition q*r")
+	assertVec3Equals(t, r.rotateVec(q.rotateVec(v31)), r.times(q).rotateVec(v31), "Quaternion composition r*q")
+}
+
+func TestQuaternionEuler(t *testing.T) {
+	// each angle rotates about its own axis, in the order x, y, z:
+	assertVec3Equals(t, Z_V3, QuaternionFromEuler(90, 0, 0).rotateVec(Y_V3), "Euler: x")
+	assertVec3Equals(t, Z_V3.scale(-ONE), QuaternionFromEuler(0, 90, 0).rotateVec(X_V3), "Euler: y")
+	assertVec3Equals(t, Y_V3, QuaternionFromEuler(0, 0, 90).rotateVec(X_V3), "Euler: z")
+	assertVec3Equals(t, X_V3, QuaternionFromEuler(90, 0, 90).rotateVec(Z_V3), "Euler: x then z")
+
+	for _, angles := range []Vec3{{10, 20, 30}, {-45, 80, 170}, {120, -30, -100}, {0, 0, 0}, {35, 90, 0}, {-60, -90, 0}} {
+		q := QuaternionFromEuler(angles[cX], angles[cY], angles[cZ])
+		x, y, z := q.toEuler()
+		assertVec3Equals(t, angles, Vec3{x, y, z}, fmt.Sprint("Euler angles ", angles))
+	}
+
+	// at y=90, the x and z rotations coincide, so the angles are not unique (but the rotation is):
+	q := QuaternionFromEuler(20, 90, 30)
+	x, y, z := q.toEuler()
+	assert(t, isQuaternionEqual(q, QuaternionFromEuler(x, y, z)), fmt.Sprint("Euler gimbal lock: ", x, y, z))
+}
+
+func TestQuaternionSlerp(t *testing.T) {
+	axis := Vec3{1, -1, 2}.direction()
+	q, r := NewQuaternion(axis, 20), NewQuaternion(axis, 140)
+	assert(t, isQuaternionEqual(q, slerp(q, r, 0)), "Slerp: t=0")
+	assert(t, isQuaternionEqual(r, slerp(q, r, 1)), "Slerp: t=1")
+
+	// the angle changes at a constant rate:
+	for _, u := range []entry{0.25, 0.5, 0.9} {
+		exp := NewQuaternion(axis, 20+120*u)
+		assert(t, isQuaternionEqual(exp, slerp(q, r, u)), fmt.Sprint("Slerp: t=", u))
+	}
+
+	// the shorter way around: from 350 degrees to 10 degrees passes through 0 (rather than 180)
+	q, r = NewQuaternion(Z_V3, 350), NewQuaternion(Z_V3, 10)
+	assertVec3Equals(t, X_V3, slerp(q, r, 0.5).rotateVec(X_V3), "Slerp: shortest path")
+
+	// nearly equal rotations:
+	q, r = NewQuaternion(Z_V3, 10), NewQuaternion(Z_V3, 10.5)
+	assertVec3Equals(t, NewQuaternion(Z_V3, 10.25).rotateVec(X_V3), slerp(q, r, 0.5).rotateVec(X_V3), "Slerp: nearly equal")
+}
+
+func TestQuaternionLookAt(t *testing.T) {
+	for _, forward := range []Vec3{{0, 0, -1}, {1, 0, 0}, {1, -2, 3}, {0.2, 0.1, 1}} {
+		q := QuaternionLookAt(forward, Y_V3)
+		msg := fmt.Sprint("Look at ", forward)
+		assertVec3Equals(t, forward.direction(), q.rotateVec(Z_V3.scale(-ONE)), msg+": forward")
+
+		// up stays in the plane of forward and Y (on the side of Y), and X is level:
+		up, right := q.rotateVec(Y_V3), q.rotateVec(X_V3)
+		assert(t, up.dot(Y_V3) > 0 && !up.dot(forward).neq(ZERO), msg+fmt.Sprint(": up ", up))
+		assert(t, !right[cY].neq(ZERO), msg+fmt.Sprint(": right ", right))
+	}
+}
@@ -3,13 +3,22 @@
 package main
 
 import (
+	"context"
 	"image"
-	"math/rand"
+	"math"
+	"sync/atomic"
+	"time"
 )
 
 // the options controlling the behaviour of the RayTracer
 type RayTracerOptions struct {
 	maxDepth, samplingFactor, numShadowRays int
+	numReflectionRays                       int     // the number of rays sampling glossy reflections of each primary ray hit (at least 1)
+	minThroughput                           entry   // reflections contributing less than this (in every colour) to the pixel are not traced
+	sampler                                 Sampler // generates the random numbers of each sample (nil for an IndependentSampler)
+	filter                                  Filter  // weights the samples near each pixel (nil for a box of radius 0.5: the average of the samples within it)
+	maxSamples                              int     // the most samples of each pixel, for adaptive sampling (at most samplingFactor^2 disables it)
+	maxError                                entry   // adaptive sampling of a pixel stops when the standard error of the luminance of its samples is below this
 }
 
 // The main 'class' which performs the ray tracing
@@ -18,6 +27,10 @@ type RayTracer struct {
 	halfWidth, halfHeight, tanX, tanY entry
 	basisU, basisV, basisW, eyePos    Vec3
 	options                           *RayTracerOptions
+	sampler                           Sampler
+	filter                            Filter
+	progress                          *atomic.Int64 // if not nil, counts the pixels rendered (to report the progress of a render)
+	aovs                              *aovBuffers   // if not nil, the passes of the render (rendered alongside the image)
 }
 
 // create a new ray tracer using the given view-window and options
@@ -29,151 +42,326 @@ func NewRayTracer(view *Camera, options *RayTracerOptions) *RayTracer {
 	tanX := tanY * (halfWidth / halfHeight)
 
 	// compute eye-basis vectors:
-	bW := view.pos.minus(&view.lookAt).direction()
+	bW := view.pos.minus(view.lookAt).direction()
 	bU := view.up.cross(bW).direction()
 	bV := bW.cross(bU)
 
+	sampler := options.sampler
+	if sampler == nil {
+		sampler = NewIndependentSampler(0)
+	}
+	filter := options.filter
+	if filter == nil {
+		filter = NewBoxFilter(0.5)
+	}
+
 	return &RayTracer{
 		view.width, view.height,
 		halfWidth, halfHeight, tanX, tanY,
-		*bU, *bV, *bW, view.pos,
-		options,
+		bU, bV, bW, view.pos,
+		options, sampler, filter, nil, nil,
 	}
 }
 
-// find the closest shape which intersects the ray
-func findClosestIntersection(ray *Ray, scene []Shape) (hit bool, inter *Intersection, closest *Shape) {
+// find the closest shape which intersects the ray within (tMin, tMax).
+// (the shape hit is inter.shape, since groups and instances report the primitive within them which was hit)
+func findClosestIntersection(ray Ray, scene []Shape, tMin, tMax entry) (hit bool, inter Intersection) {
+	hit, inter, _ = findClosestShape(ray, scene, tMin, tMax)
+	return
+}
 
-	// by default: no intersection
-	hit, inter, closest = false, nil, nil
+// find the closest shape which intersects the ray (as findClosestIntersection), along with its index in scene.
+func findClosestShape(ray Ray, scene []Shape, tMin, tMax entry) (hit bool, inter Intersection, index int) {
 
 	// iterate through each shape:
-	for _, shape := range scene {
-		// check if this shape hits the ray at a closer point than previous least.
-		if h, i := shape.Intersect(ray); h && (!hit || i.dist < inter.dist) {
-			hit, inter, closest = true, i, &shape
+	for i, shape := range scene {
+		// check if this shape hits the ray at a closer point than previous least (which then limits the interval).
+		if h, res := shape.Intersect(ray, tMin, tMax); h {
+			hit, inter, tMax, index = true, res, res.dist, i
 		}
 	}
 
 	return
 }
 
+// check if any shape is hit by the ray within (tMin, tMax), stopping at the first one found.
+func isOccluded(ray Ray, scene []Shape, tMin, tMax entry) bool {
+	for _, shape := range scene {
+		if shape.Occluded(ray, tMin, tMax) {
+			return true
+		}
+	}
+	return false
+}
+
 // build the ray travelling from the eye to the i,j point in the image
-func (r *RayTracer) buildRayFromEyeToImage(i, j entry, eye *Vec3) *Ray {
+func (r *RayTracer) buildRayFromEyeToImage(i, j entry, eye Vec3) Ray {
 	// formulas from reference calculations
 	alpha := r.tanX * ((j / r.halfWidth) - ONE)
 	beta := r.tanY * (ONE - (i / r.halfHeight))
-	dir := r.basisU.scale(alpha).plus(r.basisV.scale(beta)).minus(&r.basisW).direction()
-	return &Ray{*eye, *dir}
+	dir := r.basisU.scale(alpha).plus(r.basisV.scale(beta)).minus(r.basisW).direction()
+	return Ray{eye, dir}
+}
+
+// the material at the intersection point: a surface colour (if any) replaces the diffuse (or base) colour,
+// from which the Blinn-Phong colours of a PBR material are derived again (as by NewPBRMaterial).
+func surfaceMaterial(mat *Material, inter *Intersection) *Material {
+	if !inter.hasColor {
+		return mat
+	}
+	res := *mat
+	res.diffuse = inter.color
+	if mat.pbr != nil {
+		pbr := *mat.pbr
+		pbr.baseColor = inter.color
+		res.pbr = &pbr
+		res.diffuse, res.specular = pbr.diffuse(), pbr.f0()
+	}
+	return &res
+}
+
+// build a ray leaving the intersection point in direction dir, which starts beyond the
+// floating-point error of the point (so that it does not hit the same surface again)
+func spawnRay(inter *Intersection, dir Vec3) Ray {
+	return Ray{offsetRayOrigin(inter.point, inter.pError, inter.normal, dir), dir}
 }
 
 // reflect a ray about normal
-func reflect(dir, normal *Vec3) *Vec3 {
+func reflect(dir, normal Vec3) Vec3 {
 	return dir.minus(normal.scale(TWO * normal.dot(dir)))
 }
 
-// generates a small random number in range (-alpha, alpha) where alpha = 0.001
-func smallRand(sc float64) entry {
-	return entry(rand.Float64()-0.5) * entry(sc)
+// sample the direction of a reflected ray from the lobe about the mirror direction, which is blurred by the roughness
+// of the material (given two uniform random numbers in [0,1)). Returns the direction, and the weight of the colour
+// seen along it: zero if the direction is below the surface.
+func sampleReflection(mat *Material, dir, normal Vec3, u1, u2 entry) (Vec3, Vec3) {
+	if mat.pbr != nil {
+		return mat.pbr.SampleReflection(normal, dir.scale(-ONE), u1, u2)
+	}
+
+	mirror := reflect(dir, normal)
+	if mat.roughness <= 0 {
+		return mirror, mat.specular
+	}
+
+	// a Blinn-Phong shininess (about the half-vector) is roughly four times the equivalent Phong exponent:
+	refDir := phongLobe(mirror, maxEntry(roughnessToShininess(mat.roughness)/FOUR, ONE), u1, u2)
+	if refDir.dot(normal)*mirror.dot(normal) <= 0 {
+		return refDir, ZERO_V3
+	}
+	return refDir, mat.specular
 }
 
-func randVec() *Vec3 {
-	return &Vec3{smallRand(0.25), smallRand(0.25), smallRand(0.25)}
+// a random offset to the position of a light (within a cube of width 0.25), for soft shadows
+func (r *RayTracer) lightJitter() Vec3 {
+	u1, u2 := r.sampler.Get2D()
+	u3 := r.sampler.Get1D()
+	return Vec3{u1 - 0.5, u2 - 0.5, u3 - 0.5}.scale(0.25)
 }
 
 // Compute the color of the current ray by tracing it into the scene
-func (r *RayTracer) findColor(ray *Ray, scene []Shape, lights []Light, curDepth int) *Vec3 {
+// (throughput is the fraction of the colour of the ray which reaches the pixel; the passes of a primary ray
+// are added to aov, if it is not nil)
+func (r *RayTracer) findColor(ray Ray, scene []Shape, lights []Light, curDepth int, throughput Vec3, aov *aovSample) Vec3 {
 
 	// check if the ray hits any objects:
-	if hit, inter, closest := findClosestIntersection(ray, scene); hit {
+	if hit, inter, index := findClosestShape(ray, scene, ZERO, INF); hit {
 
 		// apply material of the closest shape
-		material := (*closest).GetMaterial()
-		color := material.ambient.plus(&material.emission)
+		material := surfaceMaterial(inter.shape.GetMaterial(), &inter)
+		color := material.ambient.plus(material.emission)
+		if aov != nil {
+			aov.setHit(&inter, index, material, r.aovs.materialIDs[inter.shape.GetMaterial()])
+		}
+
+		// physically based materials have their own shader:
+		shader := Shader(BlinnPhongShader)
+		if material.pbr != nil {
+			shader = PBRShader
+		}
 
 		// apply each light that is visible from the intersection point
 		for _, light := range lights {
 
 			// find offset to light
-			lightOffset := light.OffsetFrom(&inter.point)
+			lightOffset := light.OffsetFrom(inter.point)
 			distToLight := lightOffset.magnitude()
 
 			// enable soft-shadowing by tracing multiple shadow rays
 			numRays := r.options.numShadowRays
 			rayWeight := ONE / entry(numRays)
 			for j := 0; j < numRays; j++ {
-				shadowRayDir := lightOffset.plus(randVec()).direction()
-				shadowRay := &Ray{
-					*inter.point.plus(shadowRayDir.scale(entry(0.001))), // push ray towards light
-					*shadowRayDir,
-				}
-
-				// check if shadowRay hits any objects in the scene:
-				if h, i, _ := findClosestIntersection(shadowRay, scene); (!h) || i.dist >= distToLight {
-					extraColor := BlinnPhongShader(&light, shadowRayDir, &inter.normal, ray, material, distToLight)
-					color = color.plus(extraColor.scale(rayWeight))
+				shadowRayDir := lightOffset.plus(r.lightJitter()).direction()
+				shadowRay := spawnRay(&inter, shadowRayDir)
+
+				// check if shadowRay hits any objects (before reaching the light):
+				if !isOccluded(shadowRay, scene, ZERO, distToLight) {
+					extraColor := shader(light, shadowRayDir, inter.normal, ray, material, distToLight)
+					color.addScaledInPlace(extraColor, rayWeight)
+					if aov != nil {
+						aov.direct.addScaledInPlace(extraColor, rayWeight)
+					}
+				} else if aov != nil {
+					aov.shadow += rayWeight / entry(len(lights))
 				}
 			}
 		}
 
-		// recursively trace rays
+		// recursively trace reflected rays
 		if curDepth < r.options.maxDepth {
-			refRayDir := reflect(&ray.direction, &inter.normal)
 
-			// if this is the primary ray, perform some blurring
+			// if this is the primary ray of a glossy surface, blur the reflection with multiple rays
 			numRays := 1
-			if curDepth == 0 {
-				numRays = 4 // TODO move into options
+			if curDepth == 0 && material.roughness > 0 && r.options.numReflectionRays > 1 {
+				numRays = r.options.numReflectionRays
 			}
 			refRayWeight := ONE / entry(numRays)
 
 			for i := 0; i < numRays; i++ {
-
-				// build reflected ray
-				refRay := &Ray{
-					*inter.point.plus(refRayDir.scale(entry(0.001))), // to avoid self-collision
-					*refRayDir.plus(inter.normal.scale(smallRand(0.001))).direction(),
+				u1, u2 := r.sampler.Get2D()
+				refRayDir, weight := sampleReflection(material, ray.direction, inter.normal, u1, u2)
+				weight.scaleInPlace(refRayWeight)
+
+				// skip reflections which are below the surface, or too dim to affect the pixel
+				refThroughput := throughput.times(weight)
+				if refThroughput.maxComponent() <= ZERO || refThroughput.maxComponent() < r.options.minThroughput {
+					continue
 				}
 
-				// trace the reflected ray // TODO early stop if extraColor is small
-				extraColor := material.specular.scale(refRayWeight).times(r.findColor(refRay, scene, lights, curDepth+1))
-				color = color.plus(extraColor)
+				// trace the reflected ray
+				extraColor := weight.times(r.findColor(spawnRay(&inter, refRayDir), scene, lights, curDepth+1, refThroughput, nil))
+				color.addInPlace(extraColor)
+				if aov != nil {
+					aov.reflection.addInPlace(extraColor)
+				}
 			}
 		}
 		return color
 	}
 
 	// no intersections:
-	return &ZERO_V3
+	return ZERO_V3
+}
+
+// trace the i-th sample of the pixel (x,y) (of the current pixel of the sampler), adding it to the framebuffer.
+// Returns the colour of the sample.
+func (r *RayTracer) traceSample(fb *Framebuffer, scene []Shape, lights []Light, x, y, i int) Vec3 {
+	r.sampler.StartSample(i)
+	dx, dy := r.sampler.Get2D()
+	px, py := entry(x)+dx, entry(y)+dy
+	ray := r.buildRayFromEyeToImage(py, px, r.eyePos)
+	if r.aovs == nil {
+		color := r.findColor(ray, scene, lights, 0, ONE_V3, nil)
+		fb.Splat(px, py, color, r.filter)
+		return color
+	}
+
+	var aov aovSample
+	color := r.findColor(ray, scene, lights, 0, ONE_V3, &aov)
+	fb.Splat(px, py, color, r.filter)
+	r.aovs.add(px, py, &aov, r.filter)
+	return color
 }
 
+// RenderStats describes a (possibly partial) render.
+type RenderStats struct {
+	Elapsed      time.Duration // the time taken
+	Pixels       int           // the number of pixels rendered (in rows from the top-left)
+	TotalPixels  int           // the number of pixels in the image
+	Samples      int           // the number of samples traced (for all the pixels)
+	SampleCounts []int         // the number of samples of each pixel (zero for those not rendered)
+}
+
+// Complete checks if every pixel was rendered.
+func (s *RenderStats) Complete() bool {
+	return s.Pixels == s.TotalPixels
+}
+
+// a copy of the ray tracer (with its own sampler) for a render, so that renders can run concurrently
+func (r *RayTracer) forRender() *RayTracer {
+	rt := *r
+	rt.sampler = r.sampler.Clone()
+	return &rt
+}
+
+// Draw renders the scene: each sample is added (by the filter) to the pixels near it.
+// Pixels have samplingFactor^2 samples, and then (for adaptive sampling) more samples in batches of that many,
+// until the error of the pixel is below maxError, or it has maxSamples.
 func (r *RayTracer) Draw(scene []Shape, lights []Light) *image.RGBA {
+	img, _, _ := r.DrawContext(context.Background(), scene, lights)
+	return img
+}
+
+// DrawContext renders the scene (as Draw), stopping promptly (after the current pixel) when ctx is cancelled
+// or its deadline passes, e.g. context.WithTimeout for a time budget. It then returns the partial image
+// (with black for the pixels not rendered), along with ctx.Err(). It is safe to call concurrently.
+func (r *RayTracer) DrawContext(ctx context.Context, scene []Shape, lights []Light) (*image.RGBA, RenderStats, error) {
+	fb := NewFramebuffer(r.width, r.height)
+	stats, err := r.forRender().drawRegion(ctx, scene, lights, fb)
+	return fb.Image(), stats, err
+}
 
-	img := NewOutputImage(r.width, r.height)
-
-	// sampling factor precomputation:
-	sf := r.options.samplingFactor
-	raySubPixel := ONE / entry(sf)
-	raySFmax := raySubPixel / TWO
-	rayWeight := ONE / entry(sf*sf)
-
-	// iterate through the image
-	for y := 0; y < r.height; y++ {
-		for x := 0; x < r.width; x++ {
-			color := &Vec3{0, 0, 0}
-
-			// apply supersampling
-			for cx := 0; cx < sf; cx++ {
-				for cy := 0; cy < sf; cy++ {
-					dx := entry(x) + (entry(cx) * raySubPixel) + smallRand(float64(raySFmax))
-					dy := entry(y) + (entry(cy) * raySubPixel) + smallRand(float64(raySFmax))
-					ray := r.buildRayFromEyeToImage(dy, dx, &r.eyePos)
-					color = color.plus(r.findColor(ray, scene, lights, 0).scale(rayWeight))
+// DrawTile renders the pixels [x0,x1) x [y0,y1) of the image (as DrawContext), returning their colours
+// in rows from the top-left. The pixels are the same as those of the whole image, as the samples of the pixels
+// around the tile (within the radius of the filter) are rendered too.
+func (r *RayTracer) DrawTile(ctx context.Context, scene []Shape, lights []Light, x0, y0, x1, y1 int) ([]Vec3, error) {
+	margin := maxInt(0, int(math.Ceil(float64(r.filter.Radius()-0.5))))
+	mx0, my0 := maxInt(0, x0-margin), maxInt(0, y0-margin)
+	mx1, my1 := minInt(r.width, x1+margin), minInt(r.height, y1+margin)
+
+	fb := newFramebufferRegion(mx0, my0, mx1-mx0, my1-my0)
+	if _, err := r.forRender().drawRegion(ctx, scene, lights, fb); err != nil {
+		return nil, err
+	}
+
+	colors := make([]Vec3, 0, (x1-x0)*(y1-y0))
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			colors = append(colors, fb.Color(x, y))
+		}
+	}
+	return colors, nil
+}
+
+// render the pixels of the region of the framebuffer (stopping when ctx is cancelled).
+// (r should be a copy for this render)
+func (r *RayTracer) drawRegion(ctx context.Context, scene []Shape, lights []Light, fb *Framebuffer) (RenderStats, error) {
+
+	start := time.Now()
+	minSamples := r.options.samplingFactor * r.options.samplingFactor
+	maxSamples := maxInt(r.options.maxSamples, minSamples)
+	stats := RenderStats{TotalPixels: fb.width * fb.height, SampleCounts: make([]int, fb.width*fb.height)}
+	done := ctx.Done()
+
+	// iterate through the region
+	for y := fb.y0; y < fb.y0+fb.height; y++ {
+		for x := fb.x0; x < fb.x0+fb.width; x++ {
+			select {
+			case <-done:
+				stats.Elapsed = time.Since(start)
+				return stats, ctx.Err()
+			default:
+			}
+
+			// apply supersampling: the first dimensions of each sample are its position within the pixel
+			// (and the samples of each batch are stratified by the sampler)
+			r.sampler.StartPixel(x, y, minSamples)
+			var pixel pixelStats
+			for i := 0; i < maxSamples; i++ {
+				if i >= minSamples && i%minSamples == 0 && pixel.standardError() <= r.options.maxError {
+					break
 				}
+				pixel.add(luminance(r.traceSample(fb, scene, lights, x, y, i)))
+			}
+			stats.SampleCounts[fb.index(x, y)] = pixel.n
+			stats.Samples += pixel.n
+			stats.Pixels++
+			if r.progress != nil {
+				r.progress.Add(1)
 			}
-			Set(img, x, y, color)
 		}
 	}
 
-	return img
+	stats.Elapsed = time.Since(start)
+	return stats, nil
 }
@@ -0,0 +1,217 @@
+// contains tests for raytracer.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// a small scene of spheres on a quad, lit by two point lights (as in main.go)
+func benchmarkScene() ([]Shape, []Light) {
+	mat := &Material{Vec3{0.3, 0.3, 0.3}, ZERO_V3, Vec3{0.2, 0.4, 0.2}, Vec3{0.2, 0.35, 0.2}, entry(15), ZERO, nil}
+	var scene []Shape
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			scene = append(scene, NewSphere(entry(0.5), Vec3{1.5 * entry(i-2), -TWO, -TWO * entry(j)}, mat))
+		}
+	}
+	scene = append(scene, NewQuad(Vec3{-3, -4, 0}, Vec3{4, -4, 0}, Vec3{4, -4, -4}, Vec3{-3, -4, -4}, mat))
+	lights := []Light{
+		&PointLight{Vec3{0.2, 0.4, 0.2}, Vec3{0, 5, 3}, X_V3},
+		&PointLight{Vec3{0.4, 0.3, 0.3}, Vec3{-6, 1, 3}, X_V3},
+	}
+	return scene, lights
+}
+
+func benchmarkRayTracer() *RayTracer {
+	view := &Camera{Vec3{0, 0, 6}, ZERO_V3, Y_V3, 64, 48, entry(60)}
+	return NewRayTracer(view, &RayTracerOptions{2, 1, 2, 1, ZERO, nil, nil, 0, ZERO})
+}
+
+// tracing a ray (including its shadow and reflected rays) should not allocate
+func TestFindColorDoesNotAllocate(t *testing.T) {
+	r := benchmarkRayTracer()
+	scene, lights := benchmarkScene()
+	ray := r.buildRayFromEyeToImage(24, 32, r.eyePos)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.findColor(ray, scene, lights, 0, ONE_V3, nil)
+	})
+	assertEquals(t, 0.0, allocs, "findColor: allocations per ray")
+}
+
+func BenchmarkFindColor(b *testing.B) {
+	r := benchmarkRayTracer()
+	scene, lights := benchmarkScene()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ray := r.buildRayFromEyeToImage(entry(i%48), entry(i%64), r.eyePos)
+		r.findColor(ray, scene, lights, 0, ONE_V3, nil)
+	}
+}
+
+func BenchmarkDraw(b *testing.B) {
+	r := benchmarkRayTracer()
+	scene, lights := benchmarkScene()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Draw(scene, lights)
+	}
+}
+
+// a rough surface reflects rays in a lobe about the mirror direction, which is exact for a smooth surface
+func TestSampleReflection(t *testing.T) {
+	dir, normal := Vec3{1, -1, 0}.direction(), Y_V3
+	mirror := Vec3{1, 1, 0}.direction()
+	msg := "Reflection sampling "
+
+	// case 0: smooth (roughness 0) surfaces are perfect mirrors
+	mat := &Material{specular: Vec3{0.5, 0.4, 0.3}}
+	refDir, weight := sampleReflection(mat, dir, normal, entry(0.3), entry(0.7))
+	assertVec3Equals(t, mirror, refDir, msg+"0: mirror direction")
+	assertVec3Equals(t, mat.specular, weight, msg+"0: mirror weight")
+
+	// case 1: rougher surfaces spread the reflected rays further from the mirror direction
+	prevSpread := ZERO
+	for i, roughness := range []entry{0.1, 0.3, 0.6} {
+		mat.roughness = roughness
+		spread := ZERO
+		for u1 := entry(0.05); u1 < 1; u1 += 0.1 {
+			for u2 := entry(0.05); u2 < 1; u2 += 0.1 {
+				refDir, weight = sampleReflection(mat, dir, normal, u1, u2)
+				below := refDir.dot(normal) <= 0
+				assert(t, below == (weight == ZERO_V3), msg+fmt.Sprint("1.", i, ": zero weight only below the surface"))
+				spread += ONE - refDir.dot(mirror)
+			}
+		}
+		assert(t, spread > prevSpread, msg+fmt.Sprint("1.", i, ": spread ", spread, " after ", prevSpread))
+		prevSpread = spread
+	}
+
+	// case 2: a smooth white metal reflects (almost) all the light at normal incidence
+	pbr := NewPBRMaterial(ONE_V3, ZERO_V3, ONE, entry(0.1))
+	total := ZERO_V3
+	for u1 := entry(0.05); u1 < 1; u1 += 0.1 {
+		for u2 := entry(0.05); u2 < 1; u2 += 0.1 {
+			_, weight = sampleReflection(pbr, Y_V3.scale(-ONE), normal, u1, u2)
+			total.addScaledInPlace(weight, entry(0.01))
+		}
+	}
+	assert(t, total.maxComponent() <= 1.01 && total[cX] > 0.9, msg+fmt.Sprint("2: albedo ", total))
+}
+
+// reflections whose contribution to the pixel is below minThroughput are not traced
+func TestReflectionThroughputCutoff(t *testing.T) {
+	// a ray from between two spheres, which the mirror sphere reflects straight back onto the other (with no lights)
+	mirror := &Material{ambient: Vec3{0.1, 0.1, 0.1}, specular: Vec3{0.5, 0.25, 0.25}}
+	other := &Material{ambient: Vec3{0.2, 0.4, 0.8}}
+	scene := []Shape{NewSphere(ONE, ZERO_V3, mirror), NewSphere(ONE, Vec3{0, 0, 4}, other)}
+	ray := Ray{Vec3{0, 0, 2}, Z_V3.scale(-ONE)}
+
+	r := &RayTracer{options: &RayTracerOptions{1, 1, 1, 4, ZERO, nil, nil, 0, ZERO}, sampler: NewIndependentSampler(0)}
+	assertVec3Equals(t, Vec3{0.2, 0.2, 0.3}, r.findColor(ray, scene, nil, 0, ONE_V3, nil), "Reflection traced")
+
+	r.options.minThroughput = entry(0.6)
+	assertVec3Equals(t, mirror.ambient, r.findColor(ray, scene, nil, 0, ONE_V3, nil), "Reflection skipped")
+
+	r.options.minThroughput = entry(0.4)
+	assertVec3Equals(t, mirror.ambient, r.findColor(ray, scene, nil, 0, Vec3{0.5, 0.5, 0.5}, nil), "Reflection of a dim ray skipped")
+}
+
+// a surface colour replaces the base colour of a PBR material, from which its diffuse and specular colours are derived
+func TestSurfaceMaterial(t *testing.T) {
+	inter := &Intersection{color: Vec3{0.2, 0.4, 0.6}, hasColor: true}
+	for _, metallic := range []entry{0, 0.5, 1} {
+		msg := fmt.Sprint("Surface material (metallic ", metallic, ")")
+		mat := NewPBRMaterial(ONE_V3, ZERO_V3, metallic, 0.5)
+		exp := NewPBRMaterial(inter.color, ZERO_V3, metallic, 0.5)
+		res := surfaceMaterial(mat, inter)
+		assertVec3Equals(t, exp.diffuse, res.diffuse, msg+": diffuse")
+		assertVec3Equals(t, exp.specular, res.specular, msg+": specular")
+		assertVec3Equals(t, inter.color, res.pbr.baseColor, msg+": base colour")
+		assertVec3Equals(t, ONE_V3, mat.pbr.baseColor, msg+": original base colour")
+	}
+}
+
+// adaptive sampling adds samples to the noisy pixels (such as the edge of a sphere), but not to the empty background
+func TestAdaptiveSampling(t *testing.T) {
+	view := &Camera{Vec3{0, 0, 6}, ZERO_V3, Y_V3, 16, 16, entry(40)}
+	scene := []Shape{NewSphere(ONE, ZERO_V3, &Material{ambient: Vec3{0.5, 0.5, 0.5}})}
+	options := &RayTracerOptions{0, 2, 1, 1, ZERO, NewSobolSampler(1), nil, 32, 0.01}
+
+	r := NewRayTracer(view, options)
+	_, stats, _ := r.DrawContext(context.Background(), scene, nil)
+	counts := stats.SampleCounts
+	assertEquals(t, 4, counts[0], "Adaptive sampling: samples of the background")
+	assertEquals(t, 4, counts[8*16+8], "Adaptive sampling: samples of the center of the sphere")
+	maxCount := 0
+	for _, c := range counts {
+		assert(t, c >= 4 && c <= 32 && c%4 == 0, fmt.Sprint("Adaptive sampling: ", c, " samples"))
+		maxCount = maxInt(maxCount, c)
+	}
+	assertEquals(t, 32, maxCount, "Adaptive sampling: samples of the edge")
+
+	// without adaptive sampling, every pixel has samplingFactor^2 samples
+	options.maxSamples = 0
+	_, stats, _ = r.DrawContext(context.Background(), scene, nil)
+	for _, c := range stats.SampleCounts {
+		assertEquals(t, 4, c, "Non-adaptive sampling: samples")
+	}
+}
+
+// a complete render has stats for every pixel, and the same image as Draw
+func TestDrawContext(t *testing.T) {
+	scene, lights := benchmarkScene()
+	r := benchmarkRayTracer()
+	img, stats, err := r.DrawContext(context.Background(), scene, lights)
+	assert(t, err == nil && stats.Complete(), fmt.Sprint("DrawContext: error ", err, " with ", stats.Pixels, " pixels"))
+	assertEquals(t, 64*48, stats.TotalPixels, "DrawContext: total pixels")
+	assertEquals(t, 64*48, stats.Samples, "DrawContext: samples")
+	assert(t, stats.Elapsed > 0 && len(stats.SampleCounts) == 64*48, "DrawContext: stats")
+	assert(t, string(img.Pix) == string(r.Draw(scene, lights).Pix), "DrawContext: different image from Draw")
+}
+
+// a cancelled render stops promptly, returning the partial image
+func TestDrawContextCancelled(t *testing.T) {
+	scene, lights := benchmarkScene()
+	r := benchmarkRayTracer()
+
+	// cancelled before it starts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	img, stats, err := r.DrawContext(ctx, scene, lights)
+	assert(t, err == context.Canceled && stats.Pixels == 0 && !stats.Complete(), fmt.Sprint("DrawContext: cancelled with error ", err))
+	assert(t, img.Bounds().Dx() == 64 && img.Bounds().Dy() == 48 && img.RGBAAt(0, 0).R == 0, "DrawContext: partial image")
+
+	// a deadline during a long render (which would take seconds)
+	view := &Camera{Vec3{0, 0, 6}, ZERO_V3, Y_V3, 400, 300, entry(60)}
+	r = NewRayTracer(view, &RayTracerOptions{4, 4, 4, 4, ZERO, nil, nil, 0, ZERO})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, stats, err = r.DrawContext(ctx, scene, lights)
+	elapsed := time.Since(start)
+	assert(t, err == context.DeadlineExceeded, fmt.Sprint("DrawContext: deadline error ", err))
+	assert(t, stats.Pixels < stats.TotalPixels && stats.Samples == 16*stats.Pixels, fmt.Sprint("DrawContext: ", stats.Pixels, " pixels, ", stats.Samples, " samples"))
+	assert(t, elapsed < 500*time.Millisecond, fmt.Sprint("DrawContext: took ", elapsed, " to stop"))
+}
+
+// renders with the same ray tracer can run concurrently (each with its own sampler)
+func TestDrawContextConcurrent(t *testing.T) {
+	scene, lights := benchmarkScene()
+	r := benchmarkRayTracer()
+	exp := r.Draw(scene, lights)
+
+	results := make(chan string)
+	for i := 0; i < 4; i++ {
+		go func() {
+			img, _, _ := r.DrawContext(context.Background(), scene, lights)
+			results <- string(img.Pix)
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		assert(t, <-results == string(exp.Pix), "DrawContext: concurrent render differs")
+	}
+}
@@ -0,0 +1,406 @@
+// sampler.go: Contains the samplers, which generate the uniform random numbers in [0,1)
+// of each sample (the pixel position, light and reflection samples etc).
+
+package main
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// A Sampler generates the random numbers of the samples of each pixel.
+// Each sample consumes its dimensions in order (e.g. the pixel position is the first 2D sample),
+// and each dimension is decorrelated from the others, so that the samples of each dimension
+// can be well distributed (stratified) over all the samples of the pixel.
+type Sampler interface {
+	StartPixel(x, y, numSamples int) // start the numSamples samples of the pixel (x,y)
+	StartSample(index int)           // start the index-th sample of the current pixel, from its first dimension
+	Get1D() entry                    // the next dimension of the current sample
+	Get2D() (entry, entry)           // the next two dimensions of the current sample
+	Clone() Sampler                  // a copy (with the same seed), so that each render has its own
+}
+
+// the largest entry less than one
+const oneMinusEpsilon = ONE - machineEpsilon
+
+// the state shared by the samplers: the current pixel, sample and dimension
+type samplerState struct {
+	seed, pixelSeed uint32
+	x, y            int
+	numSamples      int
+	index           int
+	dim             uint32
+}
+
+// StartPixel starts the samples of the pixel (x,y).
+func (s *samplerState) StartPixel(x, y, numSamples int) {
+	s.x, s.y, s.numSamples = x, y, numSamples
+	s.pixelSeed = hashCombine(hashCombine(s.seed, uint32(x)), uint32(y))
+	s.StartSample(0)
+}
+
+// StartSample starts the index-th sample of the current pixel.
+func (s *samplerState) StartSample(index int) {
+	s.index, s.dim = index, 0
+}
+
+// consume the next n dimensions, returning the first
+func (s *samplerState) nextDim(n uint32) uint32 {
+	d := s.dim
+	s.dim += n
+	return d
+}
+
+// the index of the current sample, shuffled by seed among the numSamples samples of the pixel
+// (any samples beyond numSamples are shuffled again, with another seed)
+func (s *samplerState) shuffledIndex(seed uint32) (uint32, uint32) {
+	n := uint32(maxInt(s.numSamples, 1))
+	seed = hashCombine(seed, uint32(s.index)/n)
+	return permute(uint32(s.index)%n, n, seed), seed
+}
+
+// a hash of the current pixel and a dimension
+func (s *samplerState) dimSeed(dim uint32) uint32 {
+	return hashCombine(s.pixelSeed, dim)
+}
+
+// a 32-bit integer hash (with good avalanche)
+func hashUint32(x uint32) uint32 {
+	x ^= x >> 16
+	x *= 0x7feb352d
+	x ^= x >> 15
+	x *= 0x846ca68b
+	x ^= x >> 16
+	return x
+}
+
+// combine the hash h with the value v
+func hashCombine(h, v uint32) uint32 {
+	return hashUint32(h ^ (v + 0x9e3779b9 + (h << 6) + (h >> 2)))
+}
+
+// convert the bits of x into a number in [0,1)
+// (using only the top 24 bits, so that it is exact (and less than 1) in both precisions)
+func toUnit(x uint32) entry {
+	return entry(x>>8) * 0x1p-24
+}
+
+// the fractional part of v, in [0,1)
+func fractional(v float64) entry {
+	v -= math.Floor(v)
+	if e := entry(v); e < oneMinusEpsilon {
+		return e
+	}
+	return oneMinusEpsilon
+}
+
+// IndependentSampler implementation of Sampler: uncorrelated (white noise) random numbers
+type IndependentSampler struct {
+	samplerState
+}
+
+// NewIndependentSampler creates an IndependentSampler, whose numbers are determined by seed.
+func NewIndependentSampler(seed int) *IndependentSampler {
+	return &IndependentSampler{samplerState{seed: uint32(seed)}}
+}
+
+// Clone returns a copy of the sampler.
+func (s *IndependentSampler) Clone() Sampler {
+	c := *s
+	return &c
+}
+
+// Get1D returns the next dimension of the current sample.
+func (s *IndependentSampler) Get1D() entry {
+	return toUnit(hashCombine(s.dimSeed(s.nextDim(1)), uint32(s.index)))
+}
+
+// Get2D returns the next two dimensions of the current sample.
+func (s *IndependentSampler) Get2D() (entry, entry) {
+	return s.Get1D(), s.Get1D()
+}
+
+// StratifiedSampler implementation of Sampler: each dimension is divided into one stratum per sample
+// (a grid, for 2D samples when the number of samples is square), with a random point within each stratum.
+// The strata are shuffled independently for each dimension.
+type StratifiedSampler struct {
+	samplerState
+}
+
+// NewStratifiedSampler creates a StratifiedSampler, whose numbers are determined by seed.
+func NewStratifiedSampler(seed int) *StratifiedSampler {
+	return &StratifiedSampler{samplerState{seed: uint32(seed)}}
+}
+
+// Clone returns a copy of the sampler.
+func (s *StratifiedSampler) Clone() Sampler {
+	c := *s
+	return &c
+}
+
+// Get1D returns the next dimension of the current sample.
+func (s *StratifiedSampler) Get1D() entry {
+	n := entry(maxInt(s.numSamples, 1))
+	stratum, seed := s.shuffledIndex(s.dimSeed(s.nextDim(1)))
+	jitter := toUnit(hashCombine(seed, stratum))
+	return minEntry((entry(stratum)+jitter)/n, oneMinusEpsilon)
+}
+
+// Get2D returns the next two dimensions of the current sample.
+func (s *StratifiedSampler) Get2D() (entry, entry) {
+	k := int(math.Sqrt(float64(s.numSamples)))
+	if k < 1 || k*k != s.numSamples {
+		// not a square: stratify each dimension separately (i.e. latin hypercube sampling)
+		return s.Get1D(), s.Get1D()
+	}
+
+	stratum, seed := s.shuffledIndex(s.dimSeed(s.nextDim(2)))
+	jx, jy := toUnit(hashCombine(seed, 2*stratum)), toUnit(hashCombine(seed, 2*stratum+1))
+	sx, sy, kk := entry(stratum%uint32(k)), entry(stratum/uint32(k)), entry(k)
+	return minEntry((sx+jx)/kk, oneMinusEpsilon), minEntry((sy+jy)/kk, oneMinusEpsilon)
+}
+
+// a random permutation of [0,n), selected by seed (from Kensler's "Correlated Multi-Jittered Sampling"):
+// the index i in [0,n) is mapped to its position in the permutation.
+func permute(i, n, seed uint32) uint32 {
+	w := n - 1
+	w |= w >> 1
+	w |= w >> 2
+	w |= w >> 4
+	w |= w >> 8
+	w |= w >> 16
+	for {
+		i ^= seed
+		i *= 0xe170893d
+		i ^= seed >> 16
+		i ^= (i & w) >> 4
+		i ^= seed >> 8
+		i *= 0x0929eb3f
+		i ^= seed >> 23
+		i ^= (i & w) >> 1
+		i *= 1 | seed>>27
+		i *= 0x6935fa69
+		i ^= (i & w) >> 11
+		i *= 0x74dcb303
+		i ^= (i & w) >> 2
+		i *= 0x9e501cc3
+		i ^= (i & w) >> 2
+		i *= 0xc860a3df
+		i &= w
+		i ^= i >> 5
+		if i < n {
+			break
+		}
+	}
+	return (i + seed) % n
+}
+
+// the first primes, the bases of the dimensions of the Halton sequence
+var haltonPrimes = [...]uint32{
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53,
+	59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+	137, 139, 149, 151, 157, 163, 167, 173, 179, 181, 191, 193, 197, 199, 211, 223,
+	227, 229, 233, 239, 241, 251, 257, 263, 269, 271, 277, 281, 283, 293, 307, 311,
+}
+
+// HaltonSampler implementation of Sampler: the Halton sequence (the radical inverse of the sample index,
+// in a different prime base for each dimension), randomly shifted for each pixel.
+// Dimensions beyond the number of primes are independent random numbers.
+type HaltonSampler struct {
+	samplerState
+}
+
+// NewHaltonSampler creates a HaltonSampler, whose shifts are determined by seed.
+func NewHaltonSampler(seed int) *HaltonSampler {
+	return &HaltonSampler{samplerState{seed: uint32(seed)}}
+}
+
+// the digits of index in the given base, reversed around the decimal point
+func radicalInverse(base, index uint32) float64 {
+	invBase, invBaseN, reversed := 1/float64(base), 1.0, uint64(0)
+	for ; index > 0; index /= base {
+		reversed = reversed*uint64(base) + uint64(index%base)
+		invBaseN *= invBase
+	}
+	return float64(reversed) * invBaseN
+}
+
+// Clone returns a copy of the sampler.
+func (s *HaltonSampler) Clone() Sampler {
+	c := *s
+	return &c
+}
+
+// Get1D returns the next dimension of the current sample.
+func (s *HaltonSampler) Get1D() entry {
+	dim := s.nextDim(1)
+	shift := toUnit(s.dimSeed(dim))
+	if dim >= uint32(len(haltonPrimes)) {
+		return toUnit(hashCombine(s.dimSeed(dim), uint32(s.index)))
+	}
+	return fractional(radicalInverse(haltonPrimes[dim], uint32(s.index)) + float64(shift))
+}
+
+// Get2D returns the next two dimensions of the current sample.
+func (s *HaltonSampler) Get2D() (entry, entry) {
+	return s.Get1D(), s.Get1D()
+}
+
+// SobolSampler implementation of Sampler: the first two dimensions of the Sobol sequence (a (0,2)-sequence),
+// Owen-scrambled and shuffled independently for each 2D sample (from Burley's "Practical Hash-based Owen Scrambling").
+type SobolSampler struct {
+	samplerState
+}
+
+// NewSobolSampler creates a SobolSampler, whose scrambling is determined by seed.
+func NewSobolSampler(seed int) *SobolSampler {
+	return &SobolSampler{samplerState{seed: uint32(seed)}}
+}
+
+// the first (dim=0) or second (dim=1) dimension of the Sobol sequence, as a 32-bit fraction
+func sobol(index uint32, dim int) uint32 {
+	if dim == 0 {
+		return bits.Reverse32(index)
+	}
+	res := uint32(0)
+	for v := uint32(1 << 31); index != 0; index >>= 1 {
+		if index&1 != 0 {
+			res ^= v
+		}
+		v ^= v >> 1
+	}
+	return res
+}
+
+// the hash of Laine and Karras, which only propagates the bits of x upwards
+func laineKarrasPermutation(x, seed uint32) uint32 {
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return x
+}
+
+// Owen-scramble the 32-bit fraction x: each bit is flipped depending on the bits above it
+func nestedUniformScramble(x, seed uint32) uint32 {
+	return bits.Reverse32(laineKarrasPermutation(bits.Reverse32(x), seed))
+}
+
+// Clone returns a copy of the sampler.
+func (s *SobolSampler) Clone() Sampler {
+	c := *s
+	return &c
+}
+
+// Get1D returns the next dimension of the current sample.
+func (s *SobolSampler) Get1D() entry {
+	seed := s.dimSeed(s.nextDim(1))
+	i := nestedUniformScramble(uint32(s.index), seed)
+	return toUnit(nestedUniformScramble(sobol(i, 0), hashCombine(seed, 1)))
+}
+
+// Get2D returns the next two dimensions of the current sample.
+func (s *SobolSampler) Get2D() (entry, entry) {
+	seed := s.dimSeed(s.nextDim(2))
+	i := nestedUniformScramble(uint32(s.index), seed)
+	x := nestedUniformScramble(sobol(i, 0), hashCombine(seed, 1))
+	y := nestedUniformScramble(sobol(i, 1), hashCombine(seed, 2))
+	return toUnit(x), toUnit(y)
+}
+
+// the width (and height) of the tile of blue noise
+const blueNoiseSize = 32
+
+var (
+	blueNoiseTile [blueNoiseSize * blueNoiseSize]entry // the values in [0,1) of the (toroidal) tile of blue noise
+	blueNoiseOnce sync.Once
+)
+
+// build the tile of blue noise, by the void-and-cluster method: each pixel is ranked in the order in which
+// it is placed into the largest void (the unfilled pixel with the least energy from the filled pixels).
+func buildBlueNoise() {
+	const n, sigma = blueNoiseSize * blueNoiseSize, 1.5
+
+	// the energy contributed by a pixel at each (toroidal) offset:
+	var gaussian [n]float64
+	for dy := 0; dy < blueNoiseSize; dy++ {
+		for dx := 0; dx < blueNoiseSize; dx++ {
+			wx, wy := minInt(dx, blueNoiseSize-dx), minInt(dy, blueNoiseSize-dy)
+			gaussian[dy*blueNoiseSize+dx] = math.Exp(-float64(wx*wx+wy*wy) / (2 * sigma * sigma))
+		}
+	}
+
+	var energy [n]float64
+	var filled [n]bool
+	for rank := 0; rank < n; rank++ {
+		// find the largest void (with ties broken by a hash, rather than the order of the pixels)
+		best := -1
+		for p := 0; p < n; p++ {
+			if !filled[p] && (best < 0 || energy[p] < energy[best] ||
+				(energy[p] == energy[best] && hashUint32(uint32(p)) < hashUint32(uint32(best)))) {
+				best = p
+			}
+		}
+		filled[best] = true
+		blueNoiseTile[best] = (entry(rank) + 0.5) / entry(n)
+
+		// add its energy to every pixel
+		bx, by := best%blueNoiseSize, best/blueNoiseSize
+		for p := 0; p < n; p++ {
+			dx := (p%blueNoiseSize - bx + blueNoiseSize) % blueNoiseSize
+			dy := (p/blueNoiseSize - by + blueNoiseSize) % blueNoiseSize
+			energy[p] += gaussian[dy*blueNoiseSize+dx]
+		}
+	}
+}
+
+// BlueNoiseSampler implementation of Sampler: a low-discrepancy (golden ratio) sequence, shifted for each pixel
+// by a tile of blue noise (offset differently for each dimension), so that the error is distributed
+// as blue noise over the image, which is less visible than white noise (at the same number of samples).
+// The order of the samples is shuffled for each dimension, to decorrelate them.
+type BlueNoiseSampler struct {
+	samplerState
+}
+
+// NewBlueNoiseSampler creates a BlueNoiseSampler, whose offsets are determined by seed.
+func NewBlueNoiseSampler(seed int) *BlueNoiseSampler {
+	blueNoiseOnce.Do(buildBlueNoise)
+	return &BlueNoiseSampler{samplerState{seed: uint32(seed)}}
+}
+
+// the blue noise of the current pixel, in the tile offset by (the hash) h
+// (offset in the same way for all the pixels, so that neighbouring pixels have different values)
+func (s *BlueNoiseSampler) noise(h uint32) float64 {
+	x := ((s.x+int(h%blueNoiseSize))%blueNoiseSize + blueNoiseSize) % blueNoiseSize
+	y := ((s.y+int(h/blueNoiseSize%blueNoiseSize))%blueNoiseSize + blueNoiseSize) % blueNoiseSize
+	return float64(blueNoiseTile[y*blueNoiseSize+x])
+}
+
+// the index of the current sample in a dimension (shuffled in the same way for all the pixels)
+func (s *BlueNoiseSampler) dimIndex(dim uint32) (float64, uint32) {
+	h := hashCombine(s.seed, dim)
+	index, _ := s.shuffledIndex(h)
+	return float64(index), h
+}
+
+// Clone returns a copy of the sampler.
+func (s *BlueNoiseSampler) Clone() Sampler {
+	c := *s
+	return &c
+}
+
+// Get1D returns the next dimension of the current sample.
+func (s *BlueNoiseSampler) Get1D() entry {
+	const g = 0.6180339887498949 // 1/golden ratio
+	index, h := s.dimIndex(s.nextDim(1))
+	return fractional(index*g + s.noise(h))
+}
+
+// Get2D returns the next two dimensions of the current sample.
+func (s *BlueNoiseSampler) Get2D() (entry, entry) {
+	const g1, g2 = 0.7548776662466927, 0.5698402909980532 // the R2 sequence: 1/phi, 1/phi^2 for the plastic number phi
+	index, h := s.dimIndex(s.nextDim(2))
+	return fractional(index*g1 + s.noise(h)), fractional(index*g2 + s.noise(hashUint32(h)))
+}
@@ -0,0 +1,186 @@
+// contains tests for sampler.go
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// each of the samplers (with the same seed)
+func testSamplers() map[string]Sampler {
+	return map[string]Sampler{
+		"independent": NewIndependentSampler(7),
+		"stratified":  NewStratifiedSampler(7),
+		"halton":      NewHaltonSampler(7),
+		"sobol":       NewSobolSampler(7),
+		"blue noise":  NewBlueNoiseSampler(7),
+	}
+}
+
+// all the samplers generate numbers in [0,1), which depend only on the pixel, sample and dimension
+func TestSamplersAreDeterministic(t *testing.T) {
+	for name, s := range testSamplers() {
+		var first [3][8]entry
+		for pass := 0; pass < 2; pass++ {
+			for x := -1; x < 2; x++ {
+				s.StartPixel(x, 5, 9)
+				for i := 0; i < 9; i++ {
+					s.StartSample(i)
+					for d := 0; d < 4; d++ {
+						u1, u2 := s.Get2D()
+						u3 := s.Get1D()
+						for _, u := range []entry{u1, u2, u3} {
+							assert(t, u >= 0 && u < 1, fmt.Sprint(name, ": ", u, " is not in [0,1)"))
+						}
+						if i == 0 && pass == 0 {
+							first[x+1][2*d], first[x+1][2*d+1] = u1, u3
+						} else if i == 0 {
+							assert(t, first[x+1][2*d] == u1 && first[x+1][2*d+1] == u3, name+": different numbers for the same sample")
+						}
+					}
+				}
+			}
+		}
+		assert(t, first[0] != first[1] && first[1] != first[2], name+": the same numbers in different pixels")
+	}
+}
+
+// the samples of each dimension of a pixel are stratified: one per interval of width 1/n (or grid cell, for 2D)
+func TestSamplersAreStratified(t *testing.T) {
+	const n, k = 16, 4
+	for _, name := range []string{"stratified", "sobol"} {
+		s := testSamplers()[name]
+		for _, px := range []int{0, 3, 99} {
+			var strata [3][n]int
+			s.StartPixel(px, 2*px, n)
+			for i := 0; i < n; i++ {
+				s.StartSample(i)
+				x, y := s.Get2D()
+				strata[0][int(y*k)*k+int(x*k)]++
+				strata[1][int(s.Get1D()*n)]++
+				x, y = s.Get2D()
+				strata[2][int(y*k)*k+int(x*k)]++
+			}
+			for d, counts := range strata {
+				for c, count := range counts {
+					assertEquals(t, 1, count, fmt.Sprint(name, ": samples of pixel ", px, " dimension ", d, " in stratum ", c))
+				}
+			}
+		}
+	}
+
+	// the 2D samples of the Sobol sampler are also stratified in each of x and y (as a (0,2)-sequence)
+	s := NewSobolSampler(3)
+	var strataX, strataY [n]int
+	s.StartPixel(4, 5, n)
+	for i := 0; i < n; i++ {
+		s.StartSample(i)
+		x, y := s.Get2D()
+		strataX[int(x*n)]++
+		strataY[int(y*n)]++
+	}
+	for c := 0; c < n; c++ {
+		assert(t, strataX[c] == 1 && strataY[c] == 1, fmt.Sprint("sobol: samples in x, y stratum ", c, ": ", strataX[c], ", ", strataY[c]))
+	}
+}
+
+// the dimensions of a sample are not correlated with each other
+func TestSamplerDimensionsAreDecorrelated(t *testing.T) {
+	const n = 64
+	for name, s := range testSamplers() {
+		// the correlation coefficient of consecutive dimensions, over all the samples of a pixel
+		s.StartPixel(1, 2, n)
+		var sumX, sumY, sumXY, sumXX, sumYY float64
+		for i := 0; i < n; i++ {
+			s.StartSample(i)
+			s.Get2D()
+			x, y := float64(s.Get1D()), float64(s.Get1D())
+			sumX, sumY, sumXY, sumXX, sumYY = sumX+x, sumY+y, sumXY+x*y, sumXX+x*x, sumYY+y*y
+		}
+		cov := sumXY/n - sumX*sumY/(n*n)
+		corr := cov / math.Sqrt((sumXX/n-sumX*sumX/(n*n))*(sumYY/n-sumY*sumY/(n*n)))
+		assert(t, math.Abs(corr) < 0.35, fmt.Sprint(name, ": correlation ", corr))
+	}
+}
+
+// the low-discrepancy samplers estimate an integral more accurately than independent samples (by at least half)
+func TestSamplersConverge(t *testing.T) {
+	const n, pixels = 64, 100
+
+	// the root-mean-square error of estimating the integral (over the unit square) of a smooth function
+	rmsError := func(s Sampler) float64 {
+		sumSq := 0.0
+		for p := 0; p < pixels; p++ {
+			s.StartPixel(p%10, p/10, n)
+			sum := 0.0
+			for i := 0; i < n; i++ {
+				s.StartSample(i)
+				x, y := s.Get2D()
+				sum += math.Sin(math.Pi*float64(x)) * float64(y) * float64(y)
+			}
+			err := sum/n - 2/(3*math.Pi)
+			sumSq += err * err
+		}
+		return math.Sqrt(sumSq / pixels)
+	}
+
+	samplers := testSamplers()
+	independent := rmsError(samplers["independent"])
+	for _, name := range []string{"stratified", "halton", "sobol", "blue noise"} {
+		err := rmsError(samplers[name])
+		assert(t, err < independent/2, fmt.Sprint(name, ": error ", err, " is not much less than independent error ", independent))
+	}
+}
+
+// the tile of blue noise has each value once, and neighbouring pixels have more different values than white noise
+func TestBlueNoiseTile(t *testing.T) {
+	NewBlueNoiseSampler(0)
+	const n = blueNoiseSize * blueNoiseSize
+	var seen [n]bool
+	diff := ZERO
+	for p, v := range blueNoiseTile {
+		rank := int(v * n)
+		assert(t, !seen[rank], fmt.Sprint("Blue noise: rank ", rank, " repeated"))
+		seen[rank] = true
+		right := blueNoiseTile[p/blueNoiseSize*blueNoiseSize+(p+1)%blueNoiseSize]
+		below := blueNoiseTile[(p+blueNoiseSize)%n]
+		diff += abs(v-right) + abs(v-below)
+	}
+	// (white noise would have a mean difference of 1/3)
+	diff /= 2 * n
+	assert(t, diff > 0.4, fmt.Sprint("Blue noise: mean difference between neighbours ", diff))
+}
+
+func TestPermute(t *testing.T) {
+	for _, n := range []uint32{1, 5, 16, 100} {
+		for seed := uint32(0); seed < 4; seed++ {
+			seen := make([]bool, n)
+			for i := uint32(0); i < n; i++ {
+				p := permute(i, n, hashUint32(seed))
+				assert(t, p < n && !seen[p], fmt.Sprint("Permute: ", i, " maps to ", p, " (of ", n, ")"))
+				seen[p] = true
+			}
+		}
+	}
+}
+
+func TestRadicalInverse(t *testing.T) {
+	assertEquals(t, 0.0, radicalInverse(2, 0), "Radical inverse: 0")
+	assertEquals(t, 0.75, radicalInverse(2, 3), "Radical inverse: 3 (binary 0.11)")
+	assert(t, math.Abs(radicalInverse(3, 5)-(1.0/9+2.0/3)) < 1e-15, "Radical inverse: 5 (ternary 0.21)")
+}
+
+// the samplers do not allocate while rendering
+func TestSamplersDoNotAllocate(t *testing.T) {
+	for name, s := range testSamplers() {
+		allocs := testing.AllocsPerRun(100, func() {
+			s.StartPixel(3, 4, 16)
+			s.StartSample(5)
+			s.Get2D()
+			s.Get1D()
+		})
+		assertEquals(t, 0.0, allocs, name+": allocations per sample")
+	}
+}
@@ -0,0 +1,47 @@
+// sampling.go: Contains functions which turn uniform random numbers in [0,1)
+// into directions, for Monte Carlo sampling.
+
+package main
+
+import "math"
+
+// build two tangent vectors which, with the (unit) normal, form an orthonormal basis
+func orthonormalBasis(normal Vec3) (tangent, bitangent Vec3) {
+	// pick the axis least aligned with the normal, to avoid a degenerate cross product:
+	axis := X_V3
+	if abs(normal[cX]) > 0.9 {
+		axis = Y_V3
+	}
+	tangent = axis.cross(normal).direction()
+	bitangent = normal.cross(tangent)
+	return
+}
+
+// convert a direction from the (tangent, bitangent, normal) basis into world co-ordinates
+func fromLocal(local, tangent, bitangent, normal Vec3) Vec3 {
+	return tangent.scale(local[cX]).plus(bitangent.scale(local[cY])).plus(normal.scale(local[cZ]))
+}
+
+// a direction in the hemisphere about +z with the given cosine (to z), and azimuth 2*pi*u
+func sphericalDirection(cosTheta, u entry) Vec3 {
+	sinTheta := sqrt(maxEntry(ZERO, ONE-cosTheta*cosTheta))
+	phi := 2 * math.Pi * float64(u)
+	return Vec3{sinTheta * entry(math.Cos(phi)), sinTheta * entry(math.Sin(phi)), cosTheta}
+}
+
+// a cosine-weighted direction in the hemisphere about +z (with pdf cos(theta)/pi)
+func cosineHemisphere(u1, u2 entry) Vec3 {
+	return sphericalDirection(sqrt(ONE-u1), u2)
+}
+
+// a direction in the Phong lobe about the (unit) axis, with pdf (n+1)/(2*pi) * cos(theta)^n for exponent n
+func phongLobe(axis Vec3, exponent, u1, u2 entry) Vec3 {
+	cosTheta := entry(math.Pow(float64(ONE-u1), float64(ONE/(exponent+ONE))))
+	tangent, bitangent := orthonormalBasis(axis)
+	return fromLocal(sphericalDirection(cosTheta, u2), tangent, bitangent, axis)
+}
+
+// wrap around math.Abs
+func abs(e entry) entry {
+	return entry(math.Abs(float64(e)))
+}
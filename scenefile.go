@@ -0,0 +1,468 @@
+// scenefile.go: Contains a JSON format for whole scenes: the camera, the options of the ray tracer,
+// the materials, the shapes and the lights. (used to send scenes to workers, see distributed.go)
+//
+// Vectors are arrays of 3 numbers, and angles are in degrees. Meshes may be inline (vertices and indices),
+// or a file (relative to the scene file), which Embed reads into the scene so it has no external files.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A SceneFile holds the description of a scene, which Build turns into a RayTracer, shapes and lights.
+type SceneFile struct {
+	Camera    sceneCamera
+	Options   sceneOptions
+	Materials map[string]*sceneMaterial // by name
+	Shapes    []*sceneShape
+	Lights    []*sceneLight
+	dir       string // the directory of the scene file, which mesh files are relative to
+}
+
+type sceneCamera struct {
+	Position, LookAt, Up []float64
+	Width, Height        int
+	FovY                 float64
+}
+
+type sceneOptions struct {
+	MaxDepth, SamplingFactor, ShadowRays, ReflectionRays int
+	MinThroughput                                        float64
+	Sampler                                              *sceneSampler // nil for the default sampler
+	Filter                                               *sceneFilter  // nil for the default filter
+	MaxSamples                                           int
+	MaxError                                             float64
+}
+
+type sceneSampler struct {
+	Type string // "independent", "stratified", "halton", "sobol" or "bluenoise"
+	Seed int
+}
+
+type sceneFilter struct {
+	Type   string // "box", "tent", "gaussian", "mitchell" or "lanczos"
+	Radius float64
+	Sigma  float64 // (gaussian)
+	B, C   float64 // (mitchell)
+}
+
+type sceneMaterial struct {
+	Type                                 string // "phong" (the default) or "pbr"
+	Ambient, Emission, Diffuse, Specular []float64
+	Shininess, Roughness                 float64
+	BaseColor                            []float64 // (pbr)
+	Metallic                             float64   // (pbr)
+}
+
+type sceneShape struct {
+	Type     string // "sphere", "ellipsoid", "quad", "mesh", "group" or "instance"
+	Material string // the name of the material (which an instance overrides, if given)
+
+	Center []float64 // (sphere, ellipsoid)
+	Radius float64   // (sphere)
+	Radii  []float64 // (ellipsoid)
+	Axis   []float64 // the axis of rotation (ellipsoid)
+	Angle  float64
+
+	Points [][]float64 // 4 corners (quad)
+
+	File     string      // a PLY or STL file (mesh), or:
+	Data     []byte      // the contents of such a file (base64 in json), with its
+	Format   string      // extension (e.g. ".ply"), or:
+	Vertices [][]float64 // vertex positions,
+	Normals  [][]float64 // per-vertex normals (optional),
+	Indices  []uint32    // and 3 vertex indices per triangle.
+
+	Shapes []*sceneShape // (group)
+
+	Shape       *sceneShape // (instance) the shape, placed by:
+	Translation []float64   // translation,
+	Rotation    []float64   // rotation (x,y,z euler angles),
+	Scale       []float64   // and scale, applied in the order: scale, rotate, translate.
+}
+
+type sceneLight struct {
+	Type        string // "point" or "directional"
+	Color       []float64
+	Position    []float64 // (point)
+	Attenuation []float64 // constant, linear and quadratic coefficients (point)
+	Direction   []float64 // towards the light (directional)
+}
+
+// LoadSceneFile reads a scene file.
+func LoadSceneFile(path string) (*SceneFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSceneFile(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// ParseSceneFile reads a scene from the file contents; dir is used to resolve relative mesh files.
+func ParseSceneFile(data []byte, dir string) (*SceneFile, error) {
+	s := &SceneFile{dir: dir}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("scene: %v", err)
+	}
+	return s, nil
+}
+
+// Embed reads the mesh files of the scene into it (so that the scene can be sent elsewhere, as json).
+func (s *SceneFile) Embed() error {
+	return s.walkShapes(func(shape *sceneShape) error {
+		if shape.File != "" {
+			data, err := os.ReadFile(s.path(shape.File))
+			if err != nil {
+				return err
+			}
+			shape.Data, shape.Format, shape.File = data, filepath.Ext(shape.File), ""
+		}
+		return nil
+	})
+}
+
+// call fn for every shape of the scene (including those within groups and instances), stopping at the first error
+func (s *SceneFile) walkShapes(fn func(shape *sceneShape) error) error {
+	var walk func(shapes []*sceneShape) error
+	walk = func(shapes []*sceneShape) error {
+		for _, shape := range shapes {
+			if shape == nil {
+				continue
+			}
+			if err := fn(shape); err != nil {
+				return err
+			}
+			if err := walk(shape.Shapes); err != nil {
+				return err
+			}
+			if err := walk([]*sceneShape{shape.Shape}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(s.Shapes)
+}
+
+// the path of a file of the scene
+func (s *SceneFile) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(s.dir, file)
+}
+
+// Build creates the ray tracer, the shapes and the lights of the scene.
+func (s *SceneFile) Build() (*RayTracer, []Shape, []Light, error) {
+	return s.build(nil)
+}
+
+// build the scene, reusing the meshes of the cache (if not nil)
+func (s *SceneFile) build(cache *MeshCache) (*RayTracer, []Shape, []Light, error) {
+	c := &s.Camera
+	if c.Width <= 0 || c.Height <= 0 {
+		return nil, nil, nil, fmt.Errorf("scene: the image size %dx%d is invalid", c.Width, c.Height)
+	}
+	fovY := entry(c.FovY)
+	if fovY == 0 {
+		fovY = 50
+	}
+	var vs sceneVecs
+	camera := &Camera{vs.vec("Position", c.Position, ZERO_V3), vs.vec("LookAt", c.LookAt, ZERO_V3), vs.vec("Up", c.Up, Y_V3), c.Width, c.Height, fovY}
+	if vs.err != nil {
+		return nil, nil, nil, fmt.Errorf("scene: camera: %v", vs.err)
+	}
+
+	o := &s.Options
+	sampler, err := o.Sampler.build()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	filter, err := o.Filter.build()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	options := &RayTracerOptions{
+		maxInt(o.MaxDepth, 1), maxInt(o.SamplingFactor, 1), maxInt(o.ShadowRays, 1), maxInt(o.ReflectionRays, 1),
+		entry(o.MinThroughput), sampler, filter, o.MaxSamples, entry(o.MaxError),
+	}
+
+	materials := make(map[string]*Material, len(s.Materials))
+	for name, m := range s.Materials {
+		if materials[name], err = m.build(); err != nil {
+			return nil, nil, nil, fmt.Errorf("scene: material %q: %v", name, err)
+		}
+	}
+
+	shapes := make([]Shape, 0, len(s.Shapes))
+	for i, shape := range s.Shapes {
+		built, err := s.buildShape(shape, materials, cache)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("scene: shape %d: %v", i, err)
+		}
+		shapes = append(shapes, built)
+	}
+
+	lights := make([]Light, 0, len(s.Lights))
+	for i, light := range s.Lights {
+		built, err := light.build()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("scene: light %d: %v", i, err)
+		}
+		lights = append(lights, built)
+	}
+
+	return NewRayTracer(camera, options), shapes, lights, nil
+}
+
+// reads the vectors of the scene, keeping the first error
+type sceneVecs struct {
+	err error
+}
+
+// the vector of the named field (or def, if it is not given), which must have 3 components if it is given
+func (vs *sceneVecs) vec(name string, v []float64, def Vec3) Vec3 {
+	if v == nil {
+		return def
+	}
+	if len(v) != V3LEN {
+		if vs.err == nil {
+			vs.err = fmt.Errorf("%s has %d components, not %d", name, len(v), V3LEN)
+		}
+		return def
+	}
+	return Vec3{entry(v[cX]), entry(v[cY]), entry(v[cZ])}
+}
+
+func (s *sceneSampler) build() (Sampler, error) {
+	if s == nil {
+		return nil, nil
+	}
+	switch strings.ToLower(s.Type) {
+	case "independent":
+		return NewIndependentSampler(s.Seed), nil
+	case "stratified":
+		return NewStratifiedSampler(s.Seed), nil
+	case "halton":
+		return NewHaltonSampler(s.Seed), nil
+	case "sobol":
+		return NewSobolSampler(s.Seed), nil
+	case "bluenoise":
+		return NewBlueNoiseSampler(s.Seed), nil
+	}
+	return nil, fmt.Errorf("scene: unknown sampler %q", s.Type)
+}
+
+func (f *sceneFilter) build() (Filter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	radius := entry(f.Radius)
+	switch strings.ToLower(f.Type) {
+	case "box":
+		return NewBoxFilter(radius), nil
+	case "tent":
+		return NewTentFilter(radius), nil
+	case "gaussian":
+		return NewGaussianFilter(radius, entry(f.Sigma)), nil
+	case "mitchell":
+		return NewMitchellFilter(radius, entry(f.B), entry(f.C)), nil
+	case "lanczos":
+		return NewLanczosFilter(radius), nil
+	}
+	return nil, fmt.Errorf("scene: unknown filter %q", f.Type)
+}
+
+func (m *sceneMaterial) build() (*Material, error) {
+	var vs sceneVecs
+	switch strings.ToLower(m.Type) {
+	case "", "phong":
+		mat := &Material{
+			vs.vec("Ambient", m.Ambient, ZERO_V3), vs.vec("Emission", m.Emission, ZERO_V3), vs.vec("Diffuse", m.Diffuse, ZERO_V3), vs.vec("Specular", m.Specular, ZERO_V3),
+			entry(m.Shininess), entry(m.Roughness), nil,
+		}
+		return mat, vs.err
+	case "pbr":
+		mat := NewPBRMaterial(vs.vec("BaseColor", m.BaseColor, ONE_V3), vs.vec("Emission", m.Emission, ZERO_V3), entry(m.Metallic), entry(m.Roughness))
+		return mat, vs.err
+	}
+	return nil, fmt.Errorf("unknown type %q", m.Type)
+}
+
+// the material of the given name (nil for none)
+func sceneMaterialNamed(name string, materials map[string]*Material) (*Material, error) {
+	if name == "" {
+		return nil, nil
+	}
+	mat, ok := materials[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown material %q", name)
+	}
+	return mat, nil
+}
+
+func (s *SceneFile) buildShape(shape *sceneShape, materials map[string]*Material, cache *MeshCache) (Shape, error) {
+	if shape == nil {
+		return nil, fmt.Errorf("missing shape")
+	}
+	mat, err := sceneMaterialNamed(shape.Material, materials)
+	if err != nil {
+		return nil, err
+	}
+
+	var vs sceneVecs
+	switch strings.ToLower(shape.Type) {
+	case "sphere":
+		center := vs.vec("Center", shape.Center, ZERO_V3)
+		if vs.err != nil {
+			return nil, vs.err
+		}
+		return NewSphere(entry(shape.Radius), center, mat), nil
+
+	case "ellipsoid":
+		radii, center, axis := vs.vec("Radii", shape.Radii, ONE_V3), vs.vec("Center", shape.Center, ZERO_V3), vs.vec("Axis", shape.Axis, X_V3)
+		if vs.err != nil {
+			return nil, vs.err
+		}
+		return NewRotatedEllipsoid(radii, center, axis, entry(shape.Angle), mat), nil
+
+	case "quad":
+		if len(shape.Points) != 4 {
+			return nil, fmt.Errorf("a quad has 4 points, not %d", len(shape.Points))
+		}
+		var pts [4]Vec3
+		for i, p := range shape.Points {
+			if p == nil {
+				return nil, fmt.Errorf("Points[%d] is missing", i)
+			}
+			pts[i] = vs.vec(fmt.Sprintf("Points[%d]", i), p, ZERO_V3)
+		}
+		if vs.err != nil {
+			return nil, vs.err
+		}
+		return NewQuad(pts[0], pts[1], pts[2], pts[3], mat), nil
+
+	case "mesh":
+		return s.buildMesh(shape, mat, cache)
+
+	case "group":
+		children := make([]Shape, 0, len(shape.Shapes))
+		for i, child := range shape.Shapes {
+			built, err := s.buildShape(child, materials, cache)
+			if err != nil {
+				return nil, fmt.Errorf("shape %d of the group: %v", i, err)
+			}
+			children = append(children, built)
+		}
+		return NewGroup(children...), nil
+
+	case "instance":
+		child, err := s.buildShape(shape.Shape, materials, cache)
+		if err != nil {
+			return nil, fmt.Errorf("the shape of the instance: %v", err)
+		}
+		r, translation, scale := vs.vec("Rotation", shape.Rotation, ZERO_V3), vs.vec("Translation", shape.Translation, ZERO_V3), vs.vec("Scale", shape.Scale, ONE_V3)
+		if vs.err != nil {
+			return nil, vs.err
+		}
+		trans := NewTRS(translation, QuaternionFromEuler(r[cX], r[cY], r[cZ]), scale)
+		return NewInstance(child, trans, mat), nil
+	}
+	return nil, fmt.Errorf("unknown type %q", shape.Type)
+}
+
+func (s *SceneFile) buildMesh(shape *sceneShape, mat *Material, cache *MeshCache) (Shape, error) {
+	// the source of the mesh: the contents of a file (which may be embedded), or else its buffers
+	data, format := shape.Data, shape.Format
+	if shape.File != "" {
+		var err error
+		if data, err = os.ReadFile(s.path(shape.File)); err != nil {
+			return nil, err
+		}
+		format = filepath.Ext(shape.File)
+	}
+	h := sha256.New()
+	if data != nil {
+		io.WriteString(h, strings.ToLower(format)+"\x00")
+		h.Write(data)
+	} else {
+		json.NewEncoder(h).Encode([]interface{}{shape.Vertices, shape.Normals, shape.Indices})
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	mesh, err := cache.mesh(key, func() (*Mesh, error) {
+		if data == nil {
+			return inlineMesh(shape)
+		}
+		read, err := meshReader(format)
+		if err != nil {
+			return nil, err
+		}
+		return read(bytes.NewReader(data), nil)
+	})
+	if err != nil {
+		if shape.File != "" {
+			return nil, fmt.Errorf("%s: %v", shape.File, err)
+		}
+		return nil, err
+	}
+	return mesh.withMaterial(mat), nil
+}
+
+// create a mesh from its (inline) buffers
+func inlineMesh(shape *sceneShape) (*Mesh, error) {
+	var vs sceneVecs
+	vertices := make([]Vec3, len(shape.Vertices))
+	for i, v := range shape.Vertices {
+		vertices[i] = vs.vec(fmt.Sprintf("Vertices[%d]", i), v, ZERO_V3)
+	}
+	var normals []Vec3
+	if shape.Normals != nil {
+		if len(shape.Normals) != len(vertices) {
+			return nil, fmt.Errorf("the mesh has %d normals for %d vertices", len(shape.Normals), len(vertices))
+		}
+		normals = make([]Vec3, len(shape.Normals))
+		for i, n := range shape.Normals {
+			normals[i] = vs.vec(fmt.Sprintf("Normals[%d]", i), n, ZERO_V3)
+		}
+	}
+	if vs.err != nil {
+		return nil, vs.err
+	}
+	if len(shape.Indices)%3 != 0 {
+		return nil, fmt.Errorf("the number of mesh indices is not a multiple of 3")
+	}
+	for _, index := range shape.Indices {
+		if int(index) >= len(vertices) {
+			return nil, fmt.Errorf("the mesh index %d is out of range", index)
+		}
+	}
+	return NewMesh(vertices, normals, nil, nil, shape.Indices, nil), nil
+}
+
+func (l *sceneLight) build() (Light, error) {
+	var vs sceneVecs
+	color := vs.vec("Color", l.Color, ONE_V3)
+	switch strings.ToLower(l.Type) {
+	case "point":
+		light := &PointLight{color, vs.vec("Position", l.Position, ZERO_V3), vs.vec("Attenuation", l.Attenuation, X_V3)}
+		return light, vs.err
+	case "directional":
+		light := &DirectionalLight{color, vs.vec("Direction", l.Direction, Y_V3)}
+		return light, vs.err
+	}
+	return nil, fmt.Errorf("unknown type %q", l.Type)
+}
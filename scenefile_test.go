@@ -0,0 +1,163 @@
+// contains tests for scenefile.go
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a small scene, of every type of shape (the mesh is "square.ply", in the directory of the scene)
+const testSceneJSON = `{
+	"Camera": {"Position": [0, 0, 6], "LookAt": [0, 0, 0], "Up": [0, 1, 0], "Width": 24, "Height": 16, "FovY": 60},
+	"Options": {"MaxDepth": 2, "SamplingFactor": 2, "Sampler": {"Type": "sobol", "Seed": 3}, "Filter": {"Type": "mitchell", "Radius": 2, "B": 0.33, "C": 0.33}},
+	"Materials": {
+		"red": {"Ambient": [0.3, 0.1, 0.1], "Diffuse": [0.6, 0.2, 0.2], "Specular": [0.3, 0.3, 0.3], "Shininess": 20},
+		"gold": {"Type": "pbr", "BaseColor": [1, 0.8, 0.3], "Metallic": 1, "Roughness": 0.4}
+	},
+	"Shapes": [
+		{"Type": "sphere", "Material": "red", "Center": [-1, 0, 0], "Radius": 1},
+		{"Type": "ellipsoid", "Material": "gold", "Center": [1.5, 0, 0], "Radii": [0.5, 1, 0.5], "Axis": [0, 0, 1], "Angle": 30},
+		{"Type": "quad", "Material": "red", "Points": [[-4, -2, 2], [4, -2, 2], [4, -2, -4], [-4, -2, -4]]},
+		{"Type": "group", "Shapes": [
+			{"Type": "instance", "Material": "gold", "Translation": [-1, 1, 0], "Rotation": [0, 45, 0], "Scale": [1, 1, 1],
+				"Shape": {"Type": "mesh", "File": "square.ply"}},
+			{"Type": "mesh", "Material": "red", "Vertices": [[0, 1, -1], [1, 1, -1], [1, 2, -1]], "Indices": [0, 1, 2]}
+		]}
+	],
+	"Lights": [
+		{"Type": "point", "Color": [0.6, 0.6, 0.6], "Position": [0, 5, 3], "Attenuation": [1, 0, 0]},
+		{"Type": "directional", "Color": [0.3, 0.3, 0.3], "Direction": [-1, 1, 1]}
+	]
+}`
+
+// write the test scene (and its mesh) to a temporary directory, returning the path of the scene
+func writeTestScene(t *testing.T) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "square.ply"), []byte(asciiSquarePLY), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "scene.json")
+	if err := os.WriteFile(path, []byte(testSceneJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSceneFile(t *testing.T) {
+	s, err := LoadSceneFile(writeTestScene(t))
+	if !assert(t, err == nil, "SceneFile: load error "+errString(err)) {
+		return
+	}
+	r, scene, lights, err := s.Build()
+	if !assert(t, err == nil, "SceneFile: build error "+errString(err)) {
+		return
+	}
+
+	assertEquals(t, 24, r.width, "SceneFile: width")
+	assertEquals(t, 16, r.height, "SceneFile: height")
+	assertEquals(t, 2, r.options.samplingFactor, "SceneFile: sampling factor")
+	_, isSobol := r.sampler.(*SobolSampler)
+	assert(t, isSobol, "SceneFile: sampler")
+	_, isMitchell := r.filter.(*MitchellFilter)
+	assert(t, isMitchell, "SceneFile: filter")
+
+	assertEquals(t, 4, len(scene), "SceneFile: number of shapes")
+	assertEquals(t, 2, len(lights), "SceneFile: number of lights")
+	assert(t, scene[0].GetMaterial() == scene[2].GetMaterial(), "SceneFile: materials are shared by name")
+	assert(t, scene[1].GetMaterial().pbr != nil, "SceneFile: pbr material")
+
+	group := scene[3].(*Group)
+	instance := group.shapes[0].(*Instance)
+	assertSquareMesh(t, instance.shape.(*Mesh), "SceneFile: mesh file")
+	assertEquals(t, 1, group.shapes[1].(*Mesh).NumTriangles(), "SceneFile: inline mesh")
+
+	// a ray along the axis of the sphere hits it
+	hit, res := scene[0].Intersect(Ray{Vec3{-1, 0, 5}, Z_V3.scale(-ONE)}, ZERO, INF)
+	assert(t, hit, "SceneFile: expected a hit on the sphere")
+	assertVec3Equals(t, Vec3{-1, 0, 1}, res.point, "SceneFile: sphere intersection")
+}
+
+// an embedded scene needs none of its files, and renders the same image
+func TestSceneFileEmbed(t *testing.T) {
+	s, err := LoadSceneFile(writeTestScene(t))
+	if !assert(t, err == nil, "SceneFile: load error "+errString(err)) {
+		return
+	}
+	r, scene, lights, _ := s.Build()
+	exp := r.Draw(scene, lights)
+
+	if !assert(t, s.Embed() == nil, "SceneFile: embed error") {
+		return
+	}
+	data, _ := json.Marshal(s)
+	embedded, err := ParseSceneFile(data, t.TempDir())
+	if !assert(t, err == nil, "SceneFile: parse error "+errString(err)) {
+		return
+	}
+	r, scene, lights, err = embedded.Build()
+	if assert(t, err == nil, "SceneFile: build error "+errString(err)) {
+		assert(t, string(exp.Pix) == string(r.Draw(scene, lights).Pix), "SceneFile: the embedded scene renders a different image")
+	}
+}
+
+func TestSceneFileErrors(t *testing.T) {
+	for _, c := range []struct{ replace, with string }{
+		{`"Width": 24`, `"Width": 0`},
+		{`"sobol"`, `"unknown"`},
+		{`"mitchell"`, `"unknown"`},
+		{`"Type": "pbr"`, `"Type": "unknown"`},
+		{`"Material": "red", "Center"`, `"Material": "blue", "Center"`},
+		{`"Type": "sphere"`, `"Type": "cone"`},
+		{`[[-4, -2, 2], `, `[`},
+		{`"Indices": [0, 1, 2]`, `"Indices": [0, 1, 3]`},
+		{`"Indices": [0, 1, 2]`, `"Indices": [0, 1]`},
+		{`"square.ply"`, `"missing.ply"`},
+		{`"Type": "point"`, `"Type": "spot"`},
+		{`"Lights": [`, `"Lights": {`},
+	} {
+		dir := filepath.Dir(writeTestScene(t))
+		s, err := ParseSceneFile([]byte(strings.Replace(testSceneJSON, c.replace, c.with, 1)), dir)
+		if err == nil {
+			_, _, _, err = s.Build()
+		}
+		assert(t, err != nil, "SceneFile: expected an error for "+c.with)
+	}
+}
+
+// a vector which is given, but without 3 components, is an error naming its field
+func TestSceneFileVectorErrors(t *testing.T) {
+	for _, c := range []struct{ replace, with, field string }{
+		{`"Position": [0, 0, 6]`, `"Position": [0, 0]`, "Position"},
+		{`"Up": [0, 1, 0]`, `"Up": []`, "Up"},
+		{`"Diffuse": [0.6, 0.2, 0.2]`, `"Diffuse": [0.6, 0.2, 0.2, 1]`, "Diffuse"},
+		{`"BaseColor": [1, 0.8, 0.3]`, `"BaseColor": [1]`, "BaseColor"},
+		{`"Center": [-1, 0, 0]`, `"Center": [1, 2]`, "Center"},
+		{`"Radii": [0.5, 1, 0.5]`, `"Radii": [0.5]`, "Radii"},
+		{`"Axis": [0, 0, 1]`, `"Axis": [0, 1]`, "Axis"},
+		{`[4, -2, 2]`, `[4, -2]`, "Points[1]"},
+		{`"Rotation": [0, 45, 0]`, `"Rotation": [45]`, "Rotation"},
+		{`"Translation": [-1, 1, 0]`, `"Translation": [-1, 1]`, "Translation"},
+		{`"Scale": [1, 1, 1]`, `"Scale": [2]`, "Scale"},
+		{`[1, 1, -1], [1, 2, -1]]`, `[1, 1, -1], [1, 2]]`, "Vertices[2]"},
+		{`"Attenuation": [1, 0, 0]`, `"Attenuation": [1, 0]`, "Attenuation"},
+		{`"Direction": [-1, 1, 1]`, `"Direction": [-1, 1]`, "Direction"},
+	} {
+		dir := filepath.Dir(writeTestScene(t))
+		s, err := ParseSceneFile([]byte(strings.Replace(testSceneJSON, c.replace, c.with, 1)), dir)
+		if err == nil {
+			_, _, _, err = s.Build()
+		}
+		assert(t, err != nil && strings.Contains(err.Error(), c.field), "SceneFile: expected an error naming "+c.field+" for "+c.with+", not "+errString(err))
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,87 @@
+// scenegraph.go: Contains shapes which are built from other shapes:
+//	- Group: a collection of shapes, which acts as a single shape
+//	- Instance: a shape placed into the scene by a transform
+
+package main
+
+// A Group is a collection of shapes which acts as a single shape,
+// so that (for example) a whole group can be instanced.
+type Group struct {
+	shapes []Shape
+}
+
+// NewGroup creates a group containing the given shapes.
+func NewGroup(shapes ...Shape) *Group {
+	return &Group{shapes}
+}
+
+// GetMaterial returns nil: the material is that of the child shape which was hit.
+func (g *Group) GetMaterial() *Material {
+	return nil
+}
+
+// Intersect finds the closest child shape which intersects the ray within (tMin, tMax).
+func (g *Group) Intersect(ray Ray, tMin, tMax entry) (bool, Intersection) {
+	return findClosestIntersection(ray, g.shapes, tMin, tMax)
+}
+
+// Occluded checks if any child shape is hit by the ray within (tMin, tMax).
+func (g *Group) Occluded(ray Ray, tMin, tMax entry) bool {
+	return isOccluded(ray, g.shapes, tMin, tMax)
+}
+
+// An Instance places a shape into the scene using a transform.
+// The shape is shared rather than copied, so many instances can
+// refer to the same geometry (which may itself be a group or an instance).
+type Instance struct {
+	trans Transform
+	shape Shape
+	mat   *Material // if not nil, overrides the material of the shape
+}
+
+// NewInstance creates an instance of shape, transformed from object space
+// into world space by trans. mat may be nil, to keep the shape's own material.
+func NewInstance(shape Shape, trans Transform, mat *Material) *Instance {
+	return &Instance{trans, shape, mat}
+}
+
+// GetMaterial returns the overriding material of the instance (or nil, if there is none).
+func (n *Instance) GetMaterial() *Material {
+	return n.mat
+}
+
+// transform the ray, and its interval (tMin, tMax), into object space
+// (where distances are multiplied by scale)
+func (n *Instance) objectRay(ray Ray, tMin, tMax entry) (objRay Ray, objMin, objMax, scale entry) {
+	objStart := n.trans.inverseTransformPoint(ray.start)
+	objDir := n.trans.inverseTransformVector(ray.direction)
+
+	// distances are scaled along with the direction, which is then normalized.
+	// hits within the error of transforming the start may be the surface which the ray started from.
+	scale = objDir.magnitude()
+	tErr := transformPointError(n.trans.inv, ray.start, ZERO_V3).magnitude()
+	return Ray{objStart, objDir.scale(ONE / scale)}, maxEntry(tMin*scale, tErr), tMax * scale, scale
+}
+
+// Intersect checks if the ray intersects the instanced shape within (tMin, tMax).
+func (n *Instance) Intersect(ray Ray, tMin, tMax entry) (hit bool, res Intersection) {
+	objRay, objMin, objMax, scale := n.objectRay(ray, tMin, tMax)
+	if hit, res = n.shape.Intersect(objRay, objMin, objMax); hit {
+
+		// transform the result back into world space:
+		pt := n.trans.transformPoint(res.point)
+		res.pError = transformPointError(n.trans.m, res.point, res.pError)
+		res.point, res.normal, res.dist = pt, n.trans.transformNormal(res.normal), res.dist/scale
+
+		if n.mat != nil {
+			res.shape = n
+		}
+	}
+	return
+}
+
+// Occluded checks if the ray hits the instanced shape anywhere within (tMin, tMax).
+func (n *Instance) Occluded(ray Ray, tMin, tMax entry) bool {
+	objRay, objMin, objMax, _ := n.objectRay(ray, tMin, tMax)
+	return n.shape.Occluded(objRay, objMin, objMax)
+}
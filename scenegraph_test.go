@@ -0,0 +1,78 @@
+// contains tests for scenegraph.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// an instance of a unit sphere, translated to (-3,1,2) and scaled by (2,0.5,3),
+// should behave exactly like the equivalent ellipsoid.
+func TestIntersectionForInstancedSphere(t *testing.T) {
+	tr, sc := Vec3{-3, 1, 2}, Vec3{2, 0.5, 3}
+	s := NewInstance(NewSphere(ONE, ZERO_V3, &Material{}), NewTRS(tr, IDENTITY_Q, sc), nil)
+	msg := "Instanced Ray-Sphere intersection "
+
+	// case 0: a ray which is missing the sphere
+	ray := Ray{ZERO_V3, X_V3}
+	assertIntersectionEquals(t, s, ray, false, nil, msg+"0")
+
+	// case 1: a ray which hits the sphere
+	ray = Ray{Vec3{-3, 1, -5}, Z_V3}
+	exp := &Intersection{point: Vec3{-3, 1, -1}, normal: Z_V3.scale(-ONE), dist: FOUR}
+	assertIntersectionEquals(t, s, ray, true, exp, msg+"1")
+
+	// case 2: a ray which hits the (scaled) side of the sphere
+	ray = Ray{Vec3{3, 1, 2}, X_V3.scale(-ONE)}
+	exp = &Intersection{point: Vec3{-1, 1, 2}, normal: X_V3, dist: FOUR}
+	assertIntersectionEquals(t, s, ray, true, exp, msg+"2")
+}
+
+// two instances sharing a group of spheres, one of them nested within another instance.
+func TestIntersectionForNestedInstances(t *testing.T) {
+	mat1, mat2 := &Material{shininess: 1}, &Material{shininess: 2}
+	group := NewGroup(NewSphere(ONE, ZERO_V3, mat1), NewSphere(ONE, Vec3{0, 0, 4}, mat1))
+	msg := "Nested Instance intersection "
+
+	// instance 1: the group, moved along the x-axis by 10
+	inst1 := NewInstance(group, Translation(Vec3{10, 0, 0}), nil)
+
+	// instance 2: the group, scaled by 2 then rotated 90 degrees about y (so +z maps to +x),
+	// and then moved along the y-axis by 10 by an outer instance (with another material).
+	inner := NewInstance(group, NewTRS(ZERO_V3, NewQuaternion(Y_V3, entry(90)), Vec3{2, 2, 2}), nil)
+	inst2 := NewInstance(inner, Translation(Vec3{0, 10, 0}), mat2)
+
+	// case 0: hitting the second sphere of instance 1
+	ray := Ray{Vec3{10, 5, 4}, Y_V3.scale(-ONE)}
+	exp := &Intersection{point: Vec3{10, 1, 4}, normal: Y_V3, dist: FOUR}
+	assertIntersectionEquals(t, inst1, ray, true, exp, msg+"0")
+	_, res := inst1.Intersect(ray, ZERO, INF)
+	assert(t, res.shape.GetMaterial() == mat1, msg+"0: expected the material of the sphere")
+
+	// case 1: hitting the second sphere of instance 2, now centered at (8,10,0) with radius 2
+	ray = Ray{Vec3{8, 10, 5}, Z_V3.scale(-ONE)}
+	exp = &Intersection{point: Vec3{8, 10, 2}, normal: Z_V3, dist: entry(3)}
+	assertIntersectionEquals(t, inst2, ray, true, exp, msg+"1")
+	_, res = inst2.Intersect(ray, ZERO, INF)
+	assert(t, res.shape.GetMaterial() == mat2, msg+"1: expected the material of the instance")
+
+	// case 2: the gap between the spheres of instance 2
+	ray = Ray{Vec3{4, 10, 5}, Z_V3.scale(-ONE)}
+	assertIntersectionEquals(t, inst2, ray, false, nil, msg+"2")
+
+	// case 3: the interval is in world distances (not scaled by the instances):
+	// the ray through both (scaled) spheres of instance 2 hits them at 2, 6, 10 and 14.
+	ray = Ray{Vec3{-4, 10, 0}, X_V3}
+	for i, tMin := range []entry{ZERO, 3, 7, 11} {
+		hit, res := inst2.Intersect(ray, tMin, INF)
+		assert(t, hit && !res.dist.neq(entry(2+4*i)), msg+fmt.Sprint("3: hit after ", tMin, " at ", res.dist))
+	}
+	assert(t, !inst2.Occluded(ray, 14.1, INF) && inst2.Occluded(ray, ZERO, 2.1) && !inst2.Occluded(ray, ZERO, 1.9), msg+"3: occlusion")
+
+	// case 4: a group reports the closest hit within the interval, from any of its shapes
+	ray = Ray{Vec3{0, 0, 10}, Z_V3.scale(-ONE)} // hits at 5, 7, 9 and 11
+	hit, res := group.Intersect(ray, entry(7.5), INF)
+	assert(t, hit && !res.dist.neq(entry(9)), msg+fmt.Sprint("4: group hit at ", res.dist))
+	assert(t, !group.Occluded(ray, entry(7.5), entry(8.5)), msg+"4: group occlusion")
+}
@@ -0,0 +1,413 @@
+// service.go: Contains the render service: an HTTP API which queues render jobs (of scene files),
+// renders a bounded number of them at a time, and serves their progress and images.
+//
+// The API:
+//	POST   /jobs                   queue a render of the scene (a SceneFile in json, whose meshes are inline or embedded):
+//	                               202 (Accepted) with the status of the job (and its url in the Location header),
+//	                               or 400 if the scene is invalid (or its image too large), or 503 if the queue is full
+//	GET    /jobs                   the status of every job, as a json list
+//	GET    /jobs/{id}              the status of the job, as json
+//	GET    /jobs/{id}/image.png    the image of a finished job, as a PNG (or 409 (Conflict) if it has not finished)
+//	GET    /jobs/{id}/image.exr    the image of a finished job, as an OpenEXR file of floats
+//	DELETE /jobs/{id}              cancel the job, if it is queued or rendering (or else forget it, and its image)
+// Jobs (and their images) are kept until they are deleted.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the states of a render job
+const (
+	jobQueued    = "queued"
+	jobRendering = "rendering"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// the largest scene accepted by the service, in bytes
+const maxSceneSize = 256 << 20
+
+// the largest image rendered by the service, in pixels
+const maxImagePixels = 4096 * 4096
+
+// ErrServiceClosed is the error of the jobs which were still queued or rendering when the service was closed.
+var ErrServiceClosed = errors.New("service: closed")
+
+// the error of a submission when the queue is full
+var errQueueFull = errors.New("service: the queue is full")
+
+// a render job of the service
+type renderJob struct {
+	id            int
+	width, height int
+	rt            *RayTracer
+	scene         []Shape
+	lights        []Light
+	ctx           context.Context
+	cancel        context.CancelFunc
+	progress      atomic.Int64 // the number of pixels rendered
+
+	// (guarded by the mutex of the service)
+	status                     string
+	err                        error
+	created, started, finished time.Time
+	fb                         *Framebuffer // the image (once done)
+}
+
+// the status of a job, as reported by the service
+type jobStatus struct {
+	ID            int
+	Status        string
+	Progress      float64 // the fraction of the pixels rendered
+	Width, Height int
+	QueuePosition int     `json:",omitempty"` // the position of the job in the queue (from 1), while queued
+	Elapsed       float64 // the time spent rendering, in seconds
+	Error         string  `json:",omitempty"`
+}
+
+// A RenderService renders scene files, submitted (as an http.Handler) by clients, with a bounded number of renders
+// at a time, and a bounded queue of jobs waiting to be rendered.
+type RenderService struct {
+	queueSize int
+	wg        sync.WaitGroup
+
+	mu     sync.Mutex
+	queued *sync.Cond   // signalled when a job is queued, or the service is closed
+	queue  []*renderJob // the jobs waiting to be rendered, in order (cancelled jobs are removed at once)
+	idle   int          // the number of renders waiting for a job
+	jobs   map[int]*renderJob
+	nextID int
+	closed bool
+}
+
+// NewRenderService creates a RenderService which renders up to concurrency jobs at a time,
+// and queues up to queueSize more.
+func NewRenderService(concurrency, queueSize int) *RenderService {
+	s := &RenderService{queueSize: maxInt(queueSize, 0), jobs: make(map[int]*renderJob), nextID: 1}
+	s.queued = sync.NewCond(&s.mu)
+	for i := 0; i < maxInt(concurrency, 1); i++ {
+		s.wg.Add(1)
+		go s.renderJobs()
+	}
+	return s
+}
+
+// Close cancels the jobs which are queued or rendering (with the error ErrServiceClosed), and waits for the renders to stop.
+func (s *RenderService) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, job := range s.jobs {
+			if job.status == jobQueued || job.status == jobRendering {
+				s.finish(job, nil, ErrServiceClosed)
+			}
+		}
+		s.queue = nil
+		s.queued.Broadcast()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// render the jobs of the queue, in turn (until the service is closed)
+func (s *RenderService) renderJobs() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.idle++
+			s.queued.Wait()
+			s.idle--
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		job := s.queue[0]
+		s.queue = s.queue[1:]
+		job.status, job.started = jobRendering, time.Now()
+		rt, scene, lights := job.rt.forRender(), job.scene, job.lights
+		s.mu.Unlock()
+
+		rt.progress = &job.progress
+		fb := NewFramebuffer(rt.width, rt.height)
+		_, err := rt.drawRegion(job.ctx, scene, lights, fb)
+
+		s.mu.Lock()
+		s.finish(job, fb, err)
+		s.mu.Unlock()
+	}
+}
+
+// finish a job (unless it is already finished), with its image, or the error which stopped it
+// (s.mu must be held)
+func (s *RenderService) finish(job *renderJob, fb *Framebuffer, err error) {
+	switch job.status {
+	case jobDone, jobFailed, jobCancelled:
+		return
+	}
+	job.finished = time.Now()
+	switch {
+	case err == nil:
+		job.status, job.fb = jobDone, fb
+	case errors.Is(err, context.Canceled):
+		job.status = jobCancelled
+	default:
+		job.status, job.err = jobFailed, err
+	}
+	job.cancel()
+	job.rt, job.scene, job.lights = nil, nil, nil
+}
+
+// Submit queues a render of the scene, returning the ID of the job
+// (or an error if the scene is invalid, or the queue is full).
+func (s *RenderService) Submit(sceneFile *SceneFile) (int, error) {
+	if c := &sceneFile.Camera; c.Width > maxImagePixels || c.Height > maxImagePixels || c.Width*c.Height > maxImagePixels {
+		return 0, fmt.Errorf("service: the image size %dx%d is more than %d pixels", c.Width, c.Height, maxImagePixels)
+	}
+	// (the queue is checked before the scene is built, and again after, as building a large scene takes a while)
+	if err := s.checkQueue(); err != nil {
+		return 0, err
+	}
+	err := sceneFile.walkShapes(func(shape *sceneShape) error {
+		if shape.File != "" {
+			return fmt.Errorf("service: the mesh file %q is not embedded in the scene", shape.File)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	rt, scene, lights, err := sceneFile.Build()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.queueError(); err != nil {
+		return 0, err
+	}
+	job := &renderJob{id: s.nextID, width: rt.width, height: rt.height, rt: rt, scene: scene, lights: lights, status: jobQueued, created: time.Now()}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	s.queue = append(s.queue, job)
+	s.queued.Signal()
+	s.jobs[job.id] = job
+	s.nextID++
+	return job.id, nil
+}
+
+// check that a job can be queued
+func (s *RenderService) checkQueue() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queueError()
+}
+
+// the error of queueing a job, if the service is closed or the queue is full (s.mu must be held)
+func (s *RenderService) queueError() error {
+	switch {
+	case s.closed:
+		return ErrServiceClosed
+	case len(s.queue) >= s.queueSize+s.idle: // (an idle render takes a job at once)
+		return errQueueFull
+	}
+	return nil
+}
+
+// Cancel cancels a job which is queued or rendering, or else forgets a finished job (returning false if there is no such job).
+func (s *RenderService) Cancel(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	switch job.status {
+	case jobQueued:
+		s.finish(job, nil, context.Canceled)
+		for i, queued := range s.queue {
+			if queued == job {
+				s.queue = append(s.queue[:i:i], s.queue[i+1:]...)
+				break
+			}
+		}
+	case jobRendering:
+		job.cancel() // (the render finishes the job, once it stops)
+	default:
+		delete(s.jobs, id)
+	}
+	return true
+}
+
+// the status of a job (s.mu must be held)
+func (s *RenderService) status(job *renderJob) jobStatus {
+	res := jobStatus{
+		ID: job.id, Status: job.status, Width: job.width, Height: job.height,
+		Progress: float64(job.progress.Load()) / float64(job.width*job.height),
+	}
+	switch job.status {
+	case jobQueued:
+		for i, queued := range s.queue {
+			if queued == job {
+				res.QueuePosition = i + 1
+			}
+		}
+	case jobRendering:
+		res.Elapsed = time.Since(job.started).Seconds()
+	default:
+		if !job.started.IsZero() {
+			res.Elapsed = job.finished.Sub(job.started).Seconds()
+		}
+	}
+	if job.err != nil {
+		res.Error = job.err.Error()
+	}
+	return res
+}
+
+// ServeHTTP handles the requests of clients.
+func (s *RenderService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	if path == "jobs" {
+		switch req.Method {
+		case http.MethodPost:
+			s.serveSubmit(w, req)
+		case http.MethodGet:
+			s.serveList(w)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// /jobs/{id}[/image.{format}]
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "jobs" {
+		http.NotFound(w, req)
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	switch {
+	case len(parts) == 2 && req.Method == http.MethodGet:
+		s.serveStatus(w, req, id)
+	case len(parts) == 2 && req.Method == http.MethodDelete:
+		if !s.Cancel(id) {
+			http.NotFound(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 3 && req.Method == http.MethodGet:
+		s.serveImage(w, req, id, parts[2])
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *RenderService) serveSubmit(w http.ResponseWriter, req *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxSceneSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	sceneFile, err := ParseSceneFile(data, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.Submit(sceneFile)
+	switch {
+	case err == errQueueFull || err == ErrServiceClosed:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%d", id))
+	s.writeStatus(w, id, http.StatusAccepted)
+}
+
+func (s *RenderService) serveList(w http.ResponseWriter) {
+	s.mu.Lock()
+	list := make([]jobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, s.status(job))
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *RenderService) serveStatus(w http.ResponseWriter, req *http.Request, id int) {
+	if !s.writeStatus(w, id, http.StatusOK) {
+		http.NotFound(w, req)
+	}
+}
+
+// write the status of a job (returning false if there is no such job)
+func (s *RenderService) writeStatus(w http.ResponseWriter, id, code int) bool {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var status jobStatus
+	if ok {
+		status = s.status(job)
+	}
+	s.mu.Unlock()
+	if ok {
+		writeJSON(w, code, status)
+	}
+	return ok
+}
+
+func (s *RenderService) serveImage(w http.ResponseWriter, req *http.Request, id int, name string) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	var fb *Framebuffer
+	var status string
+	if ok {
+		fb, status = job.fb, job.status
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		http.NotFound(w, req)
+	case fb == nil:
+		http.Error(w, "the job is "+status, http.StatusConflict)
+	case name == "image.png":
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, fb.Image())
+	case name == "image.exr":
+		w.Header().Set("Content-Type", "image/x-exr")
+		WriteEXR(w, fb.width, fb.height, fb.Pixels())
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// write a response of json
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
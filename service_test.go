@@ -0,0 +1,196 @@
+// contains tests for service.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the test scene (with its mesh inline, as the service has no files), at the given size and sampling factor
+func serviceTestScene(width, height, samplingFactor int) string {
+	s := strings.Replace(testSceneJSON, `{"Type": "mesh", "File": "square.ply"}`,
+		`{"Type": "mesh", "Vertices": [[0, 0, 0], [1, 0, 0], [1, 1, 0], [0, 1, 0]], "Indices": [0, 1, 2, 0, 2, 3]}`, 1)
+	s = strings.Replace(s, `"Width": 24, "Height": 16`, fmt.Sprintf(`"Width": %d, "Height": %d`, width, height), 1)
+	return strings.Replace(s, `"SamplingFactor": 2`, fmt.Sprintf(`"SamplingFactor": %d`, samplingFactor), 1)
+}
+
+// make a request of the service, returning the status code and the body of the response
+func serviceRequest(t *testing.T, method, url, body string) (int, []byte) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res.StatusCode, data
+}
+
+// submit a job to the service, returning its status
+func submitJob(t *testing.T, url, scene string) jobStatus {
+	code, data := serviceRequest(t, http.MethodPost, url+"/jobs", scene)
+	var status jobStatus
+	if assertEquals(t, http.StatusAccepted, code, "Service: submit "+string(data)) {
+		json.Unmarshal(data, &status)
+	}
+	return status
+}
+
+// poll the status of a job until it is in one of the given states
+func waitForJob(t *testing.T, url string, id int, states ...string) jobStatus {
+	deadline := time.Now().Add(time.Minute)
+	for {
+		var status jobStatus
+		_, data := serviceRequest(t, http.MethodGet, fmt.Sprintf("%s/jobs/%d", url, id), "")
+		json.Unmarshal(data, &status)
+		for _, s := range states {
+			if status.Status == s {
+				return status
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Service: job %d is %s, not %v", id, status.Status, states)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// a job renders the same image as a local render, as a PNG or an OpenEXR file
+func TestServiceRender(t *testing.T) {
+	service := NewRenderService(2, 4)
+	defer service.Close()
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	scene := serviceTestScene(24, 16, 2)
+	job := submitJob(t, server.URL, scene)
+	assertEquals(t, 1, job.ID, "Service: job ID")
+	assertEquals(t, 24, job.Width, "Service: job width")
+	status := waitForJob(t, server.URL, job.ID, jobDone, jobFailed)
+	assertEquals(t, jobDone, status.Status, "Service: job status "+status.Error)
+	assertEquals(t, 1.0, status.Progress, "Service: job progress")
+
+	s, _ := ParseSceneFile([]byte(scene), "")
+	r, shapes, lights, _ := s.Build()
+	exp := r.Draw(shapes, lights)
+
+	code, data := serviceRequest(t, http.MethodGet, fmt.Sprintf("%s/jobs/%d/image.png", server.URL, job.ID), "")
+	assertEquals(t, http.StatusOK, code, "Service: PNG status")
+	img, err := png.Decode(bytes.NewReader(data))
+	if assert(t, err == nil, "Service: PNG decode") {
+		act := NewOutputImage(24, 16)
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 24; x++ {
+				act.Set(x, y, img.At(x, y))
+			}
+		}
+		assert(t, string(exp.Pix) == string(act.Pix), "Service: the image differs from a local render")
+	}
+
+	code, data = serviceRequest(t, http.MethodGet, fmt.Sprintf("%s/jobs/%d/image.exr", server.URL, job.ID), "")
+	assertEquals(t, http.StatusOK, code, "Service: EXR status")
+	_, rows := readTestEXR(t, data)
+	assertEquals(t, 16, len(rows), "Service: EXR rows")
+
+	// deleting a finished job forgets it
+	code, _ = serviceRequest(t, http.MethodDelete, fmt.Sprintf("%s/jobs/%d", server.URL, job.ID), "")
+	assertEquals(t, http.StatusNoContent, code, "Service: delete status")
+	code, _ = serviceRequest(t, http.MethodGet, fmt.Sprintf("%s/jobs/%d", server.URL, job.ID), "")
+	assertEquals(t, http.StatusNotFound, code, "Service: deleted job status")
+}
+
+// jobs wait in a bounded queue, and can be cancelled while queued or rendering
+func TestServiceQueue(t *testing.T) {
+	service := NewRenderService(1, 2)
+	defer service.Close()
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	slow := serviceTestScene(400, 300, 8)
+	first := submitJob(t, server.URL, slow)
+	waitForJob(t, server.URL, first.ID, jobRendering)
+	second, third := submitJob(t, server.URL, slow), submitJob(t, server.URL, slow)
+	assertEquals(t, jobQueued, second.Status, "Service: second job status")
+	assertEquals(t, 1, second.QueuePosition, "Service: queue position")
+	assertEquals(t, 2, third.QueuePosition, "Service: queue position")
+
+	code, _ := serviceRequest(t, http.MethodPost, server.URL+"/jobs", slow)
+	assertEquals(t, http.StatusServiceUnavailable, code, "Service: submit to a full queue")
+	code, _ = serviceRequest(t, http.MethodGet, fmt.Sprintf("%s/jobs/%d/image.png", server.URL, second.ID), "")
+	assertEquals(t, http.StatusConflict, code, "Service: image of a queued job")
+
+	var list []jobStatus
+	_, data := serviceRequest(t, http.MethodGet, server.URL+"/jobs", "")
+	json.Unmarshal(data, &list)
+	assertEquals(t, 3, len(list), "Service: number of jobs")
+
+	// cancelled jobs leave the queue at once (while the first is still rendering)
+	for _, id := range []int{second.ID, third.ID} {
+		code, _ = serviceRequest(t, http.MethodDelete, fmt.Sprintf("%s/jobs/%d", server.URL, id), "")
+		assertEquals(t, http.StatusNoContent, code, "Service: cancel status")
+		assertEquals(t, jobCancelled, waitForJob(t, server.URL, id, jobCancelled).Status, fmt.Sprint("Service: status of cancelled job ", id))
+	}
+	fourth := submitJob(t, server.URL, serviceTestScene(8, 8, 1))
+	assertEquals(t, 1, fourth.QueuePosition, "Service: queue position after cancelling")
+
+	code, _ = serviceRequest(t, http.MethodDelete, fmt.Sprintf("%s/jobs/%d", server.URL, first.ID), "")
+	assertEquals(t, http.StatusNoContent, code, "Service: cancel status")
+	status := waitForJob(t, server.URL, first.ID, jobCancelled, jobDone)
+	assertEquals(t, jobCancelled, status.Status, "Service: status of the cancelled rendering job")
+	assertEquals(t, jobDone, waitForJob(t, server.URL, fourth.ID, jobDone, jobFailed).Status, "Service: job after cancelling")
+}
+
+// a full queue, or an image too large, is refused before the scene is built
+func TestServiceSubmitLimits(t *testing.T) {
+	service := NewRenderService(1, 0)
+	defer service.Close()
+
+	// (a scene which cannot be built, so that only the checks before building can refuse it)
+	s, _ := ParseSceneFile([]byte(strings.Replace(serviceTestScene(8, 8, 1), `"sobol"`, `"unknown"`, 1)), "")
+	s.Camera.Width, s.Camera.Height = 1<<20, 1<<20
+	_, err := service.Submit(s)
+	assert(t, err != nil && strings.Contains(err.Error(), "image size"), "Service: expected an error for a huge image, not "+errString(err))
+
+	// an idle render takes a job at once, even without a queue
+	s, _ = ParseSceneFile([]byte(serviceTestScene(400, 300, 8)), "")
+	for deadline := time.Now().Add(time.Minute); ; time.Sleep(time.Millisecond) {
+		if _, err = service.Submit(s); err == nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	assert(t, err == nil, "Service: submit to an idle render "+errString(err))
+	s, _ = ParseSceneFile([]byte(strings.Replace(serviceTestScene(8, 8, 1), `"sobol"`, `"unknown"`, 1)), "")
+	_, err = service.Submit(s)
+	assertEquals(t, errQueueFull, err, "Service: submit an invalid scene to a full queue")
+}
+
+func TestServiceErrors(t *testing.T) {
+	service := NewRenderService(1, 1)
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	for _, scene := range []string{"{", testSceneJSON, strings.Replace(serviceTestScene(8, 8, 1), `"sobol"`, `"unknown"`, 1)} {
+		code, _ := serviceRequest(t, http.MethodPost, server.URL+"/jobs", scene)
+		assertEquals(t, http.StatusBadRequest, code, "Service: submit an invalid scene")
+	}
+	for _, path := range []string{"/jobs/1", "/jobs/x", "/jobs/1/image.png", "/other"} {
+		code, _ := serviceRequest(t, http.MethodGet, server.URL+path, "")
+		assertEquals(t, http.StatusNotFound, code, "Service: get "+path)
+	}
+	code, _ := serviceRequest(t, http.MethodPut, server.URL+"/jobs", "")
+	assertEquals(t, http.StatusMethodNotAllowed, code, "Service: put /jobs")
+
+	// a closed service refuses jobs
+	service.Close()
+	code, _ = serviceRequest(t, http.MethodPost, server.URL+"/jobs", serviceTestScene(8, 8, 1))
+	assertEquals(t, http.StatusServiceUnavailable, code, "Service: submit to a closed service")
+}
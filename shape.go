@@ -5,29 +5,37 @@ package main
 // Intersection holds the results of an intersection test
 type Intersection struct {
 	point, normal Vec3  // Point of intersection and the normal
-	dist          entry // distance from ray-origin to intersection point
+	pError        Vec3  // a bound on the floating-point error of point (in each axis)
+	dist          entry // distance from ray-origin to intersection point (along the unit ray direction)
+	shape         Shape // the shape which was hit (which provides the material)
+	index         int   // the primitive within the shape which was hit (e.g. the triangle of a mesh)
+	uv            Vec3  // the surface (e.g. texture) co-ordinates of the point, if any
+	color         Vec3  // the surface colour at the point (e.g. from vertex colours), if hasColor
+	hasColor      bool
 }
 
 // A Shape is a primitive in 3D space. 
+// Hits are only reported within the interval (tMin, tMax) of distances along the ray.
 type Shape interface {
 	GetMaterial() *Material
-	Intersect(ray *Ray) (bool, *Intersection)
+	Intersect(ray Ray, tMin, tMax entry) (bool, Intersection) // the closest hit
+	Occluded(ray Ray, tMin, tMax entry) bool                  // whether there is any hit (which may stop at the first one found)
 }
 
-func rotate(axis *Vec3, angle entry) *Mat3 {
+func rotate(axis Vec3, angle entry) Mat3 {
 	x, y, z := axis[cX], axis[cY], axis[cZ]
 
 	// using Rodrequiz formula
 	cosT, sinT := cos(angle), sin(angle)
-	p1 := &Mat3{x * x, x * y, x * z, x * y, y * y, y * z, x * z, y * z, z * z}
-	p2 := &Mat3{0, -z, y, z, 0, -x, -y, x, 0}
+	p1 := Mat3{x * x, x * y, x * z, x * y, y * y, y * z, x * z, y * z, z * z}
+	p2 := Mat3{0, -z, y, z, 0, -x, -y, x, 0}
 	return IDENTITY_M3.scale(cosT).plus(p1.scale(ONE - cosT)).plus(p2.scale(sinT))
 }
 
-func transform(scale, pos, rotAxis *Vec3, angle entry) *Mat4 {
+func transform(scale, pos, rotAxis Vec3, angle entry) Mat4 {
 	sx, sy, sz := scale[cX], scale[cY], scale[cZ]
 	rot := rotate(rotAxis, angle)
-	return &Mat4{
+	return Mat4{
 		rot[0] * sx, rot[1] * sx, rot[2] * sx, pos[cX],
 		rot[3] * sy, rot[4] * sy, rot[5] * sy, pos[cY],
 		rot[6] * sz, rot[7] * sz, rot[8] * sz, pos[cZ],
@@ -35,12 +43,12 @@ func transform(scale, pos, rotAxis *Vec3, angle entry) *Mat4 {
 	}
 }
 
-func toV4(v3 *Vec3, w entry) *Vec4 {
-	return &Vec4{v3[cX], v3[cY], v3[cZ], w}
+func toV4(v3 Vec3, w entry) Vec4 {
+	return Vec4{v3[cX], v3[cY], v3[cZ], w}
 }
 
-func toV3(v4 *Vec4) *Vec3 {
-	return &Vec3{v4[cX], v4[cY], v4[cZ]}
+func toV3(v4 Vec4) Vec3 {
+	return Vec3{v4[cX], v4[cY], v4[cZ]}
 }
 
 // Sphere implementation of Shape
@@ -51,23 +59,23 @@ type Sphere struct {
 }
 
 // NewSphere creates a Sphere at a given point, with a given radius and material
-func NewSphere(radius entry, center *Vec3, mat *Material) *Sphere {
-	radiusVec := &Vec3{radius, radius, radius}
+func NewSphere(radius entry, center Vec3, mat *Material) *Sphere {
+	radiusVec := Vec3{radius, radius, radius}
 	return NewEllipsoid(radiusVec, center, mat)
 }
 
 // NewEllipsoid creates a Ellipsoid (scaled sphere) at a point
-func NewEllipsoid(radius, center *Vec3, mat *Material) *Sphere {
-	return NewRotatedEllipsoid(radius, center, &X_V3, ZERO, mat)
+func NewEllipsoid(radius, center Vec3, mat *Material) *Sphere {
+	return NewRotatedEllipsoid(radius, center, X_V3, ZERO, mat)
 }
 
 // NewRotatedEllipsoid creates an Ellipsoid with a rotation applied
 // Parameters: radius-{x,y,z} ; center-{x,y,z} ; rotation-axis-{x,y,z}, rotation-angle (degrees)
-func NewRotatedEllipsoid(radius, center, rot *Vec3, angle entry, mat *Material) *Sphere {
+func NewRotatedEllipsoid(radius, center, rot Vec3, angle entry, mat *Material) *Sphere {
 	trans := transform(radius, center, rot, angle)
 	transInv := trans.inverse()
 	transInvTr := transInv.transpose()
-	return &Sphere{*trans, *transInv, *transInvTr, mat}
+	return &Sphere{trans, transInv, transInvTr, mat}
 }
 
 // GetMaterial returns the material of the surface of the sphere.
@@ -75,16 +83,15 @@ func (s *Sphere) GetMaterial() *Material {
 	return s.mat
 }
 
-// Intersect checks if the ray intersects the sphere.
-func (s *Sphere) Intersect(ray *Ray) (hit bool, res *Intersection) {
-	// by default: no intersection:
-	hit, res = false, nil
+// find the closest root of the ray-sphere quadratic within (tMin, tMax), i.e. the distance along the ray
+// to the intersection, along with the ray in the transformed space of the unit sphere.
+func (s *Sphere) closestRoot(ray Ray, tMin, tMax entry) (hit bool, x entry, invStart, invDir Vec4) {
 
 	// transform ray by the sphere's inverse transform,
 	// which allows comparison against a unit sphere.
-	invStart := s.transInv.timesVec(toV4(&(ray.start), ONE))
+	invStart = s.transInv.timesVec(toV4(ray.start, ONE))
 	invStart[cW] = ZERO // correcting for translation.
-	invDir := s.transInv.timesVec(toV4(&(ray.direction), ZERO))
+	invDir = s.transInv.timesVec(toV4(ray.direction, ZERO))
 
 	// ray-sphere intersection:
 	// a quadratic ax^2 + bx + c = 0
@@ -95,34 +102,53 @@ func (s *Sphere) Intersect(ray *Ray) (hit bool, res *Intersection) {
 	// check det >= 0
 	if det := b*b - FOUR*a*c; det >= 0 {
 
-		// compute roots:
+		// compute roots (x2 <= x1):
 		sqrtDet, twoA := sqrt(det), TWO*a
 		x1 := (-b + sqrtDet) / twoA
 		x2 := (-b - sqrtDet) / twoA
 
-		// check that at least one root is positive:
-		if (x1 >= 0) || (x2 >= 0) {
+		// roots within the error of transforming the ray start may be the surface which the ray started from,
+		// so they are excluded from the interval:
+		tErr := transformPointError(s.transInv, ray.start, ZERO_V3).magnitude() / toV3(invDir).magnitude()
+		tMin = maxEntry(tMin, tErr)
 
-			// if both roots are positive, pick least root (i.e. closest intersection)
-			if (x1 < 0) || (x2 > 0 && x2 < x1) {
-				x1 = x2
-			}
+		// pick least root within the interval (i.e. closest intersection)
+		if x2 > tMin && x2 < tMax {
+			return true, x2, invStart, invDir
+		}
+		if x1 > tMin && x1 < tMax {
+			return true, x1, invStart, invDir
+		}
+	}
+	return
+}
 
-			// compute point of intersection (in transformed space)
-			invPt := invStart.plus(invDir.scale(x1))
+// Intersect checks if the ray intersects the sphere within (tMin, tMax).
+func (s *Sphere) Intersect(ray Ray, tMin, tMax entry) (hit bool, res Intersection) {
+	hit, x, invStart, invDir := s.closestRoot(ray, tMin, tMax)
+	if !hit {
+		return
+	}
 
-			invPt[cW] = ZERO // correcting for translation.
-			normal := toV3(s.transInvTr.timesVec(invPt)).direction()
+	// compute point of intersection (in transformed space),
+	// reprojected onto the unit sphere, which bounds its error by that of normalizing it.
+	invPt := toV4(toV3(invStart.plus(invDir.scale(x))).direction(), ZERO)
+	invPtError := absVec(toV3(invPt)).scale(gamma(5))
 
-			invPt[cW] = ONE                     // correcting for translation.
-			pt := toV3(s.trans.timesVec(invPt)) // convert back into normal co-ords
-			dist := pt.distanceTo(&(ray.start))
+	invPt[cW] = ZERO // correcting for translation.
+	normal := toV3(s.transInvTr.timesVec(invPt)).direction()
 
-			hit, res = true, &Intersection{*pt, *normal, dist}
-		}
+	invPt[cW] = ONE                     // correcting for translation.
+	pt := toV3(s.trans.timesVec(invPt)) // convert back into normal co-ords
+	pError := transformPointError(s.trans, toV3(invPt), invPtError)
 
-	}
-	return
+	return true, Intersection{point: pt, normal: normal, pError: pError, dist: x, shape: s}
+}
+
+// Occluded checks if the ray hits the sphere anywhere within (tMin, tMax).
+func (s *Sphere) Occluded(ray Ray, tMin, tMax entry) bool {
+	hit, _, _, _ := s.closestRoot(ray, tMin, tMax)
+	return hit
 }
 
 // Implementation of Quad
@@ -133,12 +159,12 @@ type Quad struct {
 }
 
 // combine the 3 vectors into a matrix
-func combine(a, b, c *Vec3) *Mat3 {
-	return &Mat3{ a[0], b[0], c[0], a[1], b[1], c[1], a[2], b[2], c[2] }
+func combine(a, b, c Vec3) Mat3 {
+	return Mat3{ a[0], b[0], c[0], a[1], b[1], c[1], a[2], b[2], c[2] }
 }
 
 // NewQuad creates a quad, e.g. a rectangular truncated plane.
-func NewQuad(ptA, ptB, ptC, ptD *Vec3, mat *Material) *Quad {
+func NewQuad(ptA, ptB, ptC, ptD Vec3, mat *Material) *Quad {
 
 	// normalize the U and V direction
 	uN, vN := ptB.minus(ptA).direction(), ptD.minus(ptA).direction()
@@ -160,7 +186,7 @@ func NewQuad(ptA, ptB, ptC, ptD *Vec3, mat *Material) *Quad {
 	sideB := Vec3{ cuv[cY], blen - cuv[cX], ZERO }
 	sideL := cuv[cY] * blen
 	
-	return &Quad{ *uN, *vN, *normal, *ptA, topB, sideB, topL, sideL, mat }
+	return &Quad{ uN, vN, normal, ptA, topB, sideB, topL, sideL, mat }
 }
 
 // GetMaterial returns the material of the quad. 
@@ -169,26 +195,39 @@ func (q *Quad) GetMaterial() *Material {
 }
 
 // compute the intersection matrix: [ u | v | -raydirection ]
-func computeIntersection(a, b, c *Vec3) *Mat3 {
-	return &Mat3{ a[0], b[0], -c[0], a[1], b[1], -c[1], a[2], b[2], -c[2] }
+func computeIntersection(a, b, c Vec3) Mat3 {
+	return Mat3{ a[0], b[0], -c[0], a[1], b[1], -c[1], a[2], b[2], -c[2] }
 }
 
-// Intersect checks if the ray intersects the quad.
-func (q *Quad) Intersect(ray *Ray) (hit bool, res *Intersection) {
-
-	// by default: no intersection
-	hit, res = false, nil
+// find where the ray hits the quad within (tMin, tMax), as the
+// co-ordinates along vecU and vecV, and the distance along the ray.
+func (q *Quad) hitCoordinates(ray Ray, tMin, tMax entry) (hit bool, pqt Vec3) {
 
-	m := computeIntersection(&q.vecU, &q.vecV, &ray.direction)
+	m := computeIntersection(q.vecU, q.vecV, ray.direction)
 
 	if m.determinant() != 0 {
-		pqt := m.inverse().timesVec(ray.start.minus(&q.origin))
-		if (pqt[0] > 0) && (pqt[1] > 0) && (pqt[2] > 0) && (pqt.dot(&q.topB) <= q.topL) && (pqt.dot(&q.sideB) <= q.sideL) {
-			pt := ray.start.plus(ray.direction.scale(pqt[2]))
-			hit, res = true, &Intersection{*pt, q.normal, pqt[2]}
-		}
+		pqt = m.inverse().timesVec(ray.start.minus(q.origin))
+		hit = (pqt[0] > 0) && (pqt[1] > 0) && (pqt[2] > tMin) && (pqt[2] < tMax) && (pqt.dot(q.topB) <= q.topL) && (pqt.dot(q.sideB) <= q.sideL)
 	}
 	return
 }
 
+// Intersect checks if the ray intersects the quad within (tMin, tMax).
+func (q *Quad) Intersect(ray Ray, tMin, tMax entry) (hit bool, res Intersection) {
+	if hit, pqt := q.hitCoordinates(ray, tMin, tMax); hit {
+		// compute the point from the plane co-ordinates, so that its error is relative to the quad (not the ray):
+		u, v := q.vecU.scale(pqt[0]), q.vecV.scale(pqt[1])
+		pt := q.origin.plus(u).plus(v)
+		pError := absVec(q.origin).plus(absVec(u)).plus(absVec(v)).scale(gamma(3))
+		return true, Intersection{point: pt, normal: q.normal, pError: pError, dist: pqt[2], shape: q}
+	}
+	return
+}
+
+// Occluded checks if the ray hits the quad anywhere within (tMin, tMax).
+func (q *Quad) Occluded(ray Ray, tMin, tMax entry) bool {
+	hit, _ := q.hitCoordinates(ray, tMin, tMax)
+	return hit
+}
+
 // TODO: implementation of Triangle.
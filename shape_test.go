@@ -8,131 +8,180 @@ import (
 )
 
 func TestIntersectionForUnitSphere(t *testing.T) {
-	s := NewSphere(ONE, &ZERO_V3, &Material{}) // unit sphere, centered at origin
+	// (case 4 starts ~10 away)
+	tol := ROUNDING_TOLERANCE
+	s := NewSphere(ONE, ZERO_V3, &Material{}) // unit sphere, centered at origin
 	msg := "Ray-Sphere intersection "
 
 	// case 0: a ray from origin passing through x-axis:
-	ray := &Ray{ZERO_V3, X_V3}
-	exp := &Intersection{X_V3, X_V3, ONE}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"0")
+	ray := Ray{ZERO_V3, X_V3}
+	exp := &Intersection{point: X_V3, normal: X_V3, dist: ONE}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"0")
 
 	// case 1: a ray from outside the sphere passing through the sphere via y-axis:
-	ray = &Ray{*Y_V3.scale(-FOUR), Y_V3}
-	exp = &Intersection{*Y_V3.scale(-ONE), *Y_V3.scale(-ONE), entry(3)}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"1")
+	ray = Ray{Y_V3.scale(-FOUR), Y_V3}
+	exp = &Intersection{point: Y_V3.scale(-ONE), normal: Y_V3.scale(-ONE), dist: entry(3)}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"1")
 
 	// case 2: a ray just passing through the sphere at (0,1,0):
-	ray = &Ray{Vec3{0, 1, -2}, Z_V3}
-	exp = &Intersection{Y_V3, Y_V3, TWO}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"2")
+	ray = Ray{Vec3{0, 1, -2}, Z_V3}
+	exp = &Intersection{point: Y_V3, normal: Y_V3, dist: TWO}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"2")
 
 	// case 3: a ray in dir (1,1,1) missing the sphere
-	dir := &Vec3{1, 1, 1}
-	ray = &Ray{Vec3{2, 1, 2}, *(dir.direction())}
-	assertIntersectionEquals(t, s, ray, false, nil, msg+"3")
+	dir := Vec3{1, 1, 1}
+	ray = Ray{Vec3{2, 1, 2}, dir.direction()}
+	assertIntersectionEqualsTol(t, s, ray, false, nil, tol, msg+"3")
 
 	// case 4: a ray in dir (-1,3,-5) hitting the sphere at (0, 0.6, 0.8):
-	dir = &Vec3{-1, 3, -5}
-	ray = &Ray{Vec3{1.7, -4.5, 9.3}, *(dir.direction())}
+	dir = Vec3{-1, 3, -5}
+	ray = Ray{Vec3{1.7, -4.5, 9.3}, dir.direction()}
 	hit := Vec3{0, 0.6, 0.8}
-	exp = &Intersection{hit, hit, entry(1.7) * sqrt(entry(35))}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"4")
+	exp = &Intersection{point: hit, normal: hit, dist: entry(1.7) * sqrt(entry(35))}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"4")
 }
 
 // Same as TestIntersectionForUnitSphere, except the sphere is scaled by (0.5, 1.25, 2.5)
 func TestIntersectionForScaledSphere(t *testing.T) {
-	sc := &Vec3{0.5, 1.25, 2.5}
+	// (case 2 is tangent)
+	tol := GRAZING_TOLERANCE
+	sc := Vec3{0.5, 1.25, 2.5}
 	msg := "Scaled Ray-Sphere intersection "
 
 	// unit sphere, centered at origin
-	s1 := NewEllipsoid(sc, &ZERO_V3, &Material{})
+	s1 := NewEllipsoid(sc, ZERO_V3, &Material{})
 
 	// case 0: a ray from origin passing through x-axis:
-	ray := &Ray{ZERO_V3, X_V3}
-	exp := &Intersection{*X_V3.scale(sc[cX]), X_V3, ONE * sc[cX]}
-	assertIntersectionEquals(t, s1, ray, true, exp, msg+"0.1")
+	ray := Ray{ZERO_V3, X_V3}
+	exp := &Intersection{point: X_V3.scale(sc[cX]), normal: X_V3, dist: ONE * sc[cX]}
+	assertIntersectionEqualsTol(t, s1, ray, true, exp, tol, msg+"0.1")
 
 	// case 1: a ray from outside the sphere passing through the sphere via y-axis:
-	ray = &Ray{*Y_V3.scale(-FOUR), Y_V3}
-	exp = &Intersection{*Y_V3.scale(-ONE).scale(sc[cY]), *Y_V3.scale(-ONE), entry(2.75)}
-	assertIntersectionEquals(t, s1, ray, true, exp, msg+"1.1")
+	ray = Ray{Y_V3.scale(-FOUR), Y_V3}
+	exp = &Intersection{point: Y_V3.scale(-ONE).scale(sc[cY]), normal: Y_V3.scale(-ONE), dist: entry(2.75)}
+	assertIntersectionEqualsTol(t, s1, ray, true, exp, tol, msg+"1.1")
 
 	// case 2: a ray just passing through the sphere at (0,1,0):
-	ray = &Ray{Vec3{0, 1.25, -4}, Z_V3}
-	exp = &Intersection{*Y_V3.scale(sc[cY]), Y_V3, FOUR}
-	assertIntersectionEquals(t, s1, ray, true, exp, msg+"2.1")
+	ray = Ray{Vec3{0, 1.25, -4}, Z_V3}
+	exp = &Intersection{point: Y_V3.scale(sc[cY]), normal: Y_V3, dist: FOUR}
+	assertIntersectionEqualsTol(t, s1, ray, true, exp, tol, msg+"2.1")
 
 	// case 3: a ray in dir (1,1,1) missing the sphere
-	dir := &Vec3{1, 1, 1}
-	ray = &Ray{Vec3{2, 1, 2}, *(dir.direction())}
-	assertIntersectionEquals(t, s1, ray, false, nil, msg+"3.1")
+	dir := Vec3{1, 1, 1}
+	ray = Ray{Vec3{2, 1, 2}, dir.direction()}
+	assertIntersectionEqualsTol(t, s1, ray, false, nil, tol, msg+"3.1")
 
 	// case 4: skipped.
 }
 
 // same cases as TestIntersectionForUnitSphere, except for translation by (-10, 0.44, -2.5)
 func TestIntersectionForTranslatedUnitSphere(t *testing.T) {
-	tr := &Vec3{-10, 0.44, -2.5}
+	// (case 4 starts ~10 away)
+	tol := ROUNDING_TOLERANCE
+	tr := Vec3{-10, 0.44, -2.5}
 	s := NewSphere(ONE, tr, &Material{}) // unit sphere, centered at origin
 	msg := "Translated Ray-Sphere intersection "
 
 	// case 0: a ray from origin passing through x-axis:
-	ray := &Ray{*ZERO_V3.plus(tr), X_V3}
-	exp := &Intersection{*X_V3.plus(tr), X_V3, ONE}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"0")
+	ray := Ray{ZERO_V3.plus(tr), X_V3}
+	exp := &Intersection{point: X_V3.plus(tr), normal: X_V3, dist: ONE}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"0")
 
 	// case 1: a ray from outside the sphere passing through the sphere via y-axis:
-	ray = &Ray{*Y_V3.scale(-FOUR).plus(tr), Y_V3}
-	exp = &Intersection{*Y_V3.scale(-ONE).plus(tr), *Y_V3.scale(-ONE), entry(3)}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"1")
+	ray = Ray{Y_V3.scale(-FOUR).plus(tr), Y_V3}
+	exp = &Intersection{point: Y_V3.scale(-ONE).plus(tr), normal: Y_V3.scale(-ONE), dist: entry(3)}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"1")
 
 	// case 2: a ray just passing through the sphere at (0,1,0):
-	src := &Vec3{0, 1, -2}
-	ray = &Ray{*src.plus(tr), Z_V3}
-	exp = &Intersection{*Y_V3.plus(tr), Y_V3, TWO}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"2")
+	src := Vec3{0, 1, -2}
+	ray = Ray{src.plus(tr), Z_V3}
+	exp = &Intersection{point: Y_V3.plus(tr), normal: Y_V3, dist: TWO}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"2")
 
 	// case 3: a ray in dir (1,1,1) missing the sphere
-	src = &Vec3{2, 1, 2}
-	dir := &Vec3{1, 1, 1}
-	ray = &Ray{*src.plus(tr), *(dir.direction())}
-	assertIntersectionEquals(t, s, ray, false, nil, msg+"3")
+	src = Vec3{2, 1, 2}
+	dir := Vec3{1, 1, 1}
+	ray = Ray{src.plus(tr), dir.direction()}
+	assertIntersectionEqualsTol(t, s, ray, false, nil, tol, msg+"3")
 
 	// case 4: a ray in dir (-1,3,-5) hitting the sphere at (0, 0.6, 0.8):
-	src = &Vec3{1.7, -4.5, 9.3}
-	dir = &Vec3{-1, 3, -5}
-	ray = &Ray{*src.plus(tr), *(dir.direction())}
+	src = Vec3{1.7, -4.5, 9.3}
+	dir = Vec3{-1, 3, -5}
+	ray = Ray{src.plus(tr), dir.direction()}
 	hit := Vec3{0, 0.6, 0.8}
-	exp = &Intersection{*hit.plus(tr), hit, entry(1.7) * sqrt(entry(35))}
-	assertIntersectionEquals(t, s, ray, true, exp, msg+"4")
+	exp = &Intersection{point: hit.plus(tr), normal: hit, dist: entry(1.7) * sqrt(entry(35))}
+	assertIntersectionEqualsTol(t, s, ray, true, exp, tol, msg+"4")
 }
 
 // tests for a sphere which is translated to (-3,1,2) and scaled by (2,0.5,3)
 func TestIntersectionForTranslatedScaledSphere(t *testing.T) {
-	tr, sc := &Vec3{-3, 1, 2}, &Vec3{2, 0.5, 3}
+	tr, sc := Vec3{-3, 1, 2}, Vec3{2, 0.5, 3}
 	s := NewEllipsoid(sc, tr, &Material{})
 	msg := "Translated Scaled Ray-Sphere intersection "
 
 	// case 0: a ray which is missing the sphere
-	ray := &Ray{ZERO_V3, *X_V3.scale(entry(3))}
+	ray := Ray{ZERO_V3, X_V3.scale(entry(3))}
 	assertIntersectionEquals(t, s, ray, false, nil, msg+"0")
 
 	// case 1: a ray which hits the sphere
-	ray = &Ray{Vec3{-3, 1, -5}, Z_V3}
-	exp := &Intersection{Vec3{-3, 1, -1}, *Z_V3.scale(-ONE), FOUR}
+	ray = Ray{Vec3{-3, 1, -5}, Z_V3}
+	exp := &Intersection{point: Vec3{-3, 1, -1}, normal: Z_V3.scale(-ONE), dist: FOUR}
 	assertIntersectionEquals(t, s, ray, true, exp, msg+"1")
 }
 
-func isIntersectionResultEqual(exp, act *Intersection) bool {
-	return (!exp.dist.neq(act.dist)) &&
-		isMatEqual(exp.point[:], act.point[:], V3LEN) &&
-		isMatEqual(exp.normal[:], act.normal[:], V3LEN)
+// the interval (tMin, tMax) along the ray selects which of the hits is reported
+func TestRayIntervalForSphere(t *testing.T) {
+	s := NewSphere(ONE, ZERO_V3, &Material{})
+	ray := Ray{Vec3{0, 0, -5}, Z_V3} // enters at distance 4, and leaves at 6
+	msg := "Ray interval for sphere "
+
+	hit, res := s.Intersect(ray, ZERO, INF)
+	assert(t, hit && !res.dist.neq(FOUR), msg+fmt.Sprint("1: entry distance ", res.dist))
+	hit, res = s.Intersect(ray, entry(4.5), INF)
+	assert(t, hit && !res.dist.neq(entry(6)), msg+fmt.Sprint("2: exit distance ", res.dist))
+	assert(t, isMatEqual(res.point[:], Z_V3[:], V3LEN) && isMatEqual(res.normal[:], Z_V3[:], V3LEN), msg+fmt.Sprint("2: exit point ", res))
+
+	for i, c := range []struct {
+		tMin, tMax entry
+		exp        bool
+	}{{ZERO, 3.9, false}, {ZERO, 4.1, true}, {4.5, 5.9, false}, {4.5, 6.1, true}, {6.1, INF, false}} {
+		hit, _ := s.Intersect(ray, c.tMin, c.tMax)
+		assert(t, hit == c.exp, msg+fmt.Sprint(3+i, ": Intersect in (", c.tMin, ", ", c.tMax, ")"))
+		assert(t, s.Occluded(ray, c.tMin, c.tMax) == c.exp, msg+fmt.Sprint(3+i, ": Occluded in (", c.tMin, ", ", c.tMax, ")"))
+	}
+}
+
+func TestRayIntervalForQuad(t *testing.T) {
+	q := NewQuad(Vec3{-1, -1, 0}, Vec3{1, -1, 0}, Vec3{1, 1, 0}, Vec3{-1, 1, 0}, &Material{})
+	ray := Ray{Vec3{0.5, 0.5, 3}, Z_V3.scale(-ONE)} // hits at distance 3
+
+	hit, res := q.Intersect(ray, ZERO, INF)
+	assert(t, hit && !res.dist.neq(entry(3)), fmt.Sprint("Ray interval for quad: distance ", res.dist))
+	for i, c := range []struct {
+		tMin, tMax entry
+		exp        bool
+	}{{ZERO, 2.9, false}, {2.9, 3.1, true}, {3.1, INF, false}} {
+		hit, _ := q.Intersect(ray, c.tMin, c.tMax)
+		assert(t, hit == c.exp, fmt.Sprint("Ray interval for quad ", i, ": Intersect in (", c.tMin, ", ", c.tMax, ")"))
+		assert(t, q.Occluded(ray, c.tMin, c.tMax) == c.exp, fmt.Sprint("Ray interval for quad ", i, ": Occluded in (", c.tMin, ", ", c.tMax, ")"))
+	}
+}
+
+func isIntersectionResultEqual(exp, act Intersection, tol float64) bool {
+	return (!exp.dist.neqTol(act.dist, tol)) &&
+		isMatEqualTol(exp.point[:], act.point[:], V3LEN, tol) &&
+		isMatEqualTol(exp.normal[:], act.normal[:], V3LEN, tol)
+}
+
+func assertIntersectionEquals(t *testing.T, shape Shape, ray Ray, expHit bool, expInter *Intersection, msg string) {
+	assertIntersectionEqualsTol(t, shape, ray, expHit, expInter, TOLERANCE, msg)
 }
 
-func assertIntersectionEquals(t *testing.T, sphere *Sphere, ray *Ray, expHit bool, expInter *Intersection, msg string) {
-	hit, res := sphere.Intersect(ray)
+func assertIntersectionEqualsTol(t *testing.T, shape Shape, ray Ray, expHit bool, expInter *Intersection, tol float64, msg string) {
+	hit, res := shape.Intersect(ray, ZERO, INF)
 	passed := assert(t, hit == expHit, msg+fmt.Sprint(": Expected Hit: ", expHit))
+	assert(t, shape.Occluded(ray, ZERO, INF) == expHit, msg+fmt.Sprint(": Expected Occluded: ", expHit))
 	if passed && expHit {
-		assert(t, isIntersectionResultEqual(expInter, res), msg+fmt.Sprint(":\n\t\tExp: ", *expInter, "\n\t\tAct: ", *res))
+		assert(t, isIntersectionResultEqual(*expInter, res, tol), msg+fmt.Sprint(":\n\t\tExp: ", *expInter, "\n\t\tAct: ", res))
 	}
 }
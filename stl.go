@@ -0,0 +1,114 @@
+// stl.go: Contains a reader for STL (stereolithography) meshes, in both ASCII and binary form.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizes of the parts of a binary STL file:
+const (
+	stlHeaderSize   = 80 + 4   // header text, then the number of triangles
+	stlTriangleSize = 12*4 + 2 // normal and 3 vertices (float32 each), then attributes
+	stlASCIIPrefix  = "solid"  // the start of an ASCII STL file
+)
+
+// ReadSTL reads an ASCII or binary STL file into a mesh.
+// STL stores each triangle separately, so identical vertices are merged.
+func ReadSTL(r io.Reader, mat *Material) (*Mesh, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// binary files may also start with "solid", so check whether the size matches first:
+	b := &stlBuilder{index: make(map[Vec3]uint32)}
+	if len(data) >= stlHeaderSize {
+		if n := binary.LittleEndian.Uint32(data[80:]); len(data) == stlHeaderSize+int(n)*stlTriangleSize {
+			b.readBinary(data[stlHeaderSize:], int(n))
+			return b.mesh(mat)
+		}
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte(stlASCIIPrefix)) {
+		return nil, errors.New("stl: not an STL file")
+	}
+	if err := b.readASCII(data); err != nil {
+		return nil, err
+	}
+	return b.mesh(mat)
+}
+
+// collects the (merged) vertices and indices of the triangles
+type stlBuilder struct {
+	vertices []Vec3
+	indices  []uint32
+	index    map[Vec3]uint32 // the index of each distinct vertex
+}
+
+// add a vertex of the current triangle
+func (b *stlBuilder) add(v Vec3) {
+	i, ok := b.index[v]
+	if !ok {
+		i = uint32(len(b.vertices))
+		b.index[v] = i
+		b.vertices = append(b.vertices, v)
+	}
+	b.indices = append(b.indices, i)
+}
+
+func (b *stlBuilder) mesh(mat *Material) (*Mesh, error) {
+	if len(b.indices) == 0 {
+		return nil, errors.New("stl: no triangles")
+	}
+	return NewMesh(b.vertices, nil, nil, nil, b.indices, mat), nil
+}
+
+func (b *stlBuilder) readBinary(data []byte, n int) {
+	f := func(offset int) entry {
+		return entry(math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+	}
+	for t := 0; t < n; t++ {
+		base := t*stlTriangleSize + 12 // skip the normal
+		for v := 0; v < 3; v++ {
+			o := base + 12*v
+			b.add(Vec3{f(o), f(o + 4), f(o + 8)})
+		}
+	}
+}
+
+func (b *stlBuilder) readASCII(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line, count := 0, 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "vertex" {
+			continue // only vertices matter: the facet normals are recomputed
+		}
+		if len(fields) != 4 {
+			return fmt.Errorf("stl: line %d: expected 3 co-ordinates", line)
+		}
+		var v Vec3
+		for i := range v {
+			x, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return fmt.Errorf("stl: line %d: %v", line, err)
+			}
+			v[i] = entry(x)
+		}
+		b.add(v)
+		count++
+	}
+	if count%3 != 0 {
+		return errors.New("stl: incomplete triangle")
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,126 @@
+// transform.go: Contains affine transforms, which keep their inverse
+// (and its transpose) for transforming points, vectors and normals.
+
+package main
+
+// A Transform is an affine transform (as a matrix), along with its inverse and inverse-transpose.
+type Transform struct {
+	m, inv, invTr Mat4
+}
+
+// the transform which does nothing
+var IDENTITY_T = Transform{IDENTITY_M4, IDENTITY_M4, IDENTITY_M4}
+
+// NewTransform creates the transform of an (invertible) affine matrix.
+func NewTransform(m Mat4) Transform {
+	inv := m.inverse()
+	return Transform{m, inv, inv.transpose()}
+}
+
+// build a transform whose inverse is already known (which avoids inverting the matrix)
+func newTransformWithInverse(m, inv Mat4) Transform {
+	return Transform{m, inv, inv.transpose()}
+}
+
+// Translation creates the transform which moves by offset.
+func Translation(offset Vec3) Transform {
+	x, y, z := offset[cX], offset[cY], offset[cZ]
+	return newTransformWithInverse(
+		Mat4{1, 0, 0, x, 0, 1, 0, y, 0, 0, 1, z, 0, 0, 0, 1},
+		Mat4{1, 0, 0, -x, 0, 1, 0, -y, 0, 0, 1, -z, 0, 0, 0, 1},
+	)
+}
+
+// Scaling creates the transform which scales along each axis.
+func Scaling(scale Vec3) Transform {
+	x, y, z := scale[cX], scale[cY], scale[cZ]
+	return newTransformWithInverse(
+		Mat4{x, 0, 0, 0, 0, y, 0, 0, 0, 0, z, 0, 0, 0, 0, 1},
+		Mat4{1 / x, 0, 0, 0, 0, 1 / y, 0, 0, 0, 0, 1 / z, 0, 0, 0, 0, 1},
+	)
+}
+
+// Rotation creates the transform which rotates by a (unit) quaternion.
+func Rotation(q Quaternion) Transform {
+	r := q.toMat3()
+	m := Mat4{
+		r[0], r[1], r[2], 0,
+		r[3], r[4], r[5], 0,
+		r[6], r[7], r[8], 0,
+		0, 0, 0, 1,
+	}
+	// the inverse of a rotation is its transpose (so the inverse-transpose is itself):
+	return Transform{m, m.transpose(), m}
+}
+
+// NewTRS creates the transform which scales, then rotates, then translates.
+func NewTRS(translation Vec3, rotation Quaternion, scale Vec3) Transform {
+	return Translation(translation).times(Rotation(rotation)).times(Scaling(scale))
+}
+
+// the composition t*u: the transform u, followed by t
+func (t Transform) times(u Transform) Transform {
+	return newTransformWithInverse(t.m.times(u.m), u.inv.times(t.inv))
+}
+
+// the composition of t, followed by u (i.e. u*t), for building chains in the order they are applied
+func (t Transform) then(u Transform) Transform {
+	return u.times(t)
+}
+
+func (t Transform) inverse() Transform {
+	return Transform{t.inv, t.m, t.m.transpose()}
+}
+
+// the matrix of the transform
+func (t Transform) matrix() Mat4 {
+	return t.m
+}
+
+// transform a point (which is moved by translations)
+func (t Transform) transformPoint(p Vec3) Vec3 {
+	return toV3(t.m.timesVec(toV4(p, ONE)))
+}
+
+// transform a vector, such as a direction (which is not moved by translations)
+func (t Transform) transformVector(v Vec3) Vec3 {
+	return toV3(t.m.timesVec(toV4(v, ZERO)))
+}
+
+// transform a point by the inverse (using the stored inverse matrix, so nothing is built per call)
+func (t Transform) inverseTransformPoint(p Vec3) Vec3 {
+	return toV3(t.inv.timesVec(toV4(p, ONE)))
+}
+
+// transform a vector by the inverse
+func (t Transform) inverseTransformVector(v Vec3) Vec3 {
+	return toV3(t.inv.timesVec(toV4(v, ZERO)))
+}
+
+// transform a normal, by the inverse-transpose (so that it stays perpendicular to the surface)
+func (t Transform) transformNormal(n Vec3) Vec3 {
+	return toV3(t.invTr.timesVec(toV4(n, ZERO))).direction()
+}
+
+// decompose the transform into a translation, a rotation and a scale (as used by NewTRS).
+// Any shear is lost, and a reflection is returned as a negative scale along x.
+func (t Transform) decompose() (translation Vec3, rotation Quaternion, scale Vec3) {
+	m := t.m
+	translation = Vec3{m[3], m[7], m[11]}
+
+	// the columns of the upper 3x3 are the scaled, rotated axes:
+	cols := [V3LEN]Vec3{{m[0], m[4], m[8]}, {m[1], m[5], m[9]}, {m[2], m[6], m[10]}}
+	scale = Vec3{cols[cX].magnitude(), cols[cY].magnitude(), cols[cZ].magnitude()}
+	if cols[cX].cross(cols[cY]).dot(cols[cZ]) < 0 {
+		scale[cX] = -scale[cX]
+	}
+
+	var rot Mat3
+	for c := 0; c < V3LEN; c++ {
+		for r := 0; r < V3LEN; r++ {
+			rot[r*V3LEN+c] = cols[c][r] / scale[c]
+		}
+	}
+	rotation = quaternionFromMat3(rot)
+	return
+}
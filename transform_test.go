@@ -0,0 +1,78 @@
+// contains tests for transform.go
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTransformPointsVectorsAndNormals(t *testing.T) {
+	trans := NewTRS(Vec3{1, 2, 3}, NewQuaternion(Z_V3, 90), Vec3{2, 1, 1})
+	msg := "Transform TRS"
+
+	// points are scaled, rotated then translated; vectors are not translated:
+	assertVec3Equals(t, Vec3{1, 4, 3}, trans.transformPoint(X_V3), msg+": point")
+	assertVec3Equals(t, Vec3{0, 2, 0}, trans.transformVector(X_V3), msg+": vector")
+	assertVec3Equals(t, Vec3{1, 2, 3}, trans.transformPoint(ZERO_V3), msg+": origin")
+
+	// normals stay perpendicular to the (non-uniformly scaled) surface:
+	tangent, normal := Vec3{1, -1, 0}, Vec3{1, 1, 0}.direction()
+	assert(t, !trans.transformVector(tangent).dot(trans.transformNormal(normal)).neq(ZERO), msg+": normal")
+	assertVec3Equals(t, Vec3{-2, 1, 0}.direction(), trans.transformNormal(normal), msg+": normal direction")
+
+	// the inverse undoes the transform:
+	assertVec3Equals(t, v31, trans.inverse().transformPoint(trans.transformPoint(v31)), msg+": inverse")
+	assertVec3Equals(t, v31, trans.inverseTransformPoint(trans.transformPoint(v31)), msg+": inverse point")
+	assertVec3Equals(t, v31, trans.inverseTransformVector(trans.transformVector(v31)), msg+": inverse vector")
+	id := trans.times(trans.inverse()).matrix()
+	assert(t, isMatEqual(IDENTITY_M4[:], id[:], M4LEN), msg+fmt.Sprint(": t * inverse(t) ", id))
+
+	// the cached inverse matches the matrix inverse:
+	general := NewTransform(m45)
+	assertM4Equals(t, m44, general.inv, "Transform: inverse of a matrix")
+	assertM4Equals(t, m44.transpose(), general.invTr, "Transform: inverse-transpose of a matrix")
+}
+
+func TestTransformComposition(t *testing.T) {
+	scale, rot, move := Scaling(Vec3{1, 2, 3}), Rotation(NewQuaternion(X_V3, 90)), Translation(Vec3{0, 0, 5})
+
+	// a chain, in the order it is applied:
+	chain := scale.then(rot).then(move).then(rot)
+	exp := rot.transformPoint(move.transformPoint(rot.transformPoint(scale.transformPoint(v32))))
+	assertVec3Equals(t, exp, chain.transformPoint(v32), "Transform chain: point")
+	assertVec3Equals(t, v32, chain.inverse().transformPoint(exp), "Transform chain: inverse")
+
+	// times is the same chain, written right-to-left:
+	m := rot.times(move).times(rot).times(scale).matrix()
+	assertM4Equals(t, chain.matrix(), m, "Transform chain: times")
+	assertM4Equals(t, chain.matrix().inverse(), chain.inv, "Transform chain: cached inverse")
+}
+
+func TestTransformDecompose(t *testing.T) {
+	cases := []struct {
+		translation Vec3
+		rotation    Quaternion
+		scale       Vec3
+	}{
+		{Vec3{1, 2, 3}, NewQuaternion(Vec3{1, 2, 3}, 50), Vec3{2, 0.5, 3}},
+		{ZERO_V3, IDENTITY_Q, Vec3{1, 1, 1}},
+		{Vec3{-4, 0, 1}, QuaternionFromEuler(10, 170, -80), Vec3{0.1, 10, 1}},
+		{Vec3{0, 5, 0}, NewQuaternion(Y_V3, 180), Vec3{-2, 1, 1}}, // a reflection
+	}
+	for i, c := range cases {
+		msg := fmt.Sprint("Transform decompose ", i)
+		tr, rot, sc := NewTRS(c.translation, c.rotation, c.scale).decompose()
+		assertVec3Equals(t, c.translation, tr, msg+": translation")
+		assertVec3Equals(t, c.scale, sc, msg+": scale")
+		assert(t, isQuaternionEqual(c.rotation, rot), msg+fmt.Sprint(": rotation ", rot))
+	}
+}
+
+func TestCameraFromTransform(t *testing.T) {
+	trans := NewTRS(Vec3{0, 1, 5}, QuaternionLookAt(Vec3{0, -1, -5}, Y_V3), Vec3{1, 1, 1})
+	cam := NewCameraFromTransform(trans, 320, 240, 45)
+	assertVec3Equals(t, Vec3{0, 1, 5}, cam.pos, "Camera from transform: position")
+	assertVec3Equals(t, Vec3{0, -1, -5}.direction(), cam.lookAt.minus(cam.pos), "Camera from transform: forward")
+	assert(t, cam.up.dot(Y_V3) > 0 && !cam.up.dot(cam.lookAt.minus(cam.pos)).neq(ZERO), fmt.Sprint("Camera from transform: up ", cam.up))
+}
@@ -21,11 +21,29 @@ type Camera struct {
 	fovY            entry // the field-of-view angle, in degrees, along Y-axis.
 }
 
+// NewCameraFromTransform creates a camera placed by a transform (as in modelling tools):
+// the camera is at the origin of the transform, looking along its -Z axis, with +Y up.
+func NewCameraFromTransform(trans Transform, width, height int, fovY entry) *Camera {
+	pos := trans.transformPoint(ZERO_V3)
+	forward := trans.transformVector(Z_V3.scale(-ONE)).direction()
+	up := trans.transformVector(Y_V3).direction()
+	return &Camera{pos, pos.plus(forward), up, width, height, fovY}
+}
+
 // for creating an image
 func NewOutputImage(width, height int) *image.RGBA {
 	return image.NewRGBA(image.Rect(0, 0, width, height))
 }
 
+// create an image from the colours of its pixels, in rows from the top-left
+func colorsImage(width, height int, colors []Vec3) *image.RGBA {
+	img := NewOutputImage(width, height)
+	for i, col := range colors {
+		Set(img, i%width, i/width, col)
+	}
+	return img
+}
+
 // scale a color in [0,1] to the [0,255] range.
 func toRGB(e entry) uint8 {
 	if e >= ONE {
@@ -38,6 +56,6 @@ func toRGB(e entry) uint8 {
 }
 
 // for setting pixels
-func Set(o *image.RGBA, x, y int, col *Vec3) {
-	o.Set(x, y, color.RGBA{toRGB(col[cX]), toRGB(col[cY]), toRGB(col[cZ]), uint8(255)})
+func Set(o *image.RGBA, x, y int, col Vec3) {
+	o.SetRGBA(x, y, color.RGBA{toRGB(col[cX]), toRGB(col[cY]), toRGB(col[cZ]), uint8(255)})
 }
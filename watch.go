@@ -0,0 +1,166 @@
+// watch.go: Contains watch mode, which renders a scene file again whenever it (or one of its meshes) changes,
+// and the cache of meshes which lets it rebuild the scene without rebuilding the meshes which have not changed.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// A MeshCache keeps the meshes (with their bvhs) built for scene files, by the hash of their source
+// (the contents of their file, or their buffers), so that rebuilding a scene only rebuilds the meshes which have changed.
+// The materials of the meshes are not cached, so they may change freely. (it is for building one scene at a time)
+type MeshCache struct {
+	meshes map[[sha256.Size]byte]*Mesh
+	used   map[[sha256.Size]byte]bool // the meshes used by the scene being built
+}
+
+// NewMeshCache creates an empty MeshCache.
+func NewMeshCache() *MeshCache {
+	return &MeshCache{meshes: make(map[[sha256.Size]byte]*Mesh)}
+}
+
+// BuildCached creates the ray tracer, the shapes and the lights of the scene (as Build), reusing the meshes of the cache.
+// Meshes of the cache which the scene no longer uses are dropped from it.
+func (s *SceneFile) BuildCached(cache *MeshCache) (*RayTracer, []Shape, []Light, error) {
+	cache.used = make(map[[sha256.Size]byte]bool)
+	rt, scene, lights, err := s.build(cache)
+	if err == nil {
+		for key := range cache.meshes {
+			if !cache.used[key] {
+				delete(cache.meshes, key)
+			}
+		}
+	}
+	return rt, scene, lights, err
+}
+
+// the mesh of the key, from the cache, or else built (and added to the cache) by build
+// (a nil cache always builds the mesh)
+func (c *MeshCache) mesh(key [sha256.Size]byte, build func() (*Mesh, error)) (*Mesh, error) {
+	if c == nil {
+		return build()
+	}
+	mesh, ok := c.meshes[key]
+	if !ok {
+		var err error
+		if mesh, err = build(); err != nil {
+			return nil, err
+		}
+		c.meshes[key] = mesh
+	}
+	if c.used != nil {
+		c.used[key] = true
+	}
+	return mesh, nil
+}
+
+// the paths of the mesh files of the scene
+func (s *SceneFile) meshFiles() []string {
+	var files []string
+	s.walkShapes(func(shape *sceneShape) error {
+		if shape.File != "" {
+			files = append(files, s.path(shape.File))
+		}
+		return nil
+	})
+	return files
+}
+
+// the time a file was last modified, and its size (both zero if it does not exist)
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// the stamps of the files
+func fileStamps(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{info.ModTime(), info.Size()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+// check if any of the files have changed since their stamps
+func filesChanged(stamps map[string]fileStamp) bool {
+	for file, stamp := range stamps {
+		info, err := os.Stat(file)
+		if err != nil {
+			if stamp != (fileStamp{}) {
+				return true
+			}
+		} else if !info.ModTime().Equal(stamp.modTime) || info.Size() != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchSceneFile renders the scene file at path with render, and then checks (every interval) if the file,
+// or any of its mesh files, has changed: if so, it cancels the render (if it is still running), and renders
+// the scene again, reusing the meshes which have not changed. It stops when ctx is cancelled.
+// Errors loading the scene (e.g. while it is being edited) are passed to onError (if not nil),
+// as are the errors of render (other than for cancelling it), and the scene is loaded again once it changes.
+func WatchSceneFile(ctx context.Context, path string, interval time.Duration,
+	render func(ctx context.Context, rt *RayTracer, scene []Shape, lights []Light) error, onError func(err error)) error {
+
+	report := func(err error) {
+		if err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+	}
+	cache := NewMeshCache()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// (the stamps of the files are taken before they are read, so that any change while reading them is noticed)
+		stamps := fileStamps([]string{path})
+		var stop context.CancelFunc
+		rendered := make(chan struct{})
+		sceneFile, err := LoadSceneFile(path)
+		if err == nil {
+			for file, stamp := range fileStamps(sceneFile.meshFiles()) {
+				stamps[file] = stamp
+			}
+			rt, scene, lights, err := sceneFile.BuildCached(cache)
+			report(err)
+			if err == nil {
+				var renderCtx context.Context
+				renderCtx, stop = context.WithCancel(ctx)
+				go func() {
+					defer close(rendered)
+					if err := render(renderCtx, rt, scene, lights); renderCtx.Err() == nil {
+						report(err)
+					}
+				}()
+			}
+		} else {
+			report(err)
+		}
+
+		// wait for a change (or for ctx to be cancelled), and then stop the render
+		for changed := false; !changed && ctx.Err() == nil; {
+			select {
+			case <-ticker.C:
+				changed = filesChanged(stamps)
+			case <-ctx.Done():
+			}
+		}
+		if stop != nil {
+			stop()
+			<-rendered
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
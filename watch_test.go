@@ -0,0 +1,125 @@
+// contains tests for watch.go
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the meshes of the test scene (the mesh file, and the inline mesh)
+func testSceneMeshes(scene []Shape) (*Mesh, *Mesh) {
+	group := scene[3].(*Group)
+	return group.shapes[0].(*Instance).shape.(*Mesh), group.shapes[1].(*Mesh)
+}
+
+// rebuilding a scene reuses the meshes (and their bvhs) which have not changed, but not their materials
+func TestMeshCache(t *testing.T) {
+	path := writeTestScene(t)
+	cache := NewMeshCache()
+	s, _ := LoadSceneFile(path)
+	_, scene, _, err := s.BuildCached(cache)
+	if !assert(t, err == nil, "MeshCache: build error "+errString(err)) {
+		return
+	}
+	file, inline := testSceneMeshes(scene)
+	assertEquals(t, 2, len(cache.meshes), "MeshCache: number of meshes")
+
+	// a different material
+	s, _ = ParseSceneFile([]byte(strings.Replace(testSceneJSON, `"Diffuse": [0.6, 0.2, 0.2]`, `"Diffuse": [0.2, 0.6, 0.2]`, 1)), filepath.Dir(path))
+	_, scene, _, _ = s.BuildCached(cache)
+	file2, inline2 := testSceneMeshes(scene)
+	assert(t, file.bvh == file2.bvh && inline.bvh == inline2.bvh, "MeshCache: expected the meshes to be reused")
+	assertVec3Equals(t, Vec3{0.2, 0.6, 0.2}, inline2.mat.diffuse, "MeshCache: the material of a reused mesh")
+	assertVec3Equals(t, Vec3{0.6, 0.2, 0.2}, inline.mat.diffuse, "MeshCache: the material of the previous mesh")
+
+	// a different inline mesh, and mesh file
+	os.WriteFile(filepath.Join(filepath.Dir(path), "square.ply"), []byte(strings.Replace(asciiSquarePLY, "1 1 0 0 0 255", "1 1 0 0 255 0", 1)), 0644)
+	s, _ = ParseSceneFile([]byte(strings.Replace(testSceneJSON, `[1, 2, -1]`, `[1, 3, -1]`, 1)), filepath.Dir(path))
+	_, scene, _, _ = s.BuildCached(cache)
+	file3, inline3 := testSceneMeshes(scene)
+	assert(t, file3.bvh != file.bvh && inline3.bvh != inline.bvh, "MeshCache: expected the changed meshes to be rebuilt")
+	assertEquals(t, 2, len(cache.meshes), "MeshCache: the unused meshes are dropped")
+
+	// a scene without the cache builds its own meshes
+	_, scene, _, _ = s.Build()
+	file4, _ := testSceneMeshes(scene)
+	assert(t, file4.bvh != file3.bvh, "MeshCache: expected a new mesh without the cache")
+}
+
+// a render of the watched scene, and its meshes
+type watchedRender struct {
+	ctx    context.Context
+	scene  []Shape
+	lights []Light
+}
+
+// the scene is rendered again when it changes, cancelling the render in progress
+func TestWatchSceneFile(t *testing.T) {
+	path := writeTestScene(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	renders := make(chan watchedRender)
+	errs := make(chan error, 10)
+	done := make(chan error)
+	go func() {
+		done <- WatchSceneFile(ctx, path, 5*time.Millisecond, func(ctx context.Context, rt *RayTracer, scene []Shape, lights []Light) error {
+			renders <- watchedRender{ctx, scene, lights}
+			<-ctx.Done() // (a long render)
+			return ctx.Err()
+		}, func(err error) {
+			errs <- err
+		})
+	}()
+
+	next := func(msg string) watchedRender {
+		select {
+		case r := <-renders:
+			return r
+		case <-time.After(10 * time.Second):
+			t.Fatal("Watch: expected a render after " + msg)
+		}
+		return watchedRender{}
+	}
+	// (the modification times are set explicitly, as the file system may not notice changes so close together)
+	touch := func(file string, data string, age time.Duration) {
+		os.WriteFile(file, []byte(data), 0644)
+		when := time.Now().Add(-age)
+		os.Chtimes(file, when, when)
+	}
+
+	first := next("starting")
+	assertEquals(t, 2, len(first.lights), "Watch: the lights of the scene")
+
+	// changing the scene cancels the first render, and renders it again (with the same meshes)
+	touch(path, strings.Replace(testSceneJSON, `"Color": [0.3, 0.3, 0.3], `, `"Color": [0.3, 0.3, 0.3], "Direction": [0, 1, 0]},{"Type": "point", `, 1), time.Hour)
+	second := next("changing the scene")
+	assert(t, first.ctx.Err() != nil, "Watch: expected the first render to be cancelled")
+	assertEquals(t, 3, len(second.lights), "Watch: the lights of the changed scene")
+	file1, _ := testSceneMeshes(first.scene)
+	file2, _ := testSceneMeshes(second.scene)
+	assert(t, file1.bvh == file2.bvh, "Watch: expected the mesh to be reused")
+
+	// changing a mesh file
+	touch(filepath.Join(filepath.Dir(path), "square.ply"), asciiSquarePLY+"\n", 2*time.Hour)
+	third := next("changing a mesh file")
+	file3, _ := testSceneMeshes(third.scene)
+	assert(t, file3.bvh != file2.bvh, "Watch: expected the changed mesh to be rebuilt")
+
+	// an invalid scene is reported, and then loaded once it is fixed
+	touch(path, "{", 3*time.Hour)
+	select {
+	case err := <-errs:
+		assert(t, strings.Contains(err.Error(), "scene"), "Watch: error "+err.Error())
+	case <-time.After(10 * time.Second):
+		t.Fatal("Watch: expected an error for an invalid scene")
+	}
+	touch(path, testSceneJSON, 4*time.Hour)
+	next("fixing the scene")
+
+	cancel()
+	assertEquals(t, context.Canceled, <-done, "Watch: the error once stopped")
+}